@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jiikko/fdup/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var aliasRegex bool
+
+var aliasCmd = &cobra.Command{
+	Use:   "alias",
+	Short: "Manage code aliases",
+	Long: `Aliases map codes to the canonical code of the same item, such as the codes
+of a vendor that renamed its prefix. Files are grouped and searched under the
+canonical code. Aliases are kept in config.yaml and apply from the next scan.`,
+}
+
+var aliasListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List aliases",
+	Args:  cobra.NoArgs,
+	RunE:  runAliasList,
+}
+
+var aliasAddCmd = &cobra.Command{
+	Use:   "add <from> <to>",
+	Short: "Map a code, or codes matching a regex, to a canonical code",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runAliasAdd,
+}
+
+var aliasRemoveCmd = &cobra.Command{
+	Use:   "remove <from>",
+	Short: "Remove the alias for a code or regex",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAliasRemove,
+}
+
+func init() {
+	aliasAddCmd.Flags().BoolVar(&aliasRegex, "regex", false, "Treat <from> as a regex matching whole codes; <to> may use $1")
+
+	aliasCmd.AddCommand(aliasListCmd)
+	aliasCmd.AddCommand(aliasAddCmd)
+	aliasCmd.AddCommand(aliasRemoveCmd)
+}
+
+// loadAliasConfig finds and loads the config edited by the alias commands.
+func loadAliasConfig() (string, *config.Config) {
+	// Find config directory
+	configDir, err := config.FindConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	// Load config
+	cfg, err := config.Load(configDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid config.yaml:", err)
+		os.Exit(3)
+	}
+	return configDir, cfg
+}
+
+func runAliasList(cmd *cobra.Command, args []string) error {
+	_, cfg := loadAliasConfig()
+	if len(cfg.Aliases) == 0 {
+		if !quiet {
+			fmt.Println("No aliases defined")
+		}
+		return nil
+	}
+	for _, a := range cfg.Aliases {
+		fmt.Println(describeAlias(a))
+	}
+	return nil
+}
+
+func runAliasAdd(cmd *cobra.Command, args []string) error {
+	configDir, cfg := loadAliasConfig()
+
+	alias := config.Alias{From: args[0], To: args[1]}
+	if aliasRegex {
+		alias = config.Alias{Regex: args[0], To: args[1]}
+	}
+	for _, a := range cfg.Aliases {
+		if a.From+a.Regex == args[0] {
+			return fmt.Errorf("an alias for %s already exists", args[0])
+		}
+	}
+	cfg.Aliases = append(cfg.Aliases, alias)
+
+	if _, err := cfg.CodeAliases(); err != nil {
+		return fmt.Errorf("invalid alias: %w", err)
+	}
+	if err := config.AddAlias(configDir, alias); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	if !quiet {
+		fmt.Printf("Added alias %s\n", describeAlias(alias))
+		fmt.Println("Run 'fdup scan' to apply it to the index")
+	}
+	return nil
+}
+
+func runAliasRemove(cmd *cobra.Command, args []string) error {
+	configDir, _ := loadAliasConfig()
+
+	removed, err := config.RemoveAlias(configDir, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	if !removed {
+		return fmt.Errorf("no alias for %s", args[0])
+	}
+
+	if !quiet {
+		fmt.Printf("Removed alias for %s\n", args[0])
+		fmt.Println("Run 'fdup scan' to apply it to the index")
+	}
+	return nil
+}
+
+// describeAlias formats an alias as "from -> to".
+func describeAlias(a config.Alias) string {
+	if a.Regex != "" {
+		return fmt.Sprintf("/%s/ -> %s", a.Regex, a.To)
+	}
+	return fmt.Sprintf("%s -> %s", a.From, a.To)
+}
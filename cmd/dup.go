@@ -1,14 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/config"
 	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/export"
+	"github.com/jiikko/fdup/internal/filter"
+	"github.com/jiikko/fdup/internal/scanner"
 	"github.com/jiikko/fdup/internal/tui"
+	"github.com/jiikko/fdup/internal/verify"
+	"github.com/jiikko/fdup/internal/volume"
 	"github.com/jiikko/fdup/internal/web"
 	"github.com/spf13/cobra"
 )
@@ -18,6 +25,13 @@ var (
 	dryRun      bool
 	useTrash    bool
 	webMode     bool
+	verifyDup   bool
+	dupFormat   string
+	watchDup    bool
+	dupMinSize  string
+	dupMaxSize  string
+	dupExts     []string
+	dupListen   string
 )
 
 var dupCmd = &cobra.Command{
@@ -32,9 +46,34 @@ func init() {
 	dupCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show what would be done without making changes")
 	dupCmd.Flags().BoolVarP(&useTrash, "trash", "t", false, "Move to trash instead of deleting")
 	dupCmd.Flags().BoolVarP(&webMode, "web", "w", false, "Web UI mode")
+	dupCmd.Flags().BoolVar(&verifyDup, "verify", false, "Compare file contents and split groups into identical and different files")
+	dupCmd.Flags().StringVarP(&dupFormat, "format", "f", export.FormatText, "Output format: text, json, ndjson, csv, tsv")
+	dupCmd.Flags().BoolVar(&watchDup, "watch", false, "Keep the index up to date while the web UI runs (requires --web)")
+	dupCmd.Flags().StringVar(&dupMinSize, "min-size", "", "Only consider files of at least this size, e.g. 1MB")
+	dupCmd.Flags().StringVar(&dupMaxSize, "max-size", "", "Only consider files of at most this size, e.g. 4GB")
+	dupCmd.Flags().StringSliceVar(&dupExts, "ext", nil, "Only consider files with these extensions, e.g. mp4,mkv")
+	dupCmd.Flags().StringVar(&dupListen, "listen", "", "Address for the web UI, as host:port or host (default 127.0.0.1, port from 8080; requires --web)")
 }
 
 func runDup(cmd *cobra.Command, args []string) error {
+	structured := dupFormat != export.FormatText
+	if structured && !export.IsSupported(dupFormat) {
+		return fmt.Errorf("unsupported format %q (use text, %s)", dupFormat, strings.Join(export.Formats, ", "))
+	}
+	if structured && (interactive || webMode) {
+		return fmt.Errorf("--format cannot be combined with --interactive or --web")
+	}
+	if watchDup && !webMode {
+		return fmt.Errorf("--watch requires --web")
+	}
+	if dupListen != "" && !webMode {
+		return fmt.Errorf("--listen requires --web")
+	}
+	fileFilter, err := dupFileFilter()
+	if err != nil {
+		return err
+	}
+
 	// Find config directory
 	configDir, err := config.FindConfigDir()
 	if err != nil {
@@ -43,11 +82,12 @@ func runDup(cmd *cobra.Command, args []string) error {
 	}
 
 	// Load config (as per spec flow)
-	_, err = config.Load(configDir)
+	cfg, err := config.Load(configDir)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error: invalid config.yaml:", err)
 		os.Exit(3)
 	}
+	display := codeDisplay(cfg)
 
 	// Open database
 	dbPath := filepath.Join(configDir, config.DBFile)
@@ -58,37 +98,118 @@ func runDup(cmd *cobra.Command, args []string) error {
 	}
 	defer func() { _ = database.Close() }()
 
+	// The server and the watcher share one scanner, so offline roots are
+	// reported once
+	var s *scanner.Scanner
+	if webMode || watchDup {
+		if s, err = newScanner(cfg, configDir, database); err != nil {
+			return err
+		}
+	}
+
+	if watchDup {
+		// Scan before reading the index, then keep it live while the server runs
+		w, err := startWatcher(cfg, s, database, display)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = w.Close() }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := w.Run(ctx); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning: watch stopped:", err)
+			}
+		}()
+	}
+
 	// Check if index is empty
 	count, err := database.GetFileCount()
 	if err != nil {
 		return fmt.Errorf("failed to check index: %w", err)
 	}
-	if count == 0 {
+	if count == 0 && !watchDup {
 		if !quiet {
-			fmt.Println("No files indexed. Run 'fdup scan' first")
+			// Keep stdout a valid document for structured formats
+			if structured {
+				fmt.Fprintln(os.Stderr, "No files indexed. Run 'fdup scan' first")
+			} else {
+				fmt.Println("No files indexed. Run 'fdup scan' first")
+			}
+		}
+		if structured {
+			return export.Write(os.Stdout, dupFormat, nil, display)
 		}
 		return nil
 	}
 
 	// Find duplicates
-	groups, err := database.FindDuplicates()
+	groups, err := database.FindDuplicatesMatching(fileFilter)
 	if err != nil {
 		return fmt.Errorf("failed to find duplicates: %w", err)
 	}
 
-	if len(groups) == 0 {
+	// With --watch the web UI shows duplicates as they appear
+	if len(groups) == 0 && !watchDup {
+		if structured {
+			return export.Write(os.Stdout, dupFormat, nil, display)
+		}
 		if !quiet {
 			fmt.Println("No duplicates found")
 		}
 		return nil
 	}
 
+	if verifyDup {
+		groups, err = verify.Groups(groups, database)
+		if err != nil {
+			return fmt.Errorf("failed to verify duplicates: %w", err)
+		}
+	}
+
 	if interactive {
-		return tui.Run(groups, database, dryRun, useTrash)
+		return tui.Run(groups, database, dryRun, useTrash, cfg.SidecarRules(), display)
 	}
 
 	if webMode {
-		return web.Run(groups, database)
+		// Every configured root, so web actions stay inside them even when
+		// some, or all, are offline
+		configRoots, err := cfg.ScanRoots(configDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: invalid roots:", err)
+			os.Exit(3)
+		}
+		roots := make([]string, len(configRoots))
+		for i, r := range configRoots {
+			roots[i] = r.Path
+		}
+
+		// Scans requested through the API update the index in place
+		return web.Run(groups, database, web.Options{
+			Verify:    verifyDup,
+			Filter:    fileFilter,
+			Sidecars:  cfg.SidecarRules(),
+			Normalize: queryNormalizer(cfg),
+			Display:   display,
+			Scan: func() (*db.SyncResult, error) {
+				sync, _, err := scanIndex(s, database, false, nil)
+				return sync, err
+			},
+			Roots:  roots,
+			Listen: dupListen,
+		})
+	}
+
+	if structured {
+		return export.Write(os.Stdout, dupFormat, groups, display)
+	}
+
+	// Name the root of each copy when several roots share the index
+	showRoots := len(cfg.Roots) > 1
+	offline, err := volume.Offline(database)
+	if err != nil {
+		return fmt.Errorf("failed to read volumes: %w", err)
 	}
 
 	// Basic text output
@@ -97,9 +218,18 @@ func runDup(cmd *cobra.Command, args []string) error {
 		if len(group.Files) == 1 {
 			fileWord = "file"
 		}
-		fmt.Printf("%s: %d %s\n", code.Format(group.Code), len(group.Files), fileWord)
-		for _, f := range group.Files {
-			fmt.Printf("  %s (%s)\n", f.Path, formatSize(f.Size))
+		fmt.Printf("%s: %d %s\n", display.Format(group.Code), len(group.Files), fileWord)
+		if group.Verified() {
+			for _, sg := range group.Subgroups {
+				fmt.Printf("  %s:\n", contentLabel(sg))
+				for _, f := range sg.Files {
+					fmt.Printf("    %s\n", fileLine(f, group.Code, display, showRoots, offline))
+				}
+			}
+		} else {
+			for _, f := range group.Files {
+				fmt.Printf("  %s\n", fileLine(f, group.Code, display, showRoots, offline))
+			}
 		}
 		fmt.Println()
 	}
@@ -107,6 +237,79 @@ func runDup(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// dupFileFilter builds the filter of the --min-size, --max-size and --ext
+// flags.
+func dupFileFilter() (db.FileFilter, error) {
+	var f db.FileFilter
+	if dupMinSize != "" {
+		size, err := filter.ParseSize(dupMinSize)
+		if err != nil {
+			return f, fmt.Errorf("--min-size: %w", err)
+		}
+		f.MinSize = int64(size)
+	}
+	if dupMaxSize != "" {
+		size, err := filter.ParseSize(dupMaxSize)
+		if err != nil {
+			return f, fmt.Errorf("--max-size: %w", err)
+		}
+		f.MaxSize = int64(size)
+	}
+	f.Extensions = dupExts
+	return f, nil
+}
+
+// fileLine describes a file of the duplicate group for groupCode, optionally
+// with its root. A code reached through an alias and the other codes of a
+// file with several codes are listed, formatted with display, and files on
+// the volumes in offline, which maps UUIDs to names, are marked.
+func fileLine(f db.FileRecord, groupCode string, display *code.Display, showRoot bool, offline map[string]string) string {
+	line := fmt.Sprintf("%s (%s)", f.Path, formatSize(f.Size))
+	if aliasOf, ok := f.AliasOf[groupCode]; ok {
+		line += fmt.Sprintf(" [matched via alias %s]", display.Format(aliasOf))
+	}
+	if others := otherCodes(f, groupCode, display); len(others) > 0 {
+		line += fmt.Sprintf(" [also: %s]", strings.Join(others, ", "))
+	}
+	if showRoot && f.Root != "" {
+		line += fmt.Sprintf(" [root: %s]", f.Root)
+	}
+	if name, ok := offline[f.Volume]; ok {
+		line += fmt.Sprintf(" [offline volume %s]", name)
+	}
+	return line
+}
+
+// codeDisplay returns the display format of codes in the config.
+func codeDisplay(cfg *config.Config) *code.Display {
+	display, err := cfg.CodeDisplay()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid display:", err)
+		os.Exit(3)
+	}
+	return display
+}
+
+// otherCodes returns the codes of f other than groupCode, formatted with
+// display.
+func otherCodes(f db.FileRecord, groupCode string, display *code.Display) []string {
+	var others []string
+	for _, c := range f.Codes {
+		if c != groupCode {
+			others = append(others, display.Format(c))
+		}
+	}
+	return others
+}
+
+// contentLabel describes a content subgroup of a verified group.
+func contentLabel(sg db.ContentGroup) string {
+	if sg.Identical {
+		return "identical content"
+	}
+	return "same code, different content"
+}
+
 func formatSize(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jiikko/fdup/internal/config"
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/fileops"
+	"github.com/jiikko/fdup/internal/resolve"
+	"github.com/jiikko/fdup/internal/verify"
+	"github.com/jiikko/fdup/internal/volume"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keepRule       string
+	tieBreakRules  []string
+	preferredDirs  []string
+	skipTies       bool
+	resolveDryRun  bool
+	resolveTrash   bool
+	resolveVerify  bool
+	resolveConfirm bool
+)
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Resolve every duplicate group automatically",
+	Long: `Applies a keep rule to every duplicate group, keeping one file per group
+and removing the others. The plan is printed before anything is changed.
+
+Rules: newest, oldest, largest, smallest, shortest-path, longest-path,
+preferred (first file under a --prefer directory, in the order given).
+
+Groups where the rules leave several equally ranked files are ties. fdup refuses
+to act while ties remain unless --tie-break resolves them or --skip-ties is set.`,
+	RunE: runResolve,
+}
+
+func init() {
+	resolveCmd.Flags().StringVarP(&keepRule, "keep", "k", "", "Rule selecting the file to keep (required)")
+	resolveCmd.Flags().StringSliceVar(&tieBreakRules, "tie-break", nil, "Rules applied in order when --keep ends in a tie")
+	resolveCmd.Flags().StringArrayVar(&preferredDirs, "prefer", nil, "Preferred directory for the preferred rule (repeatable, earlier wins)")
+	resolveCmd.Flags().BoolVar(&skipTies, "skip-ties", false, "Leave tied groups untouched instead of refusing")
+	resolveCmd.Flags().BoolVarP(&resolveDryRun, "dry-run", "n", false, "Show what would be done without making changes")
+	resolveCmd.Flags().BoolVarP(&resolveTrash, "trash", "t", false, "Move to trash instead of deleting")
+	resolveCmd.Flags().BoolVar(&resolveVerify, "verify", false, "Only remove files whose content is identical to the kept file")
+	resolveCmd.Flags().BoolVarP(&resolveConfirm, "yes", "y", false, "Do not ask for confirmation")
+	_ = resolveCmd.MarkFlagRequired("keep")
+}
+
+func runResolve(cmd *cobra.Command, args []string) error {
+	var rules []resolve.Rule
+	for _, name := range append([]string{keepRule}, tieBreakRules...) {
+		rule, err := resolve.ParseRule(name)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	resolver, err := resolve.New(rules, preferredDirs)
+	if err != nil {
+		return err
+	}
+
+	// Find config directory
+	configDir, err := config.FindConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	// Load config
+	cfg, err := config.Load(configDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid config.yaml:", err)
+		os.Exit(3)
+	}
+	display := codeDisplay(cfg)
+
+	// Open database
+	dbPath := filepath.Join(configDir, config.DBFile)
+	database, err := db.Open(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to open database:", err)
+		os.Exit(4)
+	}
+	defer func() { _ = database.Close() }()
+
+	groups, err := database.FindDuplicates()
+	if err != nil {
+		return fmt.Errorf("failed to find duplicates: %w", err)
+	}
+	if len(groups) == 0 {
+		if !quiet {
+			fmt.Println("No duplicates found")
+		}
+		return nil
+	}
+
+	if resolveVerify {
+		groups, err = verify.Groups(groups, database)
+		if err != nil {
+			return fmt.Errorf("failed to verify duplicates: %w", err)
+		}
+	}
+
+	// Files on disconnected volumes are never kept or removed
+	offline, err := volume.Offline(database)
+	if err != nil {
+		return fmt.Errorf("failed to read volumes: %w", err)
+	}
+	resolver.SetOffline(offline)
+
+	plans := resolver.PlanAll(groups)
+	// Sidecars go with the files removed
+	ops := fileops.New(database)
+	ops.SetSidecars(cfg.SidecarRules())
+
+	// Print the plan
+	var actionable, tied []resolve.Plan
+	removeCount := 0
+	for _, plan := range plans {
+		if plan.Ambiguous() {
+			tied = append(tied, plan)
+			continue
+		}
+		actionable = append(actionable, plan)
+		removeCount += len(plan.Remove)
+	}
+
+	if !quiet {
+		sidecars := ops.SidecarCache()
+		for _, plan := range actionable {
+			fmt.Printf("%s:\n", display.Format(plan.Code))
+			fmt.Printf("  keep    %s\n", plan.Keep.Path)
+			for _, f := range plan.Remove {
+				fmt.Printf("  remove  %s\n", f.Path)
+				found, _ := sidecars.Of(f.Path)
+				for _, sc := range found {
+					fmt.Printf("          + %s\n", sc)
+				}
+			}
+		}
+	}
+	for _, plan := range tied {
+		fmt.Printf("%s: tie between %d files\n", display.Format(plan.Code), len(plan.Tied))
+		for _, f := range plan.Tied {
+			fmt.Printf("  ?       %s\n", f.Path)
+		}
+	}
+
+	if len(tied) > 0 && !skipTies {
+		return fmt.Errorf("%s tied; add --tie-break rules or use --skip-ties", plural(len(tied), "group"))
+	}
+
+	verb := "delete"
+	if resolveTrash {
+		verb = "trash"
+	}
+	if removeCount == 0 {
+		if !quiet {
+			fmt.Println("Nothing to remove")
+		}
+		return nil
+	}
+
+	if resolveDryRun {
+		fmt.Printf("[DRY-RUN] Would %s %s in %s\n", verb, plural(removeCount, "file"), plural(len(actionable), "group"))
+		return nil
+	}
+
+	if !resolveConfirm {
+		fmt.Printf("%s %s in %s? [y/N] ", strings.ToUpper(verb[:1])+verb[1:], plural(removeCount, "file"), plural(len(actionable), "group"))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+
+	removed := 0
+	var failures []error
+	for _, plan := range actionable {
+		for _, f := range plan.Remove {
+			if err := ops.Remove(f.Path, resolveTrash); err != nil {
+				failures = append(failures, fmt.Errorf("%s: %w", f.Path, err))
+				continue
+			}
+			removed++
+		}
+	}
+
+	if !quiet {
+		word := "Deleted"
+		if resolveTrash {
+			word = "Trashed"
+		}
+		fmt.Printf("%s %s\n", word, plural(removed, "file"))
+	}
+	if len(failures) > 0 {
+		for _, e := range failures {
+			fmt.Fprintf(os.Stderr, "  - %v\n", e)
+		}
+		return fmt.Errorf("failed to remove %s", plural(len(failures), "file"))
+	}
+
+	return nil
+}
+
+// plural formats a count with a singular or plural noun.
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
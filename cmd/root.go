@@ -48,4 +48,9 @@ func init() {
 	rootCmd.AddCommand(testCmd)
 	rootCmd.AddCommand(searchCmd)
 	rootCmd.AddCommand(dupCmd)
+	rootCmd.AddCommand(resolveCmd)
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(volumeCmd)
+	rootCmd.AddCommand(aliasCmd)
 }
@@ -4,28 +4,37 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	"github.com/jiikko/fdup/internal/config"
 	"github.com/jiikko/fdup/internal/db"
 	"github.com/jiikko/fdup/internal/scanner"
+	"github.com/jiikko/fdup/internal/volume"
 	"github.com/spf13/cobra"
 )
 
 var (
 	showProgress bool
 	dropDB       bool
+	fullScan     bool
+	scanJobs     int
 )
 
 var scanCmd = &cobra.Command{
 	Use:   "scan",
 	Short: "Scan files and update the index",
-	Long:  `Scans the current directory recursively and indexes files matching patterns.`,
-	RunE:  runScan,
+	Long: `Scans the roots recursively and indexes files matching patterns.
+The roots are listed in config.yaml; by default the directory containing
+.fdup is the only root. Only new, changed and removed files are applied to
+the index unless --full is given.`,
+	RunE: runScan,
 }
 
 func init() {
 	scanCmd.Flags().BoolVarP(&showProgress, "progress", "p", false, "Show progress bar")
 	scanCmd.Flags().BoolVarP(&dropDB, "drop", "d", false, "Drop and recreate database")
+	scanCmd.Flags().BoolVar(&fullScan, "full", false, "Clear the index and re-index every file")
+	scanCmd.Flags().IntVarP(&scanJobs, "jobs", "j", runtime.NumCPU(), "Number of concurrent workers")
 }
 
 func runScan(cmd *cobra.Command, args []string) error {
@@ -51,8 +60,11 @@ func runScan(cmd *cobra.Command, args []string) error {
 		if !quiet {
 			fmt.Println("Dropping database...")
 		}
-		if err := os.Remove(dbPath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove database: %w", err)
+		// Include the WAL files so no stale pages are replayed
+		for _, path := range []string{dbPath, dbPath + "-wal", dbPath + "-shm"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove database: %w", err)
+			}
 		}
 	}
 
@@ -70,24 +82,18 @@ func runScan(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if !quiet {
-		fmt.Println("Clearing index...")
-	}
-
-	// Clear existing index (always full re-index)
-	if err := database.Clear(); err != nil {
-		return fmt.Errorf("failed to clear index: %w", err)
-	}
-
-	// Get root directory (parent of .fdup)
-	rootDir := filepath.Dir(configDir)
-
 	// Create scanner
-	s, err := scanner.New(cfg.GetPatternRegexes(), cfg.Ignore, rootDir)
+	s, err := newScanner(cfg, configDir, database)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error: invalid patterns:", err)
-		os.Exit(3)
+		return err
 	}
+	if len(s.Roots()) == 0 {
+		if !quiet {
+			fmt.Println("No roots are online; the index was not changed")
+		}
+		return nil
+	}
+	s.SetJobs(scanJobs)
 
 	if !quiet {
 		fmt.Println("Scanning...")
@@ -103,28 +109,26 @@ func runScan(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// Scan files
-	records, result, err := s.Scan(progressFn)
-	if err != nil {
-		return fmt.Errorf("scan failed: %w", err)
-	}
+	// A full scan clears and inserts in one transaction so a failure keeps
+	// the old index; otherwise only the differences are applied
+	rebuild := fullScan || dropDB
+	sync, result, err := scanIndex(s, database, rebuild, progressFn)
 
 	if showProgress && !quiet {
 		fmt.Fprintln(os.Stderr) // New line after progress bar
 	}
-
-	// Insert records
-	for _, rec := range records {
-		if err := database.InsertFile(rec); err != nil {
-			if verbose {
-				fmt.Fprintf(os.Stderr, "Warning: failed to insert %s: %v\n", rec.Path, err)
-			}
-		}
+	if err != nil {
+		return err
 	}
 
 	if !quiet {
 		fmt.Printf("Found %d files\n", result.TotalFiles)
-		fmt.Printf("Added %d new records\n", result.AddedFiles)
+		if rebuild {
+			fmt.Printf("Added %d new records\n", result.AddedFiles)
+		} else {
+			fmt.Printf("Added %d, updated %d, removed %d records (%d unchanged)\n",
+				sync.Added, sync.Updated, sync.Removed, sync.Unchanged)
+		}
 	}
 
 	if verbose && len(result.Errors) > 0 {
@@ -137,6 +141,153 @@ func runScan(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// scanIndex scans with s and writes each record to the index as soon as it
+// is built, so records are never all held in memory. With rebuild the
+// records of the scanned roots are replaced, otherwise the index is synced.
+// Nothing is committed unless both the scan and every write succeed, and
+// nothing is scanned when no root is online, as no records could be kept.
+func scanIndex(s *scanner.Scanner, database *db.DB, rebuild bool, progress scanner.ProgressFunc) (*db.SyncResult, *scanner.ScanResult, error) {
+	if len(s.Roots()) == 0 {
+		return nil, nil, fmt.Errorf("no roots are online")
+	}
+	begin := database.BeginSync
+	if rebuild {
+		begin = database.BeginReplace
+	}
+	batch, err := begin(s.RootPaths())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update index: %w", err)
+	}
+
+	records := make(chan db.FileRecord, 256)
+	var result *scanner.ScanResult
+	var scanErr error
+	done := make(chan struct{})
+	go func() {
+		result, scanErr = s.Stream(progress, records)
+		close(done)
+	}()
+
+	// Keep receiving after a failed write so the scan can finish
+	var writeErr error
+	for rec := range records {
+		if writeErr == nil {
+			writeErr = batch.Add(rec)
+		}
+	}
+	<-done
+
+	if scanErr != nil {
+		batch.Rollback()
+		return nil, nil, fmt.Errorf("scan failed: %w", scanErr)
+	}
+	if writeErr != nil {
+		batch.Rollback()
+		return nil, nil, fmt.Errorf("failed to update index: %w", writeErr)
+	}
+	sync, err := batch.Commit()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update index: %w", err)
+	}
+	return sync, result, nil
+}
+
+// newScanner creates a scanner for the online roots in the config, or for
+// the directory containing .fdup when no roots are configured. Roots that are
+// missing or whose volume is not mounted are reported and left out, so their
+// records stay in the index. Volumes of online roots are recorded.
+func newScanner(cfg *config.Config, configDir string, database *db.DB) (*scanner.Scanner, error) {
+	configRoots, err := cfg.ScanRoots(configDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid roots:", err)
+		os.Exit(3)
+	}
+
+	var roots []scanner.Root
+	for _, r := range configRoots {
+		root := scanner.Root{Path: r.Path, Ignore: r.Ignore}
+
+		previous, err := database.RootVolume(r.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(r.Path); err != nil {
+			if err := reportOffline(database, r.Path, previous); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		vol, err := volume.Find(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		if vol == nil {
+			// An empty mount point of a volume seen before
+			if previous != "" {
+				if err := reportOffline(database, r.Path, previous); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		} else {
+			if err := database.SaveVolume(vol.UUID, vol.Name, vol.Path); err != nil {
+				return nil, fmt.Errorf("failed to record volume: %w", err)
+			}
+			root.Volume = vol.UUID
+		}
+		roots = append(roots, root)
+	}
+
+	s, err := scanner.New(cfg.CodePatterns(), cfg.Ignore, roots)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid patterns:", err)
+		os.Exit(3)
+	}
+	aliases, err := cfg.CodeAliases()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid aliases:", err)
+		os.Exit(3)
+	}
+	s.SetAliases(aliases)
+	s.SetFilter(cfg.ScanFilter())
+	s.SetSidecars(cfg.SidecarRules())
+	return s, nil
+}
+
+// reportOffline tells the user that a root is skipped and its records kept.
+func reportOffline(database *db.DB, root, volumeUUID string) error {
+	if quiet {
+		return nil
+	}
+	count, err := database.RootFileCount(root)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if volumeUUID != "" {
+		name = volumeUUID
+		volumes, err := database.Volumes()
+		if err != nil {
+			return err
+		}
+		for _, v := range volumes {
+			if v.UUID == volumeUUID {
+				name = v.Name
+			}
+		}
+	}
+
+	if name != "" {
+		fmt.Printf("Volume %s is offline; keeping %s under %s\n", name, plural(count, "record"), root)
+	} else {
+		fmt.Printf("Root %s not found; keeping %s\n", root, plural(count, "record"))
+	}
+	return nil
+}
+
 func makeProgressBar(pct float64, width int) string {
 	filled := int(pct / 100 * float64(width))
 	bar := make([]byte, width)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/config"
@@ -40,6 +41,14 @@ func runSearch(cmd *cobra.Command, args []string) error {
 		os.Exit(2)
 	}
 
+	// Load config
+	cfg, err := config.Load(configDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid config.yaml:", err)
+		os.Exit(3)
+	}
+	display := codeDisplay(cfg)
+
 	// Open database
 	dbPath := filepath.Join(configDir, config.DBFile)
 	database, err := db.Open(dbPath)
@@ -49,8 +58,8 @@ func runSearch(cmd *cobra.Command, args []string) error {
 	}
 	defer func() { _ = database.Close() }()
 
-	// Normalize query
-	normalizedQuery := code.Normalize(query)
+	// Normalize query the way codes are normalized when scanning
+	normalizedQuery := queryNormalizer(cfg)(query)
 
 	// Search
 	groups, err := database.SearchByCode(normalizedQuery, exactMatch)
@@ -66,20 +75,20 @@ func runSearch(cmd *cobra.Command, args []string) error {
 	}
 
 	if jsonOutput {
-		return outputJSON(groups)
+		return outputJSON(groups, display)
 	}
 
-	return outputText(groups)
+	return outputText(groups, display)
 }
 
-func outputJSON(groups []db.DuplicateGroup) error {
+func outputJSON(groups []db.DuplicateGroup, display *code.Display) error {
 	for _, group := range groups {
 		paths := make([]string, len(group.Files))
 		for i, f := range group.Files {
 			paths[i] = f.Path
 		}
 		data := map[string]interface{}{
-			"code":  code.Format(group.Code),
+			"code":  display.Format(group.Code),
 			"files": paths,
 		}
 		b, err := json.Marshal(data)
@@ -91,16 +100,41 @@ func outputJSON(groups []db.DuplicateGroup) error {
 	return nil
 }
 
-func outputText(groups []db.DuplicateGroup) error {
+func outputText(groups []db.DuplicateGroup, display *code.Display) error {
 	for _, group := range groups {
 		fileWord := "files"
 		if len(group.Files) == 1 {
 			fileWord = "file"
 		}
-		fmt.Printf("%s: %d %s\n", code.Format(group.Code), len(group.Files), fileWord)
+		fmt.Printf("%s: %d %s\n", display.Format(group.Code), len(group.Files), fileWord)
 		for _, f := range group.Files {
+			if others := otherCodes(f, group.Code, display); len(others) > 0 {
+				fmt.Printf("  %s [also: %s]\n", f.Path, strings.Join(others, ", "))
+				continue
+			}
 			fmt.Printf("  %s\n", f.Path)
 		}
 	}
 	return nil
 }
+
+// queryNormalizer returns a function turning a code typed by the user into
+// the code it is indexed under: normalized, then resolved through aliases.
+func queryNormalizer(cfg *config.Config) func(string) string {
+	normalizer, err := code.NewNormalizer(cfg.Normalization())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid normalize:", err)
+		os.Exit(3)
+	}
+
+	// Aliased codes are indexed under their canonical code
+	aliases, err := cfg.CodeAliases()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid aliases:", err)
+		os.Exit(3)
+	}
+	return func(query string) string {
+		canonical, _ := aliases.Resolve(normalizer.Normalize(query))
+		return canonical
+	}
+}
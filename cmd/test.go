@@ -3,9 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/config"
-	"github.com/jiikko/fdup/internal/scanner"
 	"github.com/spf13/cobra"
 )
 
@@ -36,12 +37,17 @@ func runTest(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	// Create scanner to use its extractor
-	s, err := scanner.New(cfg.GetPatternRegexes(), nil, ".")
+	// Templates are checked when the extractor is created
+	extractor, err := code.NewExtractor(cfg.CodePatterns())
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error: invalid patterns:", err)
 		os.Exit(3)
 	}
+	aliases, err := cfg.CodeAliases()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid aliases:", err)
+		os.Exit(3)
+	}
 
 	if !quiet {
 		fmt.Println("Testing patterns...")
@@ -51,7 +57,16 @@ func runTest(cmd *cobra.Command, args []string) error {
 	failed := 0
 
 	for _, tc := range cfg.Test {
-		extracted, found := s.ExtractCode(tc.Input)
+		// Inputs are relative paths; a trailing slash tests a directory
+		var match code.Match
+		var found bool
+		if strings.HasSuffix(tc.Input, "/") {
+			match, found = extractor.MatchDir(strings.TrimSuffix(tc.Input, "/"))
+		} else {
+			match, found = extractor.Match(tc.Input)
+		}
+		// Expected codes are canonical, as they are indexed
+		extracted, aliased := aliases.Resolve(match.Code)
 		var result string
 		var ok bool
 
@@ -75,6 +90,14 @@ func runTest(cmd *cobra.Command, args []string) error {
 			}
 		}
 
+		// Show which pattern, and template, produced the code
+		if found && verbose {
+			result += " " + describePattern(match.Pattern)
+			if aliased {
+				result += fmt.Sprintf(" (via alias from %s)", match.Code)
+			}
+		}
+
 		if ok {
 			passed++
 			if !quiet {
@@ -98,3 +121,18 @@ func runTest(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// describePattern names a pattern and its template for test output.
+func describePattern(p code.Pattern) string {
+	name := p.Name
+	if name == "" {
+		name = p.Regex
+	}
+	if p.Target != "" && p.Target != code.TargetFilename {
+		name += " on " + p.Target
+	}
+	if p.Template != "" {
+		return fmt.Sprintf("(%s: %s)", name, p.Template)
+	}
+	return fmt.Sprintf("(%s)", name)
+}
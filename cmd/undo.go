@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jiikko/fdup/internal/config"
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/fileops"
+	"github.com/spf13/cobra"
+)
+
+var (
+	undoSession string
+	undoList    bool
+	undoDryRun  bool
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Undo file operations recorded in the journal",
+	Long: `Reverses the moves and trash operations of a session recorded by dup -i,
+dup --web and resolve. Moved files are moved back and trashed files are
+restored, and the index is updated. Permanently deleted files cannot be restored.
+
+Without --session, the most recent session with operations left to undo is used.`,
+	RunE: runUndo,
+}
+
+func init() {
+	undoCmd.Flags().StringVarP(&undoSession, "session", "s", "", "Session ID to undo")
+	undoCmd.Flags().BoolVarP(&undoList, "list", "l", false, "List recorded sessions")
+	undoCmd.Flags().BoolVarP(&undoDryRun, "dry-run", "n", false, "Show what would be done without making changes")
+}
+
+func runUndo(cmd *cobra.Command, args []string) error {
+	// Find config directory
+	configDir, err := config.FindConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	// Open database
+	dbPath := filepath.Join(configDir, config.DBFile)
+	database, err := db.Open(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to open database:", err)
+		os.Exit(4)
+	}
+	defer func() { _ = database.Close() }()
+
+	sessions, err := database.JournalSessions()
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	if undoList {
+		if len(sessions) == 0 {
+			if !quiet {
+				fmt.Println("No operations recorded")
+			}
+			return nil
+		}
+		for _, s := range sessions {
+			fmt.Printf("%s  %s  %s", s.ID, s.StartedAt.Local().Format("2006-01-02 15:04:05"), plural(s.Entries, "operation"))
+			if s.Undone > 0 {
+				fmt.Printf(" (%d undone)", s.Undone)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+
+	sessionID := undoSession
+	if sessionID == "" {
+		for _, s := range sessions {
+			if s.Undoable() > 0 {
+				sessionID = s.ID
+				break
+			}
+		}
+		if sessionID == "" {
+			if !quiet {
+				fmt.Println("Nothing to undo")
+			}
+			return nil
+		}
+	}
+
+	if !quiet {
+		entries, err := database.JournalEntries(sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to read journal: %w", err)
+		}
+		prefix := ""
+		if undoDryRun {
+			prefix = "[DRY-RUN] Would "
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if e.Undone {
+				continue
+			}
+			switch e.Action {
+			case db.ActionMove:
+				fmt.Printf("%smove back: %s -> %s\n", prefix, e.Destination, e.Source)
+			case db.ActionTrash:
+				fmt.Printf("%srestore: %s\n", prefix, e.Source)
+			}
+		}
+	}
+
+	result, err := fileops.Undo(database, sessionID, undoDryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range result.Skipped {
+		fmt.Fprintf(os.Stderr, "Skipped: %s was deleted permanently and cannot be restored\n", e.Source)
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+	}
+
+	if !quiet && !undoDryRun {
+		fmt.Printf("Undid %s from session %s\n", plural(result.Restored, "operation"), sessionID)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("failed to undo %s", plural(len(result.Errors), "operation"))
+	}
+	return nil
+}
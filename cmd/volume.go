@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jiikko/fdup/internal/config"
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/volume"
+	"github.com/spf13/cobra"
+)
+
+var volumeName string
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manage named volumes",
+	Long: `Named volumes are disks or shares that may be disconnected. Records of files
+on a volume are kept while it is offline, and dup marks them as such.
+A volume is named by a ` + volume.MarkerFile + ` file at its top directory.`,
+}
+
+var volumeInitCmd = &cobra.Command{
+	Use:   "init <dir>",
+	Short: "Name the volume mounted at a directory",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runVolumeInit,
+}
+
+var volumeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List known volumes",
+	Args:  cobra.NoArgs,
+	RunE:  runVolumeList,
+}
+
+func init() {
+	volumeInitCmd.Flags().StringVar(&volumeName, "name", "", "Volume name (default: directory name)")
+
+	volumeCmd.AddCommand(volumeInitCmd)
+	volumeCmd.AddCommand(volumeListCmd)
+}
+
+func runVolumeInit(cmd *cobra.Command, args []string) error {
+	name := volumeName
+	if name == "" {
+		abs, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		name = filepath.Base(abs)
+	}
+
+	v, err := volume.Create(args[0], name)
+	if err != nil {
+		return fmt.Errorf("failed to create volume: %w", err)
+	}
+
+	if !quiet {
+		fmt.Printf("Created volume %s at %s\n", v.Name, v.Path)
+		fmt.Println("Run 'fdup scan' with the volume mounted to index it")
+	}
+	return nil
+}
+
+func runVolumeList(cmd *cobra.Command, args []string) error {
+	// Find config directory
+	configDir, err := config.FindConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	// Open database
+	dbPath := filepath.Join(configDir, config.DBFile)
+	database, err := db.Open(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to open database:", err)
+		os.Exit(4)
+	}
+	defer func() { _ = database.Close() }()
+
+	volumes, err := database.Volumes()
+	if err != nil {
+		return fmt.Errorf("failed to read volumes: %w", err)
+	}
+	if len(volumes) == 0 {
+		if !quiet {
+			fmt.Println("No volumes scanned")
+		}
+		return nil
+	}
+
+	for _, v := range volumes {
+		status := "online"
+		if !volume.Online(v.Path, v.UUID) {
+			status = "offline"
+		}
+		fmt.Printf("%s  %s  %s  %s  last seen %s\n",
+			v.Name, status, plural(v.Files, "file"), v.Path, v.LastSeen.Local().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
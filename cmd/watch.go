@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/jiikko/fdup/internal/code"
+	"github.com/jiikko/fdup/internal/config"
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/scanner"
+	"github.com/jiikko/fdup/internal/volume"
+	"github.com/jiikko/fdup/internal/watch"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Keep the index up to date and report new duplicates",
+	Long: `Scans the roots like 'fdup scan', then watches them and applies
+created, renamed and deleted files to the index as they happen.
+Duplicates are printed as soon as a new file joins a duplicate group.
+
+Watching is supported on Linux (inotify).`,
+	RunE: runWatch,
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	// Find config directory
+	configDir, err := config.FindConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	// Load config
+	cfg, err := config.Load(configDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: invalid config.yaml:", err)
+		os.Exit(3)
+	}
+	display := codeDisplay(cfg)
+
+	// Open database
+	dbPath := filepath.Join(configDir, config.DBFile)
+	database, err := db.Open(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to open database:", err)
+		os.Exit(4)
+	}
+	defer func() { _ = database.Close() }()
+
+	s, err := newScanner(cfg, configDir, database)
+	if err != nil {
+		return err
+	}
+	w, err := startWatcher(cfg, s, database, display)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = w.Close() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if !quiet {
+		fmt.Println("Watching for changes. Press Ctrl+C to stop")
+	}
+	return w.Run(ctx)
+}
+
+// startWatcher creates a watcher for the online roots of s, reporting changes
+// on stdout, and brings the index up to date.
+func startWatcher(cfg *config.Config, s *scanner.Scanner, database *db.DB, display *code.Display) (*watch.Watcher, error) {
+	if len(s.Roots()) == 0 {
+		return nil, fmt.Errorf("no roots are online")
+	}
+	w, err := watch.New(s, database)
+	if err != nil {
+		return nil, err
+	}
+	w.OnIndex = func(rec db.FileRecord) {
+		if verbose {
+			fmt.Printf("Indexed %s\n", rec.Path)
+		}
+	}
+	w.OnRemove = func(path string) {
+		if verbose {
+			fmt.Printf("Removed %s\n", path)
+		}
+	}
+	w.OnDuplicate = func(group db.DuplicateGroup, rec db.FileRecord) {
+		if quiet {
+			return
+		}
+		offline, err := volume.Offline(database)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning:", err)
+		}
+		fmt.Printf("Duplicate: %s: %s\n", display.Format(group.Code), plural(len(group.Files), "file"))
+		for _, f := range group.Files {
+			marker := " "
+			if f.Path == rec.Path {
+				marker = "+"
+			}
+			fmt.Printf("%s %s\n", marker, fileLine(f, group.Code, display, len(cfg.Roots) > 1, offline))
+		}
+		fmt.Println()
+	}
+	w.OnError = func(err error) {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	}
+
+	if !quiet {
+		fmt.Println("Scanning...")
+	}
+	sync, err := w.Start()
+	if err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	if !quiet {
+		fmt.Printf("Added %d, updated %d, removed %d records (%d unchanged)\n",
+			sync.Added, sync.Updated, sync.Removed, sync.Unchanged)
+	}
+	return w, nil
+}
@@ -0,0 +1,85 @@
+package code
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Alias maps codes to the canonical code of the same item, such as the code
+// of a vendor that renamed its prefix. Aliases apply to normalized codes.
+type Alias struct {
+	// From is the code mapped. Either From or Regex is set.
+	From string
+	// Regex matches whole codes, case-insensitively. To may refer to its
+	// capture groups as $1 or ${name}.
+	Regex string
+	// To is the canonical code.
+	To string
+}
+
+// compiledAlias is an Alias ready for use.
+type compiledAlias struct {
+	Alias
+	re *regexp.Regexp
+}
+
+// Aliases resolves codes to their canonical codes.
+type Aliases struct {
+	aliases   []compiledAlias
+	normalize func(string) string
+}
+
+// NewAliases prepares aliases. normalize is applied to From, To and the
+// result of regex rewrites so they compare equal to extracted codes; nil
+// means Normalize.
+func NewAliases(aliases []Alias, normalize func(string) string) (*Aliases, error) {
+	if normalize == nil {
+		normalize = Normalize
+	}
+	a := &Aliases{normalize: normalize}
+	for _, alias := range aliases {
+		if (alias.From == "") == (alias.Regex == "") {
+			return nil, errors.New("alias needs either from or regex")
+		}
+		if alias.To == "" {
+			return nil, fmt.Errorf("alias %s has no target", alias.From+alias.Regex)
+		}
+		ca := compiledAlias{Alias: alias}
+		if alias.Regex != "" {
+			re, err := regexp.Compile("(?i)^(?:" + alias.Regex + ")$")
+			if err != nil {
+				return nil, err
+			}
+			ca.re = re
+		} else {
+			ca.From = normalize(alias.From)
+			ca.To = normalize(alias.To)
+		}
+		a.aliases = append(a.aliases, ca)
+	}
+	return a, nil
+}
+
+// Resolve returns the canonical code of code and whether an alias applied.
+// The first alias that matches decides; the result is not resolved again.
+func (a *Aliases) Resolve(code string) (string, bool) {
+	if a == nil {
+		return code, false
+	}
+	for _, alias := range a.aliases {
+		if alias.re == nil {
+			if code == alias.From {
+				return alias.To, true
+			}
+			continue
+		}
+		matches := alias.re.FindStringSubmatchIndex(code)
+		if matches == nil {
+			continue
+		}
+		to := a.normalize(string(alias.re.ExpandString(nil, alias.To, code, matches)))
+		return to, to != code
+	}
+	return code, false
+}
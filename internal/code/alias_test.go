@@ -0,0 +1,51 @@
+package code
+
+import "testing"
+
+func TestAliases(t *testing.T) {
+	a, err := NewAliases([]Alias{
+		{From: "old-123", To: "new-123"},
+		{Regex: `OLD(\d+)`, To: "NEW$1"},
+		{Regex: `NEW\d+`, To: "NEWER"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create aliases: %v", err)
+	}
+
+	tests := []struct {
+		code    string
+		want    string
+		aliased bool
+	}{
+		{"OLD123", "NEW123", true},
+		{"OLD456", "NEW456", true},
+		// Results are not resolved again
+		{"NEW456", "NEWER", true},
+		{"XOLD456", "XOLD456", false},
+	}
+	for _, tt := range tests {
+		got, aliased := a.Resolve(tt.code)
+		if got != tt.want || aliased != tt.aliased {
+			t.Errorf("%s: expected %s (%v), got %s (%v)", tt.code, tt.want, tt.aliased, got, aliased)
+		}
+	}
+
+	var none *Aliases
+	if got, aliased := none.Resolve("OLD123"); got != "OLD123" || aliased {
+		t.Errorf("expected nil aliases to keep the code, got %s", got)
+	}
+}
+
+func TestInvalidAlias(t *testing.T) {
+	tests := []Alias{
+		{To: "NEW123"},
+		{From: "OLD123", Regex: "OLD\\d+", To: "NEW123"},
+		{From: "OLD123"},
+		{Regex: "(", To: "NEW"},
+	}
+	for _, alias := range tests {
+		if _, err := NewAliases([]Alias{alias}, nil); err == nil {
+			t.Errorf("%+v: expected an error", alias)
+		}
+	}
+}
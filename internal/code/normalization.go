@@ -0,0 +1,145 @@
+package code
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cases of a normalized code.
+const (
+	CaseUpper = "upper"
+	CaseLower = "lower"
+	CaseKeep  = "keep"
+)
+
+// Normalization configures the steps that turn an extracted code into the
+// code it is indexed under. The steps run in field order: NFKC, Map, Case,
+// Strip, then the digit steps.
+type Normalization struct {
+	// NFKC applies Unicode NFKC normalization, turning full-width letters,
+	// digits and hyphens into their ASCII forms. The text a pattern is
+	// matched against is normalized too, so patterns can be written in
+	// ASCII.
+	NFKC bool
+	// Map replaces strings, longest first.
+	Map map[string]string
+	// Case is one of the Case constants. Empty means CaseUpper.
+	Case string
+	// Strip lists the characters removed from the code.
+	Strip string
+	// TrimZeros drops leading zeros of each number, keeping at least one
+	// digit.
+	TrimZeros bool
+	// PadDigits zero-pads each number to this width after dropping its
+	// leading zeros. Zero leaves numbers alone.
+	PadDigits int
+}
+
+// DefaultNormalization returns the normalization used by Normalize:
+// uppercase and strip hyphens and underscores.
+func DefaultNormalization() Normalization {
+	return Normalization{Case: CaseUpper, Strip: "-_"}
+}
+
+// Normalizer applies a Normalization.
+type Normalizer struct {
+	Normalization
+	replacer *strings.Replacer
+}
+
+// NewNormalizer checks n and prepares it for use.
+func NewNormalizer(n Normalization) (*Normalizer, error) {
+	switch n.Case {
+	case "", CaseUpper, CaseLower, CaseKeep:
+	default:
+		return nil, fmt.Errorf("unknown case %q (use upper, lower or keep)", n.Case)
+	}
+	if n.PadDigits < 0 {
+		return nil, fmt.Errorf("pad_digits must not be negative, got %d", n.PadDigits)
+	}
+
+	nz := &Normalizer{Normalization: n}
+	if len(n.Map) > 0 {
+		from := make([]string, 0, len(n.Map))
+		for k := range n.Map {
+			if k == "" {
+				return nil, fmt.Errorf("map keys must not be empty")
+			}
+			from = append(from, k)
+		}
+		// The replacer prefers earlier pairs, so longer keys win
+		sort.Slice(from, func(i, j int) bool {
+			if len(from[i]) != len(from[j]) {
+				return len(from[i]) > len(from[j])
+			}
+			return from[i] < from[j]
+		})
+		pairs := make([]string, 0, 2*len(from))
+		for _, k := range from {
+			pairs = append(pairs, k, n.Map[k])
+		}
+		nz.replacer = strings.NewReplacer(pairs...)
+	}
+	return nz, nil
+}
+
+// Normalize applies the steps to code.
+func (n *Normalizer) Normalize(code string) string {
+	if n.NFKC {
+		code = norm.NFKC.String(code)
+	}
+	if n.replacer != nil {
+		code = n.replacer.Replace(code)
+	}
+	switch n.Case {
+	case "", CaseUpper:
+		code = strings.ToUpper(code)
+	case CaseLower:
+		code = strings.ToLower(code)
+	}
+	if n.Strip != "" {
+		code = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(n.Strip, r) {
+				return -1
+			}
+			return r
+		}, code)
+	}
+	if n.TrimZeros || n.PadDigits > 0 {
+		code = normalizeNumbers(code, n.PadDigits)
+	}
+	return code
+}
+
+// normalizeNumbers drops the leading zeros of each run of digits in code
+// and pads it to width, or keeps at least one digit when width is zero.
+func normalizeNumbers(code string, width int) string {
+	var b strings.Builder
+	for i := 0; i < len(code); {
+		if !isDigit(code[i]) {
+			b.WriteByte(code[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(code) && isDigit(code[j]) {
+			j++
+		}
+		number := code[i:j]
+		if width > 0 {
+			number = pad(number, width)
+		} else if number = strings.TrimLeft(number, "0"); number == "" {
+			number = "0"
+		}
+		b.WriteString(number)
+		i = j
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
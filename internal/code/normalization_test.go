@@ -0,0 +1,86 @@
+package code
+
+import "testing"
+
+func TestNormalizer(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     Normalization
+		input string
+		want  string
+	}{
+		{"default", DefaultNormalization(), "prj-001", "PRJ001"},
+		{"full-width", Normalization{NFKC: true, Strip: "-"}, "ＡＢＣ－１２３", "ABC123"},
+		{"without nfkc", Normalization{Strip: "-"}, "ＡＢＣ－１２３", "ＡＢＣ－１２３"},
+		{"spaces and dots", Normalization{Strip: "-_ ."}, "abc. 12_3", "ABC123"},
+		{"trim zeros", Normalization{TrimZeros: true}, "PRJ0012V003", "PRJ12V3"},
+		{"trim zero only", Normalization{TrimZeros: true}, "PRJ000", "PRJ0"},
+		{"pad digits", Normalization{PadDigits: 4}, "IMG12", "IMG0012"},
+		{"pad long number", Normalization{PadDigits: 4}, "IMG0012345", "IMG12345"},
+		{"map", Normalization{Map: map[string]string{"O": "0", "OO": "00"}, Case: CaseKeep}, "ABCOO1", "ABC001"},
+		{"lower", Normalization{Case: CaseLower, Strip: "-"}, "PRJ-001", "prj001"},
+	}
+	for _, tt := range tests {
+		n, err := NewNormalizer(tt.n)
+		if err != nil {
+			t.Fatalf("%s: failed to create normalizer: %v", tt.name, err)
+		}
+		if got := n.Normalize(tt.input); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := NewNormalizer(Normalization{Case: "title"}); err == nil {
+		t.Error("expected an error for an unknown case")
+	}
+}
+
+func TestExtractWithNormalization(t *testing.T) {
+	e, err := NewExtractor([]Pattern{
+		{Regex: `([A-Z]{3}[-_ ]?\d+)`, Normalization: &Normalization{NFKC: true, Strip: "-_ ", PadDigits: 5}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create extractor: %v", err)
+	}
+	for _, input := range []string{"ＰＲＪ－１２.zip", "prj 0012.zip", "PRJ_00012.zip"} {
+		if got, ok := e.Extract(input); !ok || got != "PRJ00012" {
+			t.Errorf("%s: expected PRJ00012, got %q", input, got)
+		}
+	}
+}
+
+func TestFormatWithDisplay(t *testing.T) {
+	// An empty template keeps the default format
+	none, err := NewDisplay("")
+	if err != nil {
+		t.Fatalf("failed to parse display: %v", err)
+	}
+	if got := none.Format("PRJ001"); got != "PRJ-001" {
+		t.Errorf("expected the default format, got %q", got)
+	}
+
+	d, err := NewDisplay("{1}_{2:05}")
+	if err != nil {
+		t.Fatalf("failed to parse display: %v", err)
+	}
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"PRJ001", "PRJ_00001"},
+		{"PRJ", "PRJ"},
+		{"PRJ001V2", "PRJ_00001V2"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := d.Format(tt.code); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.code, tt.want, got)
+		}
+	}
+
+	for _, tmpl := range []string{"{name}", "{0}", "{1", "{1:5}"} {
+		if _, err := NewDisplay(tmpl); err == nil {
+			t.Errorf("%s: expected an error", tmpl)
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package code
 
 import (
+	"fmt"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
+
+	"github.com/jiikko/fdup/internal/filter"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Normalize normalizes a code by uppercasing and removing hyphens and underscores.
 // Example: "prj-001" -> "PRJ001", "hoge_9851" -> "HOGE9851"
+// Patterns may configure other steps with a Normalization.
 func Normalize(code string) string {
 	upper := strings.ToUpper(code)
 	upper = strings.ReplaceAll(upper, "-", "")
@@ -15,7 +23,7 @@ func Normalize(code string) string {
 }
 
 // Format formats a normalized code for display by inserting a hyphen
-// between the letter and number parts.
+// between the letter and number parts. Display formats with a template.
 // Example: "PRJ001" -> "PRJ-001"
 func Format(normalized string) string {
 	// Find boundary between letters and digits
@@ -30,20 +38,97 @@ func Format(normalized string) string {
 	return normalized
 }
 
+// Targets select the part of a path a pattern is matched against.
+const (
+	// TargetFilename matches the name of the file, the default.
+	TargetFilename = "filename"
+	// TargetStem matches the name without its extension.
+	TargetStem = "stem"
+	// TargetDirname matches the directory part of the relative path.
+	TargetDirname = "dirname"
+	// TargetPath matches the whole relative path.
+	TargetPath = "path"
+)
+
+// Pattern is a regular expression that finds a code in a path.
+type Pattern struct {
+	// Name identifies the pattern in messages.
+	Name  string
+	Regex string
+	// Template builds the code from the capture groups, such as
+	// "{prefix}{number:05}". Without a template, all capture groups are
+	// concatenated in order.
+	Template string
+	// Multi extracts a code from every match in the filename instead of
+	// only the first one.
+	Multi bool
+	// Target is the part of the path matched, one of the Target constants.
+	// Empty means TargetFilename.
+	Target string
+	// Directory makes the pattern match directories instead of files, so a
+	// directory whose path has a code is indexed as a single item.
+	Directory bool
+	// Normalization normalizes the codes found by the pattern. Nil means
+	// DefaultNormalization.
+	Normalization *Normalization
+	// Filter limits the files the pattern applies to; see MatchFile. It
+	// cannot be set on directory patterns.
+	Filter *filter.Filter
+}
+
+// compiledPattern is a Pattern ready for matching.
+type compiledPattern struct {
+	Pattern
+	re         *regexp.Regexp
+	template   *template
+	normalizer *Normalizer
+}
+
 // Extractor extracts codes from filenames using patterns.
 type Extractor struct {
-	patterns []*regexp.Regexp
+	patterns []compiledPattern
 }
 
-// NewExtractor creates a new code extractor with the given regex patterns.
-func NewExtractor(patterns []string) (*Extractor, error) {
-	compiled := make([]*regexp.Regexp, 0, len(patterns))
+// Match is the result of extracting a code from a filename.
+type Match struct {
+	// Code is the normalized code.
+	Code string
+	// Codes lists every distinct code found by a multi pattern, Code first.
+	// It is empty when a single code was found.
+	Codes []string
+	// Pattern is the pattern that matched.
+	Pattern Pattern
+}
+
+// NewExtractor creates a new code extractor with the given patterns.
+// Patterns are tried in order and matched case-insensitively.
+func NewExtractor(patterns []Pattern) (*Extractor, error) {
+	compiled := make([]compiledPattern, 0, len(patterns))
 	for _, p := range patterns {
-		re, err := regexp.Compile("(?i)" + p)
+		switch p.Target {
+		case "", TargetFilename, TargetStem, TargetDirname, TargetPath:
+		default:
+			return nil, fmt.Errorf("unknown target %q (use filename, stem, dirname or path)", p.Target)
+		}
+		if p.Directory && p.Filter != nil {
+			return nil, fmt.Errorf("directory pattern %q cannot have a filter; filters apply to files", p.Regex)
+		}
+		re, err := regexp.Compile("(?i)" + p.Regex)
 		if err != nil {
 			return nil, err
 		}
-		compiled = append(compiled, re)
+		cp := compiledPattern{Pattern: p, re: re}
+		if p.Normalization != nil {
+			if cp.normalizer, err = NewNormalizer(*p.Normalization); err != nil {
+				return nil, err
+			}
+		}
+		if p.Template != "" {
+			if cp.template, err = parseTemplate(p.Template, re); err != nil {
+				return nil, err
+			}
+		}
+		compiled = append(compiled, cp)
 	}
 	return &Extractor{patterns: compiled}, nil
 }
@@ -51,16 +136,133 @@ func NewExtractor(patterns []string) (*Extractor, error) {
 // Extract extracts a code from a filename using the configured patterns.
 // Returns the normalized code and true if found, or empty string and false if not.
 func (e *Extractor) Extract(filename string) (string, bool) {
-	for _, re := range e.patterns {
-		matches := re.FindStringSubmatch(filename)
-		if len(matches) > 1 {
-			// Combine all capture groups
-			var code string
-			for i := 1; i < len(matches); i++ {
-				code += matches[i]
+	m, ok := e.Match(filename)
+	return m.Code, ok
+}
+
+// Match is like Extract but also reports the pattern that matched and,
+// for multi patterns, every code in the filename.
+// relPath is the path of the file relative to its root; a bare filename
+// matches patterns targeting the filename or stem only.
+// The first pattern that matches decides the result. The filters of
+// patterns are not checked.
+func (e *Extractor) Match(relPath string) (Match, bool) {
+	return e.match(relPath, false, nil)
+}
+
+// MatchFile is like Match, skipping patterns whose filter does not select
+// a file with this size and mtime.
+func (e *Extractor) MatchFile(relPath string, size int64, mtime time.Time) (Match, bool) {
+	name := filepath.Base(relPath)
+	return e.match(relPath, false, func(f *filter.Filter) bool {
+		return f.Match(name, size, mtime)
+	})
+}
+
+// MatchDir is like Match for a directory, using the directory patterns.
+func (e *Extractor) MatchDir(relPath string) (Match, bool) {
+	return e.match(relPath, true, nil)
+}
+
+// HasFilters reports whether any pattern has a filter.
+func (e *Extractor) HasFilters() bool {
+	for _, p := range e.patterns {
+		if p.Filter != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDirPatterns reports whether any pattern matches directories.
+func (e *Extractor) HasDirPatterns() bool {
+	for _, p := range e.patterns {
+		if p.Directory {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Extractor) match(relPath string, dir bool, selects func(*filter.Filter) bool) (Match, bool) {
+	for _, p := range e.patterns {
+		if p.Directory != dir {
+			continue
+		}
+		if selects != nil && p.Filter != nil && !selects(p.Filter) {
+			continue
+		}
+		input := p.input(relPath)
+		if input == "" {
+			continue
+		}
+		if p.normalizer != nil && p.normalizer.NFKC {
+			input = norm.NFKC.String(input)
+		}
+		if !p.Multi {
+			matches := p.re.FindStringSubmatch(input)
+			if len(matches) > 1 {
+				return Match{Code: p.code(matches), Pattern: p.Pattern}, true
 			}
-			return Normalize(code), true
+			continue
 		}
+
+		var codes []string
+		seen := make(map[string]bool)
+		for _, matches := range p.re.FindAllStringSubmatch(input, -1) {
+			if len(matches) < 2 {
+				continue
+			}
+			code := p.code(matches)
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+		if len(codes) == 0 {
+			continue
+		}
+		m := Match{Code: codes[0], Pattern: p.Pattern}
+		if len(codes) > 1 {
+			m.Codes = codes
+		}
+		return m, true
+	}
+	return Match{}, false
+}
+
+// input returns the part of relPath the pattern is matched against.
+func (p compiledPattern) input(relPath string) string {
+	slashed := filepath.ToSlash(relPath)
+	switch p.Target {
+	case TargetStem:
+		base := path.Base(slashed)
+		return strings.TrimSuffix(base, path.Ext(base))
+	case TargetDirname:
+		if dir := path.Dir(slashed); dir != "." {
+			return dir
+		}
+		return ""
+	case TargetPath:
+		return slashed
+	default:
+		return path.Base(slashed)
+	}
+}
+
+// code builds the normalized code from the submatches of a match.
+func (p compiledPattern) code(matches []string) string {
+	var code string
+	if p.template != nil {
+		code = p.template.expand(matches)
+	} else {
+		// Combine all capture groups
+		for i := 1; i < len(matches); i++ {
+			code += matches[i]
+		}
+	}
+	if p.normalizer != nil {
+		return p.normalizer.Normalize(code)
 	}
-	return "", false
+	return Normalize(code)
 }
@@ -0,0 +1,66 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/jiikko/fdup/internal/filter"
+)
+
+func TestExtractWithTarget(t *testing.T) {
+	e, err := NewExtractor([]Pattern{
+		{Name: "folder", Regex: `^([A-Z]{3}-\d{3})$`, Target: TargetDirname},
+		{Name: "nested", Regex: `/([A-Z]{3}-\d{3})/`, Target: TargetPath},
+		{Name: "stem", Regex: `^([A-Z]{3})(\d{3})$`, Target: TargetStem},
+		{Name: "item", Regex: `^([A-Z]{3}-\d{3})$`, Directory: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to create extractor: %v", err)
+	}
+
+	tests := []struct {
+		input   string
+		want    string
+		pattern string
+	}{
+		{"PRJ-001/cover.png", "PRJ001", "folder"},
+		{"work/PRJ-002/final/cover.png", "PRJ002", "nested"},
+		{"doc123.pdf", "DOC123", "stem"},
+		// The extension is not part of the stem
+		{"doc123.pdf123", "DOC123", "stem"},
+		{"", "", ""},
+		{"PRJ-003", "", ""},
+	}
+	for _, tt := range tests {
+		m, ok := e.Match(tt.input)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%q: expected no match, got %s from %s", tt.input, m.Code, m.Pattern.Name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%q: expected a match", tt.input)
+			continue
+		}
+		if m.Code != tt.want || m.Pattern.Name != tt.pattern {
+			t.Errorf("%q: expected %s from %s, got %s from %s", tt.input, tt.want, tt.pattern, m.Code, m.Pattern.Name)
+		}
+	}
+
+	// Directory patterns only match directories
+	if m, ok := e.MatchDir("archive/PRJ-003"); !ok || m.Code != "PRJ003" || m.Pattern.Name != "item" {
+		t.Errorf("expected PRJ003 from item, got %+v", m)
+	}
+	if _, ok := e.MatchDir("archive"); ok {
+		t.Error("expected no directory match")
+	}
+}
+
+func TestUnknownTarget(t *testing.T) {
+	if _, err := NewExtractor([]Pattern{{Regex: `(\d+)`, Target: "basename"}}); err == nil {
+		t.Error("expected an error for an unknown target")
+	}
+	if _, err := NewExtractor([]Pattern{{Regex: `(\d+)`, Directory: true, Filter: &filter.Filter{MinSize: 1}}}); err == nil {
+		t.Error("expected an error for a filter on a directory pattern")
+	}
+}
@@ -0,0 +1,216 @@
+package code
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// template builds a code from the capture groups of a match.
+// References are written as {name} or {index}; {name:05} zero-pads the
+// group to five characters, dropping leading zeros of longer numbers first
+// so that "12" and "0012" both become "00012".
+type template struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text or a reference to a capture group.
+type templatePart struct {
+	literal string
+	group   int // capture group index, or -1 for literal text
+	width   int // zero-padded width, or 0 for none
+}
+
+// parseTemplate parses tmpl and resolves its references against re.
+func parseTemplate(tmpl string, re *regexp.Regexp) (*template, error) {
+	t := &template{}
+	rest := tmpl
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest, group: -1})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start], group: -1})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed {", tmpl)
+		}
+		part, err := parseReference(rest[start+1:start+end], re)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", tmpl, err)
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[start+end+1:]
+	}
+	return t, nil
+}
+
+// parseReference parses the inside of a {...} reference.
+func parseReference(ref string, re *regexp.Regexp) (templatePart, error) {
+	name, spec, hasSpec := strings.Cut(ref, ":")
+	part := templatePart{group: -1}
+
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > re.NumSubexp() {
+			return part, fmt.Errorf("no capture group %d", n)
+		}
+		part.group = n
+	} else {
+		part.group = re.SubexpIndex(name)
+		if part.group < 0 {
+			return part, fmt.Errorf("no capture group named %q", name)
+		}
+	}
+
+	if hasSpec {
+		width, err := strconv.Atoi(spec)
+		if err != nil || !strings.HasPrefix(spec, "0") || width < 1 {
+			return part, fmt.Errorf("invalid format %q in {%s} (use a zero-padded width such as 05)", spec, ref)
+		}
+		part.width = width
+	}
+	return part, nil
+}
+
+// expand builds the code from the submatches of a match.
+func (t *template) expand(matches []string) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.group < 0 {
+			b.WriteString(p.literal)
+			continue
+		}
+		b.WriteString(pad(matches[p.group], p.width))
+	}
+	return b.String()
+}
+
+// pad zero-pads s to width. Leading zeros of a number are dropped first so
+// numbers with different padding produce the same result.
+func pad(s string, width int) string {
+	if width == 0 || s == "" {
+		return s
+	}
+	if isDigits(s) {
+		s = strings.TrimLeft(s, "0")
+	}
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat("0", width-len(s)) + s
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Display formats normalized codes from their runs of digits and other
+// characters. A nil Display uses the default format of Format.
+type Display struct {
+	parts []templatePart
+	last  int // highest run referenced
+}
+
+// NewDisplay parses a display template. References {1}, {2}, ... are the
+// runs of letters and digits of the normalized code in order, so
+// "{1}-{2}" displays "PRJ001" as "PRJ-001", and {2:05} zero-pads a run as
+// in pattern templates. Text before a reference to a run the code does not
+// have is left out, and runs after the last one referenced are appended as
+// they are. An empty template returns nil, the default format.
+func NewDisplay(tmpl string) (*Display, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+
+	d := &Display{}
+	rest := tmpl
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			d.parts = append(d.parts, templatePart{literal: rest, group: -1})
+			break
+		}
+		if start > 0 {
+			d.parts = append(d.parts, templatePart{literal: rest[:start], group: -1})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("display %q: unclosed {", tmpl)
+		}
+		ref := rest[start+1 : start+end]
+		index, spec, hasSpec := strings.Cut(ref, ":")
+		n, err := strconv.Atoi(index)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("display %q: {%s} is not a run number such as {1}", tmpl, ref)
+		}
+		part := templatePart{group: n}
+		if hasSpec {
+			width, err := strconv.Atoi(spec)
+			if err != nil || !strings.HasPrefix(spec, "0") || width < 1 {
+				return nil, fmt.Errorf("display %q: invalid format %q in {%s} (use a zero-padded width such as 05)", tmpl, spec, ref)
+			}
+			part.width = width
+		}
+		d.parts = append(d.parts, part)
+		d.last = max(d.last, n)
+		rest = rest[start+end+1:]
+	}
+	return d, nil
+}
+
+// Format formats a normalized code for display.
+func (d *Display) Format(normalized string) string {
+	if d == nil {
+		return Format(normalized)
+	}
+	runs := splitRuns(normalized)
+
+	var b strings.Builder
+	var literal string // text waiting for the next reference
+	for _, p := range d.parts {
+		if p.group < 0 {
+			literal += p.literal
+			continue
+		}
+		if p.group <= len(runs) {
+			b.WriteString(literal)
+			b.WriteString(pad(runs[p.group-1], p.width))
+		}
+		literal = ""
+	}
+	b.WriteString(literal)
+	for i := d.last; i < len(runs); i++ {
+		b.WriteString(runs[i])
+	}
+	return b.String()
+}
+
+// splitRuns splits s into maximal runs of digits and of other characters.
+func splitRuns(s string) []string {
+	var runs []string
+	start, digit := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsDigit(r) != digit {
+			runs = append(runs, s[start:i])
+			start = i
+		}
+		digit = unicode.IsDigit(r)
+	}
+	if start < len(s) {
+		runs = append(runs, s[start:])
+	}
+	return runs
+}
@@ -0,0 +1,51 @@
+package code
+
+import "testing"
+
+func TestExtractWithTemplate(t *testing.T) {
+	e, err := NewExtractor([]Pattern{
+		{Name: "img", Regex: `(?P<prefix>IMG)_(?P<number>\d+)`, Template: "{prefix}{number:05}"},
+		{Name: "reversed", Regex: `(\d{4})-([A-Z]{3})`, Template: "{2}{1}"},
+		{Name: "plain", Regex: `([A-Z]{2,5})(\d{3,5})`},
+	})
+	if err != nil {
+		t.Fatalf("failed to create extractor: %v", err)
+	}
+
+	tests := []struct {
+		input   string
+		want    string
+		pattern string
+	}{
+		{"IMG_12.jpg", "IMG00012", "img"},
+		{"IMG_0012.jpg", "IMG00012", "img"},
+		{"img_123456.jpg", "IMG123456", "img"},
+		{"IMG_0.jpg", "IMG00000", "img"},
+		{"2024-abc.pdf", "ABC2024", "reversed"},
+		{"DSC00001.jpg", "DSC00001", "plain"},
+	}
+	for _, tt := range tests {
+		m, ok := e.Match(tt.input)
+		if !ok {
+			t.Errorf("%s: expected a match", tt.input)
+			continue
+		}
+		if m.Code != tt.want || m.Pattern.Name != tt.pattern {
+			t.Errorf("%s: expected %s from %s, got %s from %s", tt.input, tt.want, tt.pattern, m.Code, m.Pattern.Name)
+		}
+	}
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	tests := []Pattern{
+		{Regex: `(?P<prefix>IMG)_(\d+)`, Template: "{prefix}{number}"},
+		{Regex: `(IMG)_(\d+)`, Template: "{3}"},
+		{Regex: `(IMG)_(\d+)`, Template: "{1}{2:5}"},
+		{Regex: `(IMG)_(\d+)`, Template: "{1}{2"},
+	}
+	for _, p := range tests {
+		if _, err := NewExtractor([]Pattern{p}); err == nil {
+			t.Errorf("expected template %q to be rejected", p.Template)
+		}
+	}
+}
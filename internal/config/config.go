@@ -1,10 +1,15 @@
 package config
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/jiikko/fdup/internal/code"
+	"github.com/jiikko/fdup/internal/filter"
+	"github.com/jiikko/fdup/internal/sidecar"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,19 +21,125 @@ const (
 
 // Config represents the fdup configuration.
 type Config struct {
-	Patterns []Pattern   `yaml:"patterns"`
-	Ignore   []string    `yaml:"ignore"`
-	Test     []TestCase  `yaml:"test,omitempty"`
+	Patterns []Pattern `yaml:"patterns"`
+	// Normalize configures how codes are normalized unless a pattern has
+	// its own steps.
+	Normalize *Normalization `yaml:"normalize,omitempty"`
+	// Display is the template codes are displayed with, e.g. "{1}-{2}".
+	Display string `yaml:"display,omitempty"`
+	// Aliases map codes to the canonical code they are grouped under.
+	Aliases []Alias `yaml:"aliases,omitempty"`
+	// Filter selects the files indexed by extension, size and age.
+	Filter *Filter `yaml:"filter,omitempty"`
+	// Sidecars lists the extensions of sidecar files, such as xmp, which
+	// belong to the file with the same stem in their directory.
+	Sidecars []string   `yaml:"sidecars,omitempty"`
+	Ignore   []string   `yaml:"ignore"`
+	Roots    []Root     `yaml:"roots,omitempty"`
+	Test     []TestCase `yaml:"test,omitempty"`
+}
+
+// Alias maps a code, or the codes matching a regex, to a canonical code.
+type Alias struct {
+	From  string `yaml:"from,omitempty"`
+	Regex string `yaml:"regex,omitempty"`
+	// To is the canonical code. With a regex it may refer to capture groups
+	// as $1.
+	To string `yaml:"to"`
+}
+
+// Root is a directory scanned into the index.
+type Root struct {
+	// Path is absolute or relative to the directory containing .fdup.
+	Path string `yaml:"path"`
+	// Ignore lists patterns ignored under this root in addition to the
+	// global ignore list.
+	Ignore []string `yaml:"ignore,omitempty"`
 }
 
 // Pattern represents a regex pattern for code extraction.
 type Pattern struct {
 	Name  string `yaml:"name"`
 	Regex string `yaml:"regex"`
+	// Template builds the code from named or numbered capture groups,
+	// e.g. "{prefix}{number:05}".
+	Template string `yaml:"template,omitempty"`
+	// Multi extracts every code in a filename, not just the first.
+	Multi bool `yaml:"multi,omitempty"`
+	// Target is the part of the path matched: filename (default), stem,
+	// dirname or path.
+	Target string `yaml:"target,omitempty"`
+	// Directory indexes each matching directory as one item.
+	Directory bool `yaml:"directory,omitempty"`
+	// Normalize replaces the global normalization for this pattern.
+	Normalize *Normalization `yaml:"normalize,omitempty"`
+	// Filter limits the files the pattern applies to.
+	Filter *Filter `yaml:"filter,omitempty"`
+}
+
+// Filter selects files by extension, size and modification time.
+// Sizes take units such as "10MB"; times are dates such as "2024-01-31"
+// or ages such as "30d".
+type Filter struct {
+	Extensions        []string    `yaml:"extensions,omitempty"`
+	ExcludeExtensions []string    `yaml:"exclude_extensions,omitempty"`
+	MinSize           filter.Size `yaml:"min_size,omitempty"`
+	MaxSize           filter.Size `yaml:"max_size,omitempty"`
+	ModifiedAfter     filter.Time `yaml:"modified_after,omitempty"`
+	ModifiedBefore    filter.Time `yaml:"modified_before,omitempty"`
+}
+
+// filter converts f for use, or returns nil for nil.
+func (f *Filter) filter() *filter.Filter {
+	if f == nil {
+		return nil
+	}
+	return &filter.Filter{
+		Extensions:        f.Extensions,
+		ExcludeExtensions: f.ExcludeExtensions,
+		MinSize:           f.MinSize,
+		MaxSize:           f.MaxSize,
+		ModifiedAfter:     f.ModifiedAfter,
+		ModifiedBefore:    f.ModifiedBefore,
+	}
+}
+
+// Normalization lists the steps applied to extracted codes.
+// Unset fields keep the default of uppercasing and stripping "-" and "_".
+type Normalization struct {
+	NFKC bool              `yaml:"nfkc,omitempty"`
+	Map  map[string]string `yaml:"map,omitempty"`
+	// Case is upper (default), lower or keep.
+	Case string `yaml:"case,omitempty"`
+	// Strip lists the characters removed; nil means "-_".
+	Strip     *string `yaml:"strip,omitempty"`
+	TrimZeros bool    `yaml:"trim_zeros,omitempty"`
+	PadDigits int     `yaml:"pad_digits,omitempty"`
+}
+
+// code converts n for the code package, or returns nil for nil.
+func (n *Normalization) code() *code.Normalization {
+	if n == nil {
+		return nil
+	}
+	result := code.DefaultNormalization()
+	result.NFKC = n.NFKC
+	result.Map = n.Map
+	if n.Case != "" {
+		result.Case = n.Case
+	}
+	if n.Strip != nil {
+		result.Strip = *n.Strip
+	}
+	result.TrimZeros = n.TrimZeros
+	result.PadDigits = n.PadDigits
+	return &result
 }
 
 // TestCase represents a test case for pattern validation.
 type TestCase struct {
+	// Input is a filename or a path relative to a root. A trailing slash
+	// makes it a directory.
 	Input    string  `yaml:"input"`
 	Expected *string `yaml:"expected"`
 }
@@ -107,11 +218,208 @@ func Save(configDir string, cfg *Config) error {
 	return os.WriteFile(configPath, data, 0644)
 }
 
-// GetPatternRegexes returns the regex strings from the patterns.
-func (c *Config) GetPatternRegexes() []string {
-	regexes := make([]string, len(c.Patterns))
+// AddAlias appends alias to the aliases in the config file of configDir.
+// The file is edited in place, so its comments, key order and the rest of
+// its content are kept.
+func AddAlias(configDir string, alias Alias) error {
+	return editConfig(configDir, func(root *yaml.Node) error {
+		var item yaml.Node
+		if err := item.Encode(alias); err != nil {
+			return err
+		}
+		seq := mappingValue(root, "aliases")
+		if seq == nil {
+			root.Content = append(root.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "aliases"},
+				&yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{&item}})
+			return nil
+		}
+		if seq.Kind != yaml.SequenceNode {
+			// "aliases:" with no value
+			*seq = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", HeadComment: seq.HeadComment, LineComment: seq.LineComment}
+		}
+		if len(seq.Content) == 0 {
+			// Lay out a former "aliases: []" as a block
+			seq.Style = 0
+		}
+		seq.Content = append(seq.Content, &item)
+		return nil
+	})
+}
+
+// RemoveAlias removes the aliases for from, a code or regex, from the config
+// file of configDir, editing it in place as AddAlias does. It reports
+// whether any alias was removed.
+func RemoveAlias(configDir, from string) (bool, error) {
+	removed := false
+	err := editConfig(configDir, func(root *yaml.Node) error {
+		seq := mappingValue(root, "aliases")
+		if seq == nil || seq.Kind != yaml.SequenceNode {
+			return nil
+		}
+		kept := seq.Content[:0]
+		for _, item := range seq.Content {
+			var a Alias
+			if err := item.Decode(&a); err != nil {
+				return err
+			}
+			if a.From+a.Regex == from {
+				removed = true
+				continue
+			}
+			kept = append(kept, item)
+		}
+		seq.Content = kept
+		if removed && len(kept) == 0 {
+			deleteMappingKey(root, "aliases")
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// editConfig applies edit to the top-level mapping of the config file of
+// configDir and writes the file back.
+func editConfig(configDir string, edit func(root *yaml.Node) error) error {
+	configPath := filepath.Join(configDir, ConfigFile)
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		// An empty file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping", ConfigFile)
+	}
+	if err := edit(root); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, buf.Bytes(), 0644)
+}
+
+// deleteMappingKey removes key and its value from a mapping node.
+func deleteMappingKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// CodePatterns returns the patterns for code extraction.
+func (c *Config) CodePatterns() []code.Pattern {
+	patterns := make([]code.Pattern, len(c.Patterns))
 	for i, p := range c.Patterns {
-		regexes[i] = p.Regex
+		patterns[i] = code.Pattern{
+			Name:      p.Name,
+			Regex:     p.Regex,
+			Template:  p.Template,
+			Multi:     p.Multi,
+			Target:    p.Target,
+			Directory: p.Directory,
+			Filter:    p.Filter.filter(),
+		}
+		if p.Normalize != nil {
+			patterns[i].Normalization = p.Normalize.code()
+		} else {
+			patterns[i].Normalization = c.Normalize.code()
+		}
+	}
+	return patterns
+}
+
+// ScanFilter returns the filter selecting the files indexed, or nil when
+// every file is.
+func (c *Config) ScanFilter() *filter.Filter {
+	return c.Filter.filter()
+}
+
+// SidecarRules returns the rules of sidecar files, or nil without any.
+func (c *Config) SidecarRules() *sidecar.Rules {
+	return sidecar.New(c.Sidecars)
+}
+
+// CodeDisplay returns the display format of codes, or nil for the default.
+func (c *Config) CodeDisplay() (*code.Display, error) {
+	return code.NewDisplay(c.Display)
+}
+
+// CodeAliases returns the aliases for code extraction, normalized with the
+// global normalization.
+func (c *Config) CodeAliases() (*code.Aliases, error) {
+	normalizer, err := code.NewNormalizer(c.Normalization())
+	if err != nil {
+		return nil, err
+	}
+	aliases := make([]code.Alias, len(c.Aliases))
+	for i, a := range c.Aliases {
+		aliases[i] = code.Alias{From: a.From, Regex: a.Regex, To: a.To}
+	}
+	return code.NewAliases(aliases, normalizer.Normalize)
+}
+
+// Normalization returns the global normalization steps, used for codes
+// typed by the user.
+func (c *Config) Normalization() code.Normalization {
+	if n := c.Normalize.code(); n != nil {
+		return *n
+	}
+	return code.DefaultNormalization()
+}
+
+// ScanRoots returns the roots to scan with absolute, cleaned paths.
+// Without roots in the config, the directory containing configDir is the
+// only root.
+func (c *Config) ScanRoots(configDir string) ([]Root, error) {
+	base := filepath.Dir(configDir)
+	if len(c.Roots) == 0 {
+		return []Root{{Path: base}}, nil
+	}
+
+	roots := make([]Root, 0, len(c.Roots))
+	seen := make(map[string]bool)
+	for _, r := range c.Roots {
+		if r.Path == "" {
+			return nil, errors.New("root path must not be empty")
+		}
+		path := r.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(base, path)
+		}
+		path = filepath.Clean(path)
+		if seen[path] {
+			return nil, fmt.Errorf("root %s is listed more than once", path)
+		}
+		seen[path] = true
+		roots = append(roots, Root{Path: path, Ignore: r.Ignore})
 	}
-	return regexes
+	return roots, nil
 }
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const commentedConfig = `# Settings for the photo library
+patterns:
+    - name: standard # vendor codes
+      regex: ([A-Z]{2,5}-\d{3,5})
+# Codes renamed by vendors
+aliases:
+    - from: OLD001 # renamed in 2023
+      to: NEW001
+ignore:
+    - .git/
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return dir
+}
+
+func readConfig(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	return string(data)
+}
+
+func TestAddAliasKeepsComments(t *testing.T) {
+	dir := writeConfig(t, commentedConfig)
+	if err := AddAlias(dir, Alias{Regex: `OLD(\d+)`, To: "NEW$1"}); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+
+	got := readConfig(t, dir)
+	want := strings.Replace(commentedConfig, "ignore:", `    - regex: OLD(\d+)
+      to: NEW$1
+ignore:`, 1)
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if len(cfg.Aliases) != 2 || cfg.Aliases[1].Regex != `OLD(\d+)` || cfg.Aliases[1].To != "NEW$1" {
+		t.Errorf("expected the alias to be added, got %+v", cfg.Aliases)
+	}
+}
+
+func TestAddAliasWithoutAliases(t *testing.T) {
+	for _, content := range []string{"", "# empty\n", "aliases: []\n", "aliases:\n"} {
+		dir := writeConfig(t, content)
+		if err := AddAlias(dir, Alias{From: "OLD001", To: "NEW001"}); err != nil {
+			t.Fatalf("%q: failed to add alias: %v", content, err)
+		}
+		cfg, err := Load(dir)
+		if err != nil {
+			t.Fatalf("%q: failed to load config: %v", content, err)
+		}
+		if len(cfg.Aliases) != 1 || cfg.Aliases[0].From != "OLD001" {
+			t.Errorf("%q: expected one alias, got %+v", content, cfg.Aliases)
+		}
+	}
+}
+
+func TestRemoveAliasKeepsComments(t *testing.T) {
+	dir := writeConfig(t, commentedConfig)
+	if err := AddAlias(dir, Alias{From: "ABC001", To: "XYZ001"}); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+
+	removed, err := RemoveAlias(dir, "ABC001")
+	if err != nil {
+		t.Fatalf("failed to remove alias: %v", err)
+	}
+	if !removed {
+		t.Error("expected the alias to be removed")
+	}
+	if got := readConfig(t, dir); got != commentedConfig {
+		t.Errorf("expected:\n%s\ngot:\n%s", commentedConfig, got)
+	}
+
+	if removed, err := RemoveAlias(dir, "ABC001"); err != nil || removed {
+		t.Errorf("expected nothing to remove, got %v, %v", removed, err)
+	}
+
+	// Removing the last alias drops the key
+	if _, err := RemoveAlias(dir, "OLD001"); err != nil {
+		t.Fatalf("failed to remove alias: %v", err)
+	}
+	if got := readConfig(t, dir); strings.Contains(got, "aliases") || !strings.Contains(got, "# Settings for the photo library") {
+		t.Errorf("expected the aliases key to be dropped, got:\n%s", got)
+	}
+}
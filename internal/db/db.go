@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -21,21 +22,86 @@ type FileRecord struct {
 	Size      int64
 	Mtime     time.Time
 	CreatedAt time.Time
+	// PartialHash and Hash are content hashes computed lazily by dup --verify.
+	// They are empty until computed and reset whenever the record is replaced.
+	PartialHash string
+	Hash        string
+	// Root is the scan root the file was found under. It is empty for
+	// records written before roots were recorded.
+	Root string
+	// Volume is the UUID of the volume the file is on, or empty if the root
+	// is not on a named volume.
+	Volume string
+	// Codes lists every code of a file with several codes, Code first.
+	// It is empty for files with a single code.
+	Codes []string
+	// AliasOf maps each code of the file that was reached through an alias
+	// to the code found in its name. It is nil when no alias applied.
+	AliasOf map[string]string
+}
+
+// AllCodes returns every code of the file.
+func (r FileRecord) AllCodes() []string {
+	if len(r.Codes) > 0 {
+		return r.Codes
+	}
+	return []string{r.Code}
+}
+
+// SyncResult summarizes the changes applied by Sync.
+type SyncResult struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
 }
 
 // DuplicateGroup represents a group of duplicate files.
 type DuplicateGroup struct {
 	Code  string
 	Files []FileRecord
+	// Subgroups is set when the group has been verified by content.
+	// Files is then ordered to match the concatenation of the subgroups.
+	Subgroups []ContentGroup
+}
+
+// ContentGroup is a subset of a verified DuplicateGroup.
+type ContentGroup struct {
+	// Identical is true when all files share the same content.
+	// Otherwise the files share only the code and differ in content.
+	Identical bool
+	Hash      string
+	Files     []FileRecord
+}
+
+// Verified reports whether the group has been split by content.
+func (g DuplicateGroup) Verified() bool {
+	return len(g.Subgroups) > 0
 }
 
 // Open opens or creates the database at the given path.
 func Open(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite", dbPath)
+	// The busy timeout lets a watcher and other writers wait for each other
+	// instead of failing with "database is locked"
+	conn, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
-	return &DB{conn: conn}, nil
+	d := &DB{conn: conn}
+
+	// WAL lets readers continue while a scan writes, and keeps the
+	// previous index intact if the process dies mid-transaction
+	if _, err := conn.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// Create the schema or bring databases from older versions up to date
+	if err := d.migrate(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
 // Close closes the database connection.
@@ -44,107 +110,170 @@ func (d *DB) Close() error {
 }
 
 // Initialize creates the database tables.
+// Open already applies all migrations, so this is a no-op for an opened
+// database; it is kept for callers that create a database explicitly.
 func (d *DB) Initialize() error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS codes (
-			code TEXT PRIMARY KEY,
-			created_at DATETIME
-		);
-
-		CREATE TABLE IF NOT EXISTS files (
-			path TEXT PRIMARY KEY,
-			code TEXT REFERENCES codes(code),
-			size INTEGER,
-			mtime DATETIME,
-			created_at DATETIME
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_code ON files(code);
-		CREATE INDEX IF NOT EXISTS idx_size ON files(size);
-	`
-	_, err := d.conn.Exec(schema)
-	return err
+	return d.migrate()
 }
 
 // Clear removes all records from the database.
 func (d *DB) Clear() error {
-	_, err := d.conn.Exec("DELETE FROM files; DELETE FROM codes;")
+	_, err := d.conn.Exec("DELETE FROM file_codes; DELETE FROM files; DELETE FROM codes;")
 	return err
 }
 
 // InsertFile inserts or updates a file record.
 func (d *DB) InsertFile(record FileRecord) error {
-	now := time.Now()
+	return d.InsertFiles([]FileRecord{record})
+}
 
-	// Ensure code exists
-	_, err := d.conn.Exec(`
-		INSERT OR IGNORE INTO codes (code, created_at)
-		VALUES (?, ?)
-	`, record.Code, now)
+// ListFiles returns all indexed file records.
+func (d *DB) ListFiles() ([]FileRecord, error) {
+	return d.ListFilesMatching(FileFilter{})
+}
+
+// ListFilesMatching returns the indexed file records selected by filter,
+// sorted by path.
+func (d *DB) ListFilesMatching(filter FileFilter) ([]FileRecord, error) {
+	query := `
+		SELECT f.path, f.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+		FROM files f
+	`
+	cond, args := filter.where()
+	if cond != "" {
+		query += " WHERE " + cond
+	}
+	rows, err := d.conn.Query(query+" ORDER BY f.path", args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer func() { _ = rows.Close() }()
 
-	// Insert file
-	_, err = d.conn.Exec(`
-		INSERT OR REPLACE INTO files (path, code, size, mtime, created_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, record.Path, record.Code, record.Size, record.Mtime, now)
-	return err
+	var records []FileRecord
+	for rows.Next() {
+		rec, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := d.attachCodes(records); err != nil {
+		return nil, err
+	}
+	return records, nil
 }
 
 // SearchByCode searches for files by code prefix or exact match.
+// A file with several codes is found by any of them.
 func (d *DB) SearchByCode(code string, exact bool) ([]DuplicateGroup, error) {
 	var query string
 	var args []interface{}
 
 	if exact {
 		query = `
-			SELECT f.path, f.code, f.size, f.mtime, f.created_at
-			FROM files f
-			WHERE f.code = ?
-			ORDER BY f.code, f.path
+			SELECT f.path, fc.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+			FROM file_codes fc
+			JOIN files f ON f.path = fc.path
+			WHERE fc.code = ?
+			ORDER BY fc.code, f.path
 		`
 		args = []interface{}{code}
 	} else {
 		query = `
-			SELECT f.path, f.code, f.size, f.mtime, f.created_at
-			FROM files f
-			WHERE f.code LIKE ?
-			ORDER BY f.code, f.path
+			SELECT f.path, fc.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+			FROM file_codes fc
+			JOIN files f ON f.path = fc.path
+			WHERE fc.code LIKE ?
+			ORDER BY fc.code, f.path
 		`
 		args = []interface{}{code + "%"}
 	}
 
-	rows, err := d.conn.Query(query, args...)
-	if err != nil {
-		return nil, err
+	return d.queryGroups(query, args...)
+}
+
+// FileFilter limits the files considered when finding duplicates. The zero
+// value considers every file.
+type FileFilter struct {
+	MinSize int64
+	// MaxSize is the largest size considered. Zero means no limit.
+	MaxSize int64
+	// Extensions lists the extensions considered, with or without the dot,
+	// compared case-insensitively. Empty means any extension.
+	Extensions []string
+}
+
+// where returns the SQL condition on the files table f selecting the
+// files of the filter, or "" for the zero filter.
+func (ff FileFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if ff.MinSize > 0 {
+		conds = append(conds, "f.size >= ?")
+		args = append(args, ff.MinSize)
 	}
-	defer func() { _ = rows.Close() }()
+	if ff.MaxSize > 0 {
+		conds = append(conds, "f.size <= ?")
+		args = append(args, ff.MaxSize)
+	}
+	if len(ff.Extensions) > 0 {
+		var exts []string
+		for _, ext := range ff.Extensions {
+			// LIKE is case-insensitive for ASCII
+			exts = append(exts, `f.path LIKE ? ESCAPE '\'`)
+			args = append(args, "%."+escapeLike(strings.TrimPrefix(ext, ".")))
+		}
+		conds = append(conds, "("+strings.Join(exts, " OR ")+")")
+	}
+	return strings.Join(conds, " AND "), args
+}
 
-	return groupResults(rows)
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 // FindDuplicates finds all duplicate file groups.
 // Duplicates are files with the same code but in different directories.
+// A file with several codes can be in several groups; in each group its
+// Code is the code of the group.
 func (d *DB) FindDuplicates() ([]DuplicateGroup, error) {
+	return d.FindDuplicatesMatching(FileFilter{})
+}
+
+// FindDuplicatesMatching is like FindDuplicates, considering only the files
+// selected by filter. Groups are formed among those files alone.
+func (d *DB) FindDuplicatesMatching(filter FileFilter) ([]DuplicateGroup, error) {
 	// First, get all files grouped by code
 	query := `
-		SELECT f.path, f.code, f.size, f.mtime, f.created_at
-		FROM files f
-		WHERE f.code IN (
-			SELECT code FROM files GROUP BY code HAVING COUNT(*) > 1
+		SELECT f.path, fc.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+		FROM file_codes fc
+		JOIN files f ON f.path = fc.path
+		WHERE fc.code IN (
+			SELECT code FROM file_codes GROUP BY code HAVING COUNT(*) > 1
 		)
-		ORDER BY f.code, f.path
+		ORDER BY fc.code, f.path
 	`
-
-	rows, err := d.conn.Query(query)
-	if err != nil {
-		return nil, err
+	cond, args := filter.where()
+	if cond != "" {
+		// The size condition can use idx_size
+		query = `
+			WITH selected AS (SELECT f.path FROM files f WHERE ` + cond + `)
+			SELECT f.path, fc.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+			FROM file_codes fc
+			JOIN files f ON f.path = fc.path
+			WHERE fc.path IN selected AND fc.code IN (
+				SELECT code FROM file_codes WHERE path IN selected GROUP BY code HAVING COUNT(*) > 1
+			)
+			ORDER BY fc.code, f.path
+		`
 	}
-	defer func() { _ = rows.Close() }()
 
-	groups, err := groupResults(rows)
+	groups, err := d.queryGroups(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -152,12 +281,7 @@ func (d *DB) FindDuplicates() ([]DuplicateGroup, error) {
 	// Filter to keep only groups with files in different directories
 	var result []DuplicateGroup
 	for _, group := range groups {
-		dirs := make(map[string]bool)
-		for _, f := range group.Files {
-			dirs[filepath.Dir(f.Path)] = true
-		}
-		// Only include if files are in different directories
-		if len(dirs) > 1 {
+		if spansDirectories(group) {
 			result = append(result, group)
 		}
 	}
@@ -165,6 +289,83 @@ func (d *DB) FindDuplicates() ([]DuplicateGroup, error) {
 	return result, nil
 }
 
+// DuplicatesOf returns the duplicate group of code, or nil if the files
+// with that code do not form a duplicate group.
+func (d *DB) DuplicatesOf(code string) (*DuplicateGroup, error) {
+	groups, err := d.SearchByCode(code, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 || !spansDirectories(groups[0]) {
+		return nil, nil
+	}
+	return &groups[0], nil
+}
+
+// spansDirectories reports whether the files of a group are in more than
+// one directory.
+func spansDirectories(group DuplicateGroup) bool {
+	dirs := make(map[string]bool)
+	for _, f := range group.Files {
+		dirs[filepath.Dir(f.Path)] = true
+	}
+	return len(dirs) > 1
+}
+
+// GetFile returns the indexed record for path, or nil if it is not indexed.
+func (d *DB) GetFile(path string) (*FileRecord, error) {
+	rows, err := d.conn.Query(`
+		SELECT path, code, size, mtime, created_at, partial_hash, hash, root, volume
+		FROM files
+		WHERE path = ?
+	`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	rec, err := scanFile(rows)
+	if err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	if rec.Codes, err = d.fileCodes(path); err != nil {
+		return nil, err
+	}
+	aliases, err := d.aliasesOf([]string{path})
+	if err != nil {
+		return nil, err
+	}
+	rec.AliasOf = aliases[path]
+	return &rec, nil
+}
+
+// fileCodes returns the codes of path if it has more than one.
+func (d *DB) fileCodes(path string) ([]string, error) {
+	rows, err := d.conn.Query("SELECT code FROM file_codes WHERE path = ? ORDER BY rowid", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) < 2 {
+		return nil, rows.Err()
+	}
+	return codes, rows.Err()
+}
+
 // GetFileCount returns the total number of indexed files.
 func (d *DB) GetFileCount() (int, error) {
 	var count int
@@ -174,23 +375,218 @@ func (d *DB) GetFileCount() (int, error) {
 
 // DeleteFile removes a file from the database.
 func (d *DB) DeleteFile(path string) error {
-	_, err := d.conn.Exec("DELETE FROM files WHERE path = ?", path)
-	return err
+	w, err := d.beginWrite()
+	if err != nil {
+		return err
+	}
+	if err := w.delete(path); err != nil {
+		w.rollback()
+		return err
+	}
+	return w.commit()
+}
+
+// DeleteFilesUnder removes dir and every file below it from the database
+// and returns the number of removed records.
+func (d *DB) DeleteFilesUnder(dir string) (int64, error) {
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM file_codes WHERE path = ? OR instr(path, ?) = 1", dir, prefix); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM files WHERE path = ? OR instr(path, ?) = 1", dir, prefix)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return n, tx.Commit()
 }
 
 // UpdateFilePath updates a file's path (for move operations).
 func (d *DB) UpdateFilePath(oldPath, newPath string) error {
-	_, err := d.conn.Exec("UPDATE files SET path = ? WHERE path = ?", newPath, oldPath)
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE files SET path = ? WHERE path = ?", newPath, oldPath); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("UPDATE file_codes SET path = ? WHERE path = ?", newPath, oldPath); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetHashes stores the content hashes of an indexed file.
+// An empty hash is stored as NULL.
+func (d *DB) SetHashes(path, partialHash, hash string) error {
+	_, err := d.conn.Exec(
+		"UPDATE files SET partial_hash = ?, hash = ? WHERE path = ?",
+		nullString(partialHash), nullString(hash), path,
+	)
 	return err
 }
 
+// scanFile reads a file record from a row selected with the columns
+// path, code, size, mtime, created_at, partial_hash, hash, root, volume.
+func scanFile(rows *sql.Rows) (FileRecord, error) {
+	var rec FileRecord
+	var partialHash, hash, root, volume sql.NullString
+	if err := rows.Scan(&rec.Path, &rec.Code, &rec.Size, &rec.Mtime, &rec.CreatedAt, &partialHash, &hash, &root, &volume); err != nil {
+		return rec, err
+	}
+	rec.PartialHash = partialHash.String
+	rec.Hash = hash.String
+	rec.Root = root.String
+	rec.Volume = volume.String
+	return rec, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// queryGroups runs a query selecting file columns as scanFile expects,
+// groups the files by code and fills in the codes of multi-code files.
+func (d *DB) queryGroups(query string, args ...interface{}) ([]DuplicateGroup, error) {
+	rows, err := d.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := groupResults(rows)
+	_ = rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([][]FileRecord, len(groups))
+	for i, group := range groups {
+		files[i] = group.Files
+	}
+	if err := d.attachCodes(files...); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// attachCodes fills in the codes and aliases of the records in each list
+// from file_codes, reading it once for all of them. Only the rows of the
+// records are read unless there are too many to list in a query.
+func (d *DB) attachCodes(lists ...[]FileRecord) error {
+	var paths []string
+	for _, records := range lists {
+		for _, rec := range records {
+			paths = append(paths, rec.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	if len(paths) > maxPathArgs {
+		paths = nil
+	}
+
+	codes, err := d.multiCodes(paths)
+	if err != nil {
+		return err
+	}
+	aliases, err := d.aliasesOf(paths)
+	if err != nil {
+		return err
+	}
+	for _, records := range lists {
+		for i := range records {
+			records[i].Codes = codes[records[i].Path]
+			records[i].AliasOf = aliases[records[i].Path]
+		}
+	}
+	return nil
+}
+
+// maxPathArgs is the most paths attachCodes lists in a query.
+const maxPathArgs = 1000
+
+// pathIn returns the condition selecting rows whose path is one of paths,
+// or "1" to select every row when paths is nil.
+func pathIn(paths []string) (string, []interface{}) {
+	if paths == nil {
+		return "1", nil
+	}
+	args := make([]interface{}, len(paths))
+	for i, p := range paths {
+		args[i] = p
+	}
+	return "path IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(paths)), ", ") + ")", args
+}
+
+// aliasesOf returns, by path, the codes reached through an alias mapped to
+// the codes found in the names. With paths given, only those files are read.
+func (d *DB) aliasesOf(paths []string) (map[string]map[string]string, error) {
+	cond, args := pathIn(paths)
+	rows, err := d.conn.Query("SELECT path, code, alias_of FROM file_codes WHERE alias_of IS NOT NULL AND "+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	aliases := make(map[string]map[string]string)
+	for rows.Next() {
+		var p, code, aliasOf string
+		if err := rows.Scan(&p, &code, &aliasOf); err != nil {
+			return nil, err
+		}
+		if aliases[p] == nil {
+			aliases[p] = make(map[string]string)
+		}
+		aliases[p][code] = aliasOf
+	}
+	return aliases, rows.Err()
+}
+
+// multiCodes returns the codes of every file with more than one code, by
+// path, in the order they were extracted. With paths given, only those
+// files are read.
+func (d *DB) multiCodes(paths []string) (map[string][]string, error) {
+	cond, args := pathIn(paths)
+	rows, err := d.conn.Query(`
+		SELECT path, code FROM file_codes
+		WHERE path IN (SELECT path FROM file_codes WHERE `+cond+` GROUP BY path HAVING COUNT(*) > 1)
+		ORDER BY path, rowid
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	codes := make(map[string][]string)
+	for rows.Next() {
+		var path, code string
+		if err := rows.Scan(&path, &code); err != nil {
+			return nil, err
+		}
+		codes[path] = append(codes[path], code)
+	}
+	return codes, rows.Err()
+}
+
 func groupResults(rows *sql.Rows) ([]DuplicateGroup, error) {
 	groups := make(map[string][]FileRecord)
 	order := []string{}
 
 	for rows.Next() {
-		var rec FileRecord
-		if err := rows.Scan(&rec.Path, &rec.Code, &rec.Size, &rec.Mtime, &rec.CreatedAt); err != nil {
+		rec, err := scanFile(rows)
+		if err != nil {
 			return nil, err
 		}
 		if _, exists := groups[rec.Code]; !exists {
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Journal actions.
+const (
+	ActionMove   = "move"
+	ActionTrash  = "trash"
+	ActionDelete = "delete"
+)
+
+// JournalEntry records one file operation so it can be undone.
+type JournalEntry struct {
+	ID        int64
+	SessionID string
+	CreatedAt time.Time
+	Action    string
+	Source    string
+	// Destination is the new path for moves and the location inside the
+	// trash for trashed files. It is empty for deletions.
+	Destination string
+	Code        string
+	// InfoPath is the .trashinfo file of a trashed file, if any.
+	InfoPath string
+	// File is the index record of a trashed or deleted file, so undo can
+	// restore it with all its codes. It is nil for files that were not
+	// indexed and for entries recorded before it was journaled.
+	File *FileRecord
+	// Undone is set once the entry has been reversed by undo.
+	Undone bool
+}
+
+// JournalSession summarizes the entries recorded by one session.
+type JournalSession struct {
+	ID        string
+	StartedAt time.Time
+	Entries   int
+	Undone    int
+	// Irreversible counts the entries that cannot be undone, such as
+	// permanent deletions.
+	Irreversible int
+}
+
+// Undoable returns the number of entries left to undo.
+func (s JournalSession) Undoable() int {
+	return s.Entries - s.Undone - s.Irreversible
+}
+
+// AddJournalEntry records a file operation.
+func (d *DB) AddJournalEntry(e JournalEntry) error {
+	createdAt := e.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	var file sql.NullString
+	if e.File != nil {
+		b, err := json.Marshal(e.File)
+		if err != nil {
+			return err
+		}
+		file = sql.NullString{String: string(b), Valid: true}
+	}
+	_, err := d.conn.Exec(`
+		INSERT INTO journal (session_id, created_at, action, source, destination, code, info_path, file)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.SessionID, createdAt, e.Action, e.Source, nullString(e.Destination), nullString(e.Code), nullString(e.InfoPath), file)
+	return err
+}
+
+// JournalEntries returns the entries of a session in the order they were recorded.
+func (d *DB) JournalEntries(sessionID string) ([]JournalEntry, error) {
+	rows, err := d.conn.Query(`
+		SELECT id, session_id, created_at, action, source, destination, code, info_path, file, undone_at
+		FROM journal
+		WHERE session_id = ?
+		ORDER BY id
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var entries []JournalEntry
+	for rows.Next() {
+		var e JournalEntry
+		var destination, code, infoPath, file sql.NullString
+		var undoneAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.CreatedAt, &e.Action, &e.Source, &destination, &code, &infoPath, &file, &undoneAt); err != nil {
+			return nil, err
+		}
+		if file.Valid {
+			e.File = &FileRecord{}
+			if err := json.Unmarshal([]byte(file.String), e.File); err != nil {
+				return nil, err
+			}
+		}
+		e.Destination = destination.String
+		e.Code = code.String
+		e.InfoPath = infoPath.String
+		e.Undone = undoneAt.Valid
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// JournalSessions returns all sessions, most recent first.
+func (d *DB) JournalSessions() ([]JournalSession, error) {
+	rows, err := d.conn.Query(`
+		SELECT session_id, MIN(id), COUNT(*), COUNT(undone_at),
+			COUNT(CASE WHEN action = ? OR destination IS NULL THEN 1 END)
+		FROM journal
+		GROUP BY session_id
+		ORDER BY MIN(id) DESC
+	`, ActionDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []JournalSession
+	var firstIDs []int64
+	for rows.Next() {
+		var s JournalSession
+		var firstID int64
+		if err := rows.Scan(&s.ID, &firstID, &s.Entries, &s.Undone, &s.Irreversible); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		sessions = append(sessions, s)
+		firstIDs = append(firstIDs, firstID)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	_ = rows.Close()
+
+	// Aggregates lose the column type, so read the start time separately
+	for i := range sessions {
+		if err := d.conn.QueryRow("SELECT created_at FROM journal WHERE id = ?", firstIDs[i]).Scan(&sessions[i].StartedAt); err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+// MarkUndone marks a journal entry as reversed.
+func (d *DB) MarkUndone(id int64) error {
+	_, err := d.conn.Exec("UPDATE journal SET undone_at = ? WHERE id = ?", time.Now(), id)
+	return err
+}
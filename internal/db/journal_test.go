@@ -0,0 +1,49 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalSessionsCountIrreversibleEntries(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	entries := []JournalEntry{
+		{SessionID: "old", Action: ActionTrash, Source: "/a/1.jpg", Destination: "/trash/1.jpg"},
+		{SessionID: "new", Action: ActionMove, Source: "/a/2.jpg", Destination: "/b/2.jpg"},
+		{SessionID: "new", Action: ActionDelete, Source: "/a/3.jpg"},
+	}
+	for _, e := range entries {
+		if err := database.AddJournalEntry(e); err != nil {
+			t.Fatalf("failed to add journal entry: %v", err)
+		}
+	}
+	recorded, err := database.JournalEntries("new")
+	if err != nil {
+		t.Fatalf("failed to read journal: %v", err)
+	}
+	if err := database.MarkUndone(recorded[0].ID); err != nil {
+		t.Fatalf("failed to mark entry undone: %v", err)
+	}
+
+	sessions, err := database.JournalSessions()
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "new" || sessions[1].ID != "old" {
+		t.Fatalf("expected sessions new and old, got %+v", sessions)
+	}
+
+	// Only the permanent deletion is left, so nothing can be undone
+	s := sessions[0]
+	if s.Entries != 2 || s.Undone != 1 || s.Irreversible != 1 || s.Undoable() != 0 {
+		t.Errorf("expected 2 entries, 1 undone, 1 irreversible, got %+v", s)
+	}
+	if sessions[1].Undoable() != 1 {
+		t.Errorf("expected 1 undoable entry in the old session, got %+v", sessions[1])
+	}
+}
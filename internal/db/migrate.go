@@ -0,0 +1,269 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration upgrades the schema by one version.
+// Migrations must be safe to run on databases created before versioning
+// existed, where some of their changes may already be present.
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in order. Append new migrations to
+// the end; never edit or reorder released ones.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create codes and files tables",
+		apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS codes (
+					code TEXT PRIMARY KEY,
+					created_at DATETIME
+				);
+
+				CREATE TABLE IF NOT EXISTS files (
+					path TEXT PRIMARY KEY,
+					code TEXT REFERENCES codes(code),
+					size INTEGER,
+					mtime DATETIME,
+					created_at DATETIME
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_code ON files(code);
+				CREATE INDEX IF NOT EXISTS idx_size ON files(size);
+			`)
+			return err
+		},
+	},
+	{
+		version:     2,
+		description: "add content hash columns to files",
+		apply: func(tx *sql.Tx) error {
+			if err := ensureColumn(tx, "files", "partial_hash", "TEXT"); err != nil {
+				return err
+			}
+			return ensureColumn(tx, "files", "hash", "TEXT")
+		},
+	},
+	{
+		version:     3,
+		description: "create journal table",
+		apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE journal (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					session_id TEXT NOT NULL,
+					created_at DATETIME,
+					action TEXT NOT NULL,
+					source TEXT NOT NULL,
+					destination TEXT,
+					code TEXT,
+					info_path TEXT,
+					undone_at DATETIME
+				);
+
+				CREATE INDEX idx_journal_session ON journal(session_id);
+			`)
+			return err
+		},
+	},
+	{
+		version:     4,
+		description: "add scan root column to files",
+		apply: func(tx *sql.Tx) error {
+			return ensureColumn(tx, "files", "root", "TEXT")
+		},
+	},
+	{
+		version:     5,
+		description: "create volumes table and add volume column to files",
+		apply: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`
+				CREATE TABLE volumes (
+					uuid TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					path TEXT NOT NULL,
+					last_seen DATETIME
+				);
+			`); err != nil {
+				return err
+			}
+			return ensureColumn(tx, "files", "volume", "TEXT")
+		},
+	},
+	{
+		version:     6,
+		description: "create file_codes table for files with several codes",
+		apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				CREATE TABLE file_codes (
+					path TEXT NOT NULL,
+					code TEXT NOT NULL,
+					PRIMARY KEY (path, code)
+				);
+
+				CREATE INDEX idx_file_codes_code ON file_codes(code);
+
+				INSERT INTO file_codes (path, code)
+				SELECT path, code FROM files WHERE code IS NOT NULL;
+			`)
+			return err
+		},
+	},
+	{
+		version:     7,
+		description: "record the code found in the name of aliased codes",
+		apply: func(tx *sql.Tx) error {
+			return ensureColumn(tx, "file_codes", "alias_of", "TEXT")
+		},
+	},
+	{
+		version:     8,
+		description: "record the index record of removed files in the journal",
+		apply: func(tx *sql.Tx) error {
+			return ensureColumn(tx, "journal", "file", "TEXT")
+		},
+	},
+}
+
+// SchemaVersion returns the newest schema version this binary supports.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaTooNewError is returned by Open when the database was written by a
+// newer version of fdup.
+type SchemaTooNewError struct {
+	Version   int
+	Supported int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than supported version %d; upgrade fdup", e.Version, e.Supported)
+}
+
+// Version returns the current schema version of the database.
+// Databases created before versioning existed report 0.
+func (d *DB) Version() (int, error) {
+	if _, err := d.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT,
+			applied_at DATETIME
+		)
+	`); err != nil {
+		return 0, err
+	}
+	return currentVersion(d.conn)
+}
+
+// migrate applies all pending migrations, each in its own transaction.
+func (d *DB) migrate() error {
+	version, err := d.Version()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion() {
+		return &SchemaTooNewError{Version: version, Supported: SchemaVersion()}
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := d.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+func (d *DB) applyMigration(m migration) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Another process may have migrated since we checked
+	version, err := currentVersion(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if m.version <= version {
+		return tx.Rollback()
+	}
+
+	if err := m.apply(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+		m.version, m.description, time.Now(),
+	); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func currentVersion(q queryer) (int, error) {
+	var version sql.NullInt64
+	if err := q.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// ensureColumn adds a column to an existing table if it is missing.
+func ensureColumn(q queryer, table, column, typ string) error {
+	rows, err := q.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	if found {
+		return nil
+	}
+	_, err = q.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + typ)
+	return err
+}
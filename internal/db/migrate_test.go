@@ -0,0 +1,99 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenCreatesSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	version, err := database.Version()
+	if err != nil {
+		t.Fatalf("failed to get version: %v", err)
+	}
+	if version != SchemaVersion() {
+		t.Errorf("expected version %d, got %d", SchemaVersion(), version)
+	}
+
+	if err := database.InsertFile(FileRecord{Path: "/a/DSC00001.jpg", Code: "DSC00001", Mtime: time.Now()}); err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+}
+
+func TestOpenMigratesLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	// Schema written by versions before migrations existed
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = conn.Exec(`
+		CREATE TABLE codes (code TEXT PRIMARY KEY, created_at DATETIME);
+		CREATE TABLE files (
+			path TEXT PRIMARY KEY,
+			code TEXT REFERENCES codes(code),
+			size INTEGER,
+			mtime DATETIME,
+			created_at DATETIME
+		);
+		INSERT INTO files (path, code, size, mtime, created_at)
+		VALUES ('/a/DSC00001.jpg', 'DSC00001', 10, '2024-01-01 00:00:00+00:00', '2024-01-01 00:00:00+00:00');
+	`)
+	if err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	_ = conn.Close()
+
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open legacy database: %v", err)
+	}
+	defer database.Close()
+
+	files, err := database.ListFiles()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0].Code != "DSC00001" {
+		t.Errorf("expected legacy record to be kept, got %+v", files)
+	}
+
+	if err := database.SetHashes("/a/DSC00001.jpg", "p", "h"); err != nil {
+		t.Errorf("expected hash columns to exist: %v", err)
+	}
+}
+
+func TestOpenRejectsNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = database.conn.Exec(
+		"INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+		SchemaVersion()+1, "from the future", time.Now(),
+	)
+	if err != nil {
+		t.Fatalf("failed to bump version: %v", err)
+	}
+	_ = database.Close()
+
+	_, err = Open(dbPath)
+	var tooNew *SchemaTooNewError
+	if !errors.As(err, &tooNew) {
+		t.Fatalf("expected SchemaTooNewError, got %v", err)
+	}
+	if tooNew.Version != SchemaVersion()+1 {
+		t.Errorf("expected version %d, got %d", SchemaVersion()+1, tooNew.Version)
+	}
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// GroupQuery selects a page of duplicate groups.
+type GroupQuery struct {
+	// Filter limits the files considered; groups are formed among them.
+	Filter FileFilter
+	// Code selects the group of one code.
+	Code string
+	// CodePrefix selects groups whose code starts with it.
+	CodePrefix string
+	// Under selects groups with a file below the directory.
+	Under string
+	// Limit is the most groups returned, after skipping Offset groups.
+	// Zero means no limit.
+	Limit  int
+	Offset int
+}
+
+// FileQuery selects a page of indexed files.
+type FileQuery struct {
+	Filter FileFilter
+	// CodePrefix selects files with a code starting with it.
+	CodePrefix string
+	// Under selects files below the directory.
+	Under  string
+	Limit  int
+	Offset int
+}
+
+// DuplicateStats summarizes the duplicate groups of the files selected by
+// a filter.
+type DuplicateStats struct {
+	Files     int
+	TotalSize int64
+	Groups    int
+	// DuplicateFiles counts the files in any group, once each.
+	DuplicateFiles int
+	// ReclaimableSize is the size of every file of each group but its
+	// largest one.
+	ReclaimableSize int64
+}
+
+// dirOf is the SQL expression of the directory of f.path, with a trailing
+// separator.
+const dirOf = "rtrim(f.path, replace(f.path, '" + string(filepath.Separator) + "', ''))"
+
+// duplicateCodes returns a WITH clause defining "selected", the paths the
+// filter selects, and "dups", the codes of the duplicate groups of q, and
+// its arguments. Limit and Offset are not applied.
+func duplicateCodes(q GroupQuery) (string, []interface{}) {
+	cond, args := q.Filter.where()
+	if cond == "" {
+		cond = "1"
+	}
+	with := `
+		WITH selected AS (SELECT f.path FROM files f WHERE ` + cond + `),
+		dups AS (
+			SELECT fc.code FROM file_codes fc
+			JOIN files f ON f.path = fc.path
+			WHERE fc.path IN selected`
+	if q.Code != "" {
+		with += " AND fc.code = ?"
+		args = append(args, q.Code)
+	}
+	if q.CodePrefix != "" {
+		with += ` AND fc.code LIKE ? ESCAPE '\'`
+		args = append(args, escapeLike(q.CodePrefix)+"%")
+	}
+	// Duplicates are files with the same code in different directories
+	with += `
+			GROUP BY fc.code
+			HAVING COUNT(DISTINCT ` + dirOf + `) > 1`
+	if q.Under != "" {
+		with += " AND SUM(instr(f.path, ?) = 1) > 0"
+		args = append(args, underPrefix(q.Under))
+	}
+	with += `
+		)`
+	return with, args
+}
+
+// underPrefix returns the prefix of the paths below dir.
+func underPrefix(dir string) string {
+	return strings.TrimSuffix(filepath.Clean(dir), string(filepath.Separator)) + string(filepath.Separator)
+}
+
+// FindDuplicatesPage returns the duplicate groups selected by q, ordered by
+// code, and the number of groups selected before Limit and Offset apply.
+func (d *DB) FindDuplicatesPage(q GroupQuery) ([]DuplicateGroup, int, error) {
+	with, args := duplicateCodes(q)
+
+	var total int
+	if err := d.conn.QueryRow(with+" SELECT COUNT(*) FROM dups", args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+
+	page := "SELECT code FROM dups ORDER BY code"
+	if q.Limit > 0 {
+		page += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit, q.Offset)
+	}
+	groups, err := d.queryGroups(with+`, page AS (`+page+`)
+		SELECT f.path, fc.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+		FROM file_codes fc
+		JOIN files f ON f.path = fc.path
+		WHERE fc.path IN selected AND fc.code IN page
+		ORDER BY fc.code, f.path
+	`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+// ListFilesPage returns the files selected by q, ordered by path, and the
+// number of files selected before Limit and Offset apply.
+func (d *DB) ListFilesPage(q FileQuery) ([]FileRecord, int, error) {
+	cond, args := q.Filter.where()
+	conds := []string{}
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	if q.Under != "" {
+		conds = append(conds, "instr(f.path, ?) = 1")
+		args = append(args, underPrefix(q.Under))
+	}
+	if q.CodePrefix != "" {
+		conds = append(conds, `f.path IN (SELECT path FROM file_codes WHERE code LIKE ? ESCAPE '\')`)
+		args = append(args, escapeLike(q.CodePrefix)+"%")
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := d.conn.QueryRow("SELECT COUNT(*) FROM files f"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+
+	query := `
+		SELECT f.path, f.code, f.size, f.mtime, f.created_at, f.partial_hash, f.hash, f.root, f.volume
+		FROM files f` + where + " ORDER BY f.path"
+	if q.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit, q.Offset)
+	}
+	rows, err := d.conn.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []FileRecord
+	for rows.Next() {
+		rec, err := scanFile(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if err := d.attachCodes(records); err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+// Stats summarizes the files selected by filter and their duplicate groups.
+func (d *DB) Stats(filter FileFilter) (*DuplicateStats, error) {
+	with, args := duplicateCodes(GroupQuery{Filter: filter})
+	var stats DuplicateStats
+	err := d.conn.QueryRow(with+`,
+		members AS (
+			SELECT fc.code, f.path, f.size FROM file_codes fc
+			JOIN files f ON f.path = fc.path
+			WHERE fc.path IN selected AND fc.code IN dups
+		)
+		SELECT
+			(SELECT COUNT(*) FROM files f WHERE f.path IN selected),
+			(SELECT COALESCE(SUM(f.size), 0) FROM files f WHERE f.path IN selected),
+			(SELECT COUNT(*) FROM dups),
+			(SELECT COUNT(DISTINCT path) FROM members),
+			(SELECT COALESCE(SUM(size), 0) FROM members) -
+				(SELECT COALESCE(SUM(largest), 0) FROM (SELECT MAX(size) AS largest FROM members GROUP BY code))
+	`, args...).Scan(&stats.Files, &stats.TotalSize, &stats.Groups, &stats.DuplicateFiles, &stats.ReclaimableSize)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupPageDB returns a database with the duplicate groups ABC001 (in /a
+// and /b), ABC002 (in /a and /c, one file also coded XYZ001) and XYZ001 (in
+// /b and /c), and ABC003, whose two files share a directory.
+func setupPageDB(t *testing.T) *DB {
+	t.Helper()
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := database.InsertFiles([]FileRecord{
+		{Path: "/a/ABC001.jpg", Code: "ABC001", Size: 10, Mtime: mtime},
+		{Path: "/b/ABC001.jpg", Code: "ABC001", Size: 30, Mtime: mtime},
+		{Path: "/a/ABC002.jpg", Code: "ABC002", Size: 20, Mtime: mtime},
+		{Path: "/c/ABC002_XYZ001.jpg", Code: "ABC002", Codes: []string{"ABC002", "XYZ001"}, Size: 20, Mtime: mtime},
+		{Path: "/b/XYZ001.mp4", Code: "XYZ001", Size: 100, Mtime: mtime},
+		{Path: "/d/ABC003.jpg", Code: "ABC003", Size: 5, Mtime: mtime},
+		{Path: "/d/ABC003 copy.jpg", Code: "ABC003", Size: 5, Mtime: mtime},
+	}); err != nil {
+		t.Fatalf("failed to insert files: %v", err)
+	}
+	return database
+}
+
+func groupCodes(groups []DuplicateGroup) []string {
+	codes := make([]string, len(groups))
+	for i, g := range groups {
+		codes[i] = g.Code
+	}
+	return codes
+}
+
+func TestFindDuplicatesPage(t *testing.T) {
+	database := setupPageDB(t)
+
+	tests := []struct {
+		name  string
+		query GroupQuery
+		want  []string
+		total int
+	}{
+		{"all", GroupQuery{}, []string{"ABC001", "ABC002", "XYZ001"}, 3},
+		{"page", GroupQuery{Limit: 2, Offset: 1}, []string{"ABC002", "XYZ001"}, 3},
+		{"past the end", GroupQuery{Limit: 2, Offset: 4}, nil, 3},
+		{"code", GroupQuery{Code: "XYZ001"}, []string{"XYZ001"}, 1},
+		{"code prefix", GroupQuery{CodePrefix: "ABC"}, []string{"ABC001", "ABC002"}, 2},
+		{"under", GroupQuery{Under: "/c/"}, []string{"ABC002", "XYZ001"}, 2},
+		{"filter", GroupQuery{Filter: FileFilter{MinSize: 20}}, []string{"ABC002", "XYZ001"}, 2},
+		{"same directory", GroupQuery{Code: "ABC003"}, nil, 0},
+	}
+	for _, tt := range tests {
+		groups, total, err := database.FindDuplicatesPage(tt.query)
+		if err != nil {
+			t.Fatalf("%s: failed to find duplicates: %v", tt.name, err)
+		}
+		got := groupCodes(groups)
+		if total != tt.total || len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v of %d, got %v of %d", tt.name, tt.want, tt.total, got, total)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+
+	// Files carry every code, as with FindDuplicates
+	groups, _, err := database.FindDuplicatesPage(GroupQuery{Code: "XYZ001"})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 2 || len(groups[0].Files[1].Codes) != 2 {
+		t.Errorf("expected the codes of the multi-code file, got %+v", groups)
+	}
+}
+
+func TestListFilesPage(t *testing.T) {
+	database := setupPageDB(t)
+
+	files, total, err := database.ListFilesPage(FileQuery{CodePrefix: "XYZ", Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if total != 2 || len(files) != 1 || files[0].Path != "/b/XYZ001.mp4" {
+		t.Errorf("expected the first of 2 files, got %+v of %d", files, total)
+	}
+
+	files, total, err = database.ListFilesPage(FileQuery{Under: "/c"})
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if total != 1 || len(files) != 1 || len(files[0].Codes) != 2 {
+		t.Errorf("expected the multi-code file, got %+v of %d", files, total)
+	}
+}
+
+func TestStats(t *testing.T) {
+	database := setupPageDB(t)
+
+	stats, err := database.Stats(FileFilter{})
+	if err != nil {
+		t.Fatalf("failed to read stats: %v", err)
+	}
+	// The multi-code file is counted once but reclaimable in each group
+	want := DuplicateStats{Files: 7, TotalSize: 190, Groups: 3, DuplicateFiles: 5, ReclaimableSize: 10 + 20 + 20}
+	if *stats != want {
+		t.Errorf("expected %+v, got %+v", want, *stats)
+	}
+}
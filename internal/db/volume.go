@@ -0,0 +1,74 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Volume is a named volume seen by a scan.
+type Volume struct {
+	UUID string
+	Name string
+	// Path is where the volume was last mounted.
+	Path     string
+	LastSeen time.Time
+	// Files is the number of indexed files on the volume.
+	Files int
+}
+
+// SaveVolume records that a volume was seen mounted at its path now.
+func (d *DB) SaveVolume(uuid, name, path string) error {
+	_, err := d.conn.Exec(`
+		INSERT INTO volumes (uuid, name, path, last_seen)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(uuid) DO UPDATE SET name = excluded.name, path = excluded.path, last_seen = excluded.last_seen
+	`, uuid, name, path, time.Now())
+	return err
+}
+
+// Volumes returns all known volumes ordered by name.
+func (d *DB) Volumes() ([]Volume, error) {
+	rows, err := d.conn.Query(`
+		SELECT v.uuid, v.name, v.path, v.last_seen, COUNT(f.path)
+		FROM volumes v
+		LEFT JOIN files f ON f.volume = v.uuid
+		GROUP BY v.uuid
+		ORDER BY v.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var volumes []Volume
+	for rows.Next() {
+		var v Volume
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&v.UUID, &v.Name, &v.Path, &lastSeen, &v.Files); err != nil {
+			return nil, err
+		}
+		v.LastSeen = lastSeen.Time
+		volumes = append(volumes, v)
+	}
+	return volumes, rows.Err()
+}
+
+// RootVolume returns the UUID of the volume that files under root were last
+// indexed on, or "" if none were on a volume.
+func (d *DB) RootVolume(root string) (string, error) {
+	var uuid string
+	err := d.conn.QueryRow(
+		"SELECT volume FROM files WHERE root = ? AND volume IS NOT NULL LIMIT 1", root,
+	).Scan(&uuid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return uuid, err
+}
+
+// RootFileCount returns the number of indexed files under root.
+func (d *DB) RootFileCount(root string) (int, error) {
+	var count int
+	err := d.conn.QueryRow("SELECT COUNT(*) FROM files WHERE root = ?", root).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// fileWriter applies file changes inside a single transaction using
+// prepared statements. Nothing is visible to readers until commit.
+type fileWriter struct {
+	tx             *sql.Tx
+	insertCode     *sql.Stmt
+	insertFile     *sql.Stmt
+	insertFileCode *sql.Stmt
+	deleteFile     *sql.Stmt
+	deleteCodes    *sql.Stmt
+	now            time.Time
+}
+
+func (d *DB) beginWrite() (*fileWriter, error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	w := &fileWriter{tx: tx, now: time.Now()}
+
+	if w.insertCode, err = tx.Prepare(`
+		INSERT OR IGNORE INTO codes (code, created_at)
+		VALUES (?, ?)
+	`); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	if w.insertFile, err = tx.Prepare(`
+		INSERT OR REPLACE INTO files (path, code, size, mtime, created_at, root, volume)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	if w.insertFileCode, err = tx.Prepare("INSERT OR IGNORE INTO file_codes (path, code, alias_of) VALUES (?, ?, ?)"); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	if w.deleteFile, err = tx.Prepare("DELETE FROM files WHERE path = ?"); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	if w.deleteCodes, err = tx.Prepare("DELETE FROM file_codes WHERE path = ?"); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *fileWriter) insert(record FileRecord) error {
+	codes := record.AllCodes()
+	for _, code := range codes {
+		if _, err := w.insertCode.Exec(code, w.now); err != nil {
+			return err
+		}
+	}
+	if _, err := w.insertFile.Exec(record.Path, record.Code, record.Size, record.Mtime, w.now, nullString(record.Root), nullString(record.Volume)); err != nil {
+		return err
+	}
+
+	// Replace the codes of the file, keeping their order
+	if _, err := w.deleteCodes.Exec(record.Path); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := w.insertFileCode.Exec(record.Path, code, nullString(record.AliasOf[code])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *fileWriter) delete(path string) error {
+	if _, err := w.deleteCodes.Exec(path); err != nil {
+		return err
+	}
+	_, err := w.deleteFile.Exec(path)
+	return err
+}
+
+// deleteOrphanCodes removes codes no longer referenced by any file.
+func (w *fileWriter) deleteOrphanCodes() error {
+	_, err := w.tx.Exec("DELETE FROM codes WHERE code NOT IN (SELECT code FROM file_codes)")
+	return err
+}
+
+func (w *fileWriter) commit() error {
+	return w.tx.Commit()
+}
+
+// rollback discards the transaction. Prepared statements are closed with it.
+func (w *fileWriter) rollback() {
+	_ = w.tx.Rollback()
+}
+
+// InsertFiles inserts or updates many file records in one transaction.
+// Either all records are written or none are.
+func (d *DB) InsertFiles(records []FileRecord) error {
+	w, err := d.beginWrite()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := w.insert(rec); err != nil {
+			w.rollback()
+			return err
+		}
+	}
+	return w.commit()
+}
+
+// ReplaceFiles clears the index and inserts records in one transaction,
+// so the previous index is kept if anything fails. With roots given, only
+// records under those roots (and records without a root) are cleared, so
+// records of offline volumes are kept.
+func (d *DB) ReplaceFiles(records []FileRecord, roots []string) error {
+	b, err := d.BeginReplace(roots)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := b.Add(rec); err != nil {
+			b.Rollback()
+			return err
+		}
+	}
+	_, err = b.Commit()
+	return err
+}
+
+// Sync reconciles the index with the records of a fresh scan.
+// New files are inserted, files whose size, mtime, codes, aliases, root or
+// volume changed are updated, and indexed files missing from records are removed.
+// With roots given, only records under those roots (and records without a
+// root) can be removed, so records of offline volumes are kept.
+// All changes are applied in one transaction.
+func (d *DB) Sync(records []FileRecord, roots []string) (*SyncResult, error) {
+	b, err := d.BeginSync(roots)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if err := b.Add(rec); err != nil {
+			b.Rollback()
+			return nil, err
+		}
+	}
+	return b.Commit()
+}
+
+// Batch writes the records of a scan to the index as they arrive, in one
+// transaction. Nothing is visible to readers until Commit, and Rollback
+// keeps the index as it was.
+type Batch struct {
+	w *fileWriter
+	// indexed holds the records a sync may still remove; nil when replacing
+	indexed map[string]FileRecord
+	result  SyncResult
+}
+
+// BeginSync starts a batch that works like Sync.
+func (d *DB) BeginSync(roots []string) (*Batch, error) {
+	existing, err := d.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	scanned := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		scanned[root] = true
+	}
+
+	indexed := make(map[string]FileRecord, len(existing))
+	for _, rec := range existing {
+		if roots == nil || rec.Root == "" || scanned[rec.Root] {
+			indexed[rec.Path] = rec
+		}
+	}
+
+	w, err := d.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{w: w, indexed: indexed}, nil
+}
+
+// BeginReplace starts a batch that works like ReplaceFiles.
+func (d *DB) BeginReplace(roots []string) (*Batch, error) {
+	w, err := d.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+
+	where := ""
+	args := make([]interface{}, len(roots))
+	if roots != nil {
+		placeholders := make([]string, len(roots))
+		for i, root := range roots {
+			placeholders[i] = "?"
+			args[i] = root
+		}
+		where = " WHERE root IS NULL OR root IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if _, err := w.tx.Exec("DELETE FROM file_codes WHERE path IN (SELECT path FROM files"+where+")", args...); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	if _, err := w.tx.Exec("DELETE FROM files"+where, args...); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	return &Batch{w: w}, nil
+}
+
+// Add writes one record. After an error the batch must be rolled back.
+func (b *Batch) Add(rec FileRecord) error {
+	if b.indexed == nil {
+		if err := b.w.insert(rec); err != nil {
+			return err
+		}
+		b.result.Added++
+		return nil
+	}
+
+	old, ok := b.indexed[rec.Path]
+	delete(b.indexed, rec.Path)
+
+	if ok && old.Code == rec.Code && old.Size == rec.Size && old.Mtime.Equal(rec.Mtime) &&
+		old.Root == rec.Root && old.Volume == rec.Volume && equalCodes(old.AllCodes(), rec.AllCodes()) &&
+		equalAliases(old.AliasOf, rec.AliasOf) {
+		b.result.Unchanged++
+		return nil
+	}
+	if err := b.w.insert(rec); err != nil {
+		return err
+	}
+	if ok {
+		b.result.Updated++
+	} else {
+		b.result.Added++
+	}
+	return nil
+}
+
+// Commit removes the files of a sync that were not added, then commits.
+func (b *Batch) Commit() (*SyncResult, error) {
+	// Whatever is left was not seen by the scan
+	for path := range b.indexed {
+		if err := b.w.delete(path); err != nil {
+			b.w.rollback()
+			return nil, err
+		}
+		b.result.Removed++
+	}
+
+	if b.indexed == nil || b.result.Removed > 0 || b.result.Updated > 0 {
+		if err := b.w.deleteOrphanCodes(); err != nil {
+			b.w.rollback()
+			return nil, err
+		}
+	}
+
+	if err := b.w.commit(); err != nil {
+		return nil, err
+	}
+	return &b.result, nil
+}
+
+// Rollback discards everything written by the batch.
+func (b *Batch) Rollback() {
+	b.w.rollback()
+}
+
+func equalCodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalAliases(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for code, aliasOf := range a {
+		if b[code] != aliasOf {
+			return false
+		}
+	}
+	return true
+}
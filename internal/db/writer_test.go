@@ -0,0 +1,406 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	unchanged := FileRecord{Path: "/a/DSC00001.jpg", Code: "DSC00001", Size: 10, Mtime: mtime, Root: "/a"}
+	modified := FileRecord{Path: "/a/DSC00002.jpg", Code: "DSC00002", Size: 10, Mtime: mtime, Root: "/a"}
+	removed := FileRecord{Path: "/a/DSC00003.jpg", Code: "DSC00003", Size: 10, Mtime: mtime, Root: "/a"}
+
+	result, err := database.Sync([]FileRecord{unchanged, modified, removed}, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if *result != (SyncResult{Added: 3}) {
+		t.Errorf("expected 3 added records, got %+v", result)
+	}
+	for _, rec := range []FileRecord{unchanged, modified} {
+		if err := database.SetHashes(rec.Path, "partial", "full"); err != nil {
+			t.Fatalf("failed to set hashes: %v", err)
+		}
+	}
+
+	// One file is modified, one deleted and one new
+	modified.Size, modified.Mtime = 20, mtime.Add(time.Hour)
+	added := FileRecord{Path: "/a/DSC00004.jpg", Code: "DSC00004", Size: 10, Mtime: mtime, Root: "/a"}
+	result, err = database.Sync([]FileRecord{unchanged, modified, added}, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if *result != (SyncResult{Added: 1, Updated: 1, Removed: 1, Unchanged: 1}) {
+		t.Errorf("expected 1 added, 1 updated, 1 removed and 1 unchanged record, got %+v", result)
+	}
+
+	files, err := database.ListFiles()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(files) != 3 || files[0].Path != unchanged.Path || files[1].Path != modified.Path || files[2].Path != added.Path {
+		t.Fatalf("expected %s, %s and %s, got %+v", unchanged.Path, modified.Path, added.Path, files)
+	}
+	// Unchanged files keep their hashes, updated files must be hashed again
+	if files[0].Hash != "full" {
+		t.Errorf("expected the unchanged file to keep its hash, got %q", files[0].Hash)
+	}
+	if files[1].Size != 20 || !files[1].Mtime.Equal(modified.Mtime) || files[1].Hash != "" {
+		t.Errorf("expected the updated file with size 20, the new mtime and no hash, got %+v", files[1])
+	}
+
+	// A scan that finds nothing new changes nothing
+	result, err = database.Sync([]FileRecord{unchanged, modified, added}, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if *result != (SyncResult{Unchanged: 3}) {
+		t.Errorf("expected 3 unchanged records, got %+v", result)
+	}
+}
+
+// failInsertsOf makes inserting path fail, to check that a batch is
+// written entirely or not at all.
+func failInsertsOf(t *testing.T, database *DB, path string) {
+	t.Helper()
+	if _, err := database.conn.Exec(`
+		CREATE TRIGGER fail_insert BEFORE INSERT ON files
+		WHEN NEW.path = '` + path + `'
+		BEGIN SELECT RAISE(ABORT, 'insert failed'); END
+	`); err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+}
+
+func listPaths(t *testing.T, database *DB) []string {
+	t.Helper()
+	files, err := database.ListFiles()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func TestInsertFiles(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []FileRecord{
+		{Path: "/a/DSC00001.jpg", Code: "DSC00001", Size: 10, Mtime: mtime},
+		{Path: "/b/DSC00001.jpg", Code: "DSC00001", Size: 10, Mtime: mtime},
+		{Path: "/b/IMG0001 DSC00002.jpg", Code: "IMG0001", Codes: []string{"IMG0001", "DSC00002"}, Size: 20, Mtime: mtime},
+	}
+	if err := database.InsertFiles(records); err != nil {
+		t.Fatalf("failed to insert files: %v", err)
+	}
+	if paths := listPaths(t, database); len(paths) != 3 {
+		t.Fatalf("expected 3 files, got %v", paths)
+	}
+
+	// Inserting a known path replaces its record and codes
+	if err := database.InsertFiles([]FileRecord{{Path: "/b/IMG0001 DSC00002.jpg", Code: "IMG0001", Size: 30, Mtime: mtime}}); err != nil {
+		t.Fatalf("failed to insert files: %v", err)
+	}
+	rec, err := database.GetFile("/b/IMG0001 DSC00002.jpg")
+	if err != nil || rec == nil {
+		t.Fatalf("expected the file to be indexed: %v", err)
+	}
+	if rec.Size != 30 || len(rec.Codes) != 0 {
+		t.Errorf("expected size 30 and a single code, got %+v", rec)
+	}
+
+	// A failing record leaves the whole batch out
+	failInsertsOf(t, database, "/c/bad.jpg")
+	err = database.InsertFiles([]FileRecord{
+		{Path: "/c/DSC00003.jpg", Code: "DSC00003", Mtime: mtime},
+		{Path: "/c/bad.jpg", Code: "DSC00003", Mtime: mtime},
+	})
+	if err == nil {
+		t.Fatal("expected the insert to fail")
+	}
+	if paths := listPaths(t, database); len(paths) != 3 {
+		t.Errorf("expected the failed batch to insert nothing, got %v", paths)
+	}
+}
+
+func TestReplaceFiles(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := database.InsertFiles([]FileRecord{
+		{Path: "/a/DSC00001.jpg", Code: "DSC00001", Mtime: mtime},
+		{Path: "/a/DSC00002.jpg", Code: "DSC00002", Mtime: mtime},
+	}); err != nil {
+		t.Fatalf("failed to insert files: %v", err)
+	}
+
+	replaced := []FileRecord{
+		{Path: "/a/DSC00001.jpg", Code: "DSC00001", Mtime: mtime},
+		{Path: "/a/DSC00003.jpg", Code: "DSC00003", Mtime: mtime},
+	}
+	if err := database.ReplaceFiles(replaced, nil); err != nil {
+		t.Fatalf("failed to replace files: %v", err)
+	}
+	paths := listPaths(t, database)
+	if len(paths) != 2 || paths[0] != "/a/DSC00001.jpg" || paths[1] != "/a/DSC00003.jpg" {
+		t.Errorf("expected only the new records, got %v", paths)
+	}
+	// Codes no file has any more are dropped
+	var codes int
+	if err := database.conn.QueryRow("SELECT COUNT(*) FROM codes WHERE code = 'DSC00002'").Scan(&codes); err != nil {
+		t.Fatalf("failed to count codes: %v", err)
+	}
+	if codes != 0 {
+		t.Error("expected the code of the removed file to be dropped")
+	}
+
+	// A failing record keeps the previous index
+	failInsertsOf(t, database, "/a/bad.jpg")
+	if err := database.ReplaceFiles([]FileRecord{{Path: "/a/bad.jpg", Code: "DSC00004", Mtime: mtime}}, nil); err == nil {
+		t.Fatal("expected the replace to fail")
+	}
+	if paths := listPaths(t, database); len(paths) != 2 {
+		t.Errorf("expected the previous index to be kept, got %v", paths)
+	}
+}
+
+func TestBatchRollback(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := database.InsertFiles([]FileRecord{{Path: "/a/DSC00001.jpg", Code: "DSC00001", Mtime: mtime}}); err != nil {
+		t.Fatalf("failed to insert files: %v", err)
+	}
+
+	for _, begin := range []func([]string) (*Batch, error){database.BeginSync, database.BeginReplace} {
+		b, err := begin(nil)
+		if err != nil {
+			t.Fatalf("failed to begin batch: %v", err)
+		}
+		if err := b.Add(FileRecord{Path: "/a/DSC00002.jpg", Code: "DSC00002", Mtime: mtime}); err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+		b.Rollback()
+
+		paths := listPaths(t, database)
+		if len(paths) != 1 || paths[0] != "/a/DSC00001.jpg" {
+			t.Errorf("expected the index to be kept, got %v", paths)
+		}
+	}
+}
+
+func TestSyncKeepsRecordsOfOtherRoots(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	local := FileRecord{Path: "/local/a/DSC00001.jpg", Code: "DSC00001", Mtime: mtime, Root: "/local"}
+	archived := FileRecord{Path: "/media/disk/b/DSC00001.jpg", Code: "DSC00001", Mtime: mtime, Root: "/media/disk", Volume: "uuid-1"}
+	if _, err := database.Sync([]FileRecord{local, archived}, nil); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	// The disk is offline, so only the local root is scanned
+	result, err := database.Sync(nil, []string{"/local"})
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Errorf("expected 1 removed record, got %d", result.Removed)
+	}
+
+	files, err := database.ListFiles()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != archived.Path || files[0].Volume != "uuid-1" {
+		t.Errorf("expected only the archived record to be kept, got %+v", files)
+	}
+
+	// A full rebuild of the local root keeps it as well
+	if err := database.ReplaceFiles([]FileRecord{local}, []string{"/local"}); err != nil {
+		t.Fatalf("failed to replace files: %v", err)
+	}
+	count, err := database.GetFileCount()
+	if err != nil {
+		t.Fatalf("failed to count files: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 records after rebuild, got %d", count)
+	}
+}
+
+func TestFileWithSeveralCodes(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []FileRecord{
+		{Path: "/a/ABC00123_vs_XYZ00456.mp4", Code: "ABC00123", Codes: []string{"ABC00123", "XYZ00456"}, Mtime: mtime},
+		{Path: "/b/ABC00123.mp4", Code: "ABC00123", Mtime: mtime},
+		{Path: "/c/XYZ00456.mp4", Code: "XYZ00456", Mtime: mtime},
+	}
+	if _, err := database.Sync(records, nil); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	groups, err := database.FindDuplicates()
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("expected the file to be in 2 groups, got %+v", groups)
+	}
+	for _, g := range groups {
+		if len(g.Files) != 2 || g.Files[0].Path != records[0].Path {
+			t.Errorf("%s: unexpected files %+v", g.Code, g.Files)
+		}
+		if len(g.Files[0].Codes) != 2 {
+			t.Errorf("%s: expected the codes of the file, got %v", g.Code, g.Files[0].Codes)
+		}
+	}
+
+	// Dropping a code removes the file from that group only
+	records[0].Codes = nil
+	result, err := database.Sync(records, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if result.Updated != 1 {
+		t.Errorf("expected 1 updated record, got %d", result.Updated)
+	}
+	groups, err = database.SearchByCode("XYZ00456", true)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 1 {
+		t.Errorf("expected only XYZ00456.mp4 under XYZ00456, got %+v", groups)
+	}
+}
+
+func TestSyncUpdatesAliases(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []FileRecord{
+		{Path: "/a/OLD123.zip", Code: "NEW123", AliasOf: map[string]string{"NEW123": "OLD123"}, Mtime: mtime},
+		{Path: "/b/NEW123.zip", Code: "NEW123", Mtime: mtime},
+	}
+	if _, err := database.Sync(records, nil); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	groups, err := database.FindDuplicates()
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Files[0].AliasOf["NEW123"] != "OLD123" || groups[0].Files[1].AliasOf != nil {
+		t.Fatalf("expected the aliased file to be marked, got %+v", groups)
+	}
+
+	// Removing the alias moves the file back to its own code
+	records[0].Code = "OLD123"
+	records[0].AliasOf = nil
+	result, err := database.Sync(records, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if result.Updated != 1 {
+		t.Errorf("expected 1 updated record, got %d", result.Updated)
+	}
+	rec, err := database.GetFile("/a/OLD123.zip")
+	if err != nil || rec == nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	if rec.Code != "OLD123" || rec.AliasOf != nil {
+		t.Errorf("expected the alias to be gone, got %+v", rec)
+	}
+}
+
+func TestFindDuplicatesMatching(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []FileRecord{
+		{Path: "/a/DSC00001.MP4", Code: "DSC00001", Size: 2048, Mtime: mtime},
+		{Path: "/b/DSC00001.mp4", Code: "DSC00001", Size: 4096, Mtime: mtime},
+		{Path: "/b/DSC00001.xmp", Code: "DSC00001", Size: 10, Mtime: mtime},
+		{Path: "/a/DSC00002.mp4", Code: "DSC00002", Size: 0, Mtime: mtime},
+		{Path: "/b/DSC00002.mp4", Code: "DSC00002", Size: 0, Mtime: mtime},
+	}
+	if _, err := database.Sync(records, nil); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter FileFilter
+		want   map[string]int
+	}{
+		{"no filter", FileFilter{}, map[string]int{"DSC00001": 3, "DSC00002": 2}},
+		{"min size", FileFilter{MinSize: 1}, map[string]int{"DSC00001": 3}},
+		{"extension", FileFilter{Extensions: []string{"mp4"}}, map[string]int{"DSC00001": 2, "DSC00002": 2}},
+		{"size range", FileFilter{MinSize: 1, MaxSize: 4096, Extensions: []string{".mp4"}}, map[string]int{"DSC00001": 2}},
+		// One file left is not a duplicate
+		{"max size", FileFilter{MinSize: 1, MaxSize: 2048}, map[string]int{"DSC00001": 2}},
+		{"too small", FileFilter{MinSize: 3000}, map[string]int{}},
+	}
+	for _, tt := range tests {
+		groups, err := database.FindDuplicatesMatching(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: failed to find duplicates: %v", tt.name, err)
+		}
+		got := make(map[string]int)
+		for _, g := range groups {
+			got[g.Code] = len(g.Files)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for code, n := range tt.want {
+			if got[code] != n {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+}
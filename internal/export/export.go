@@ -0,0 +1,183 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jiikko/fdup/internal/code"
+	"github.com/jiikko/fdup/internal/db"
+)
+
+// Supported output formats.
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+)
+
+// Formats lists the structured formats handled by Write.
+var Formats = []string{FormatJSON, FormatNDJSON, FormatCSV, FormatTSV}
+
+// Content values for verified groups.
+const (
+	ContentIdentical = "identical"
+	ContentDifferent = "different"
+)
+
+// Group is the exported form of a duplicate group.
+// The JSON field names are part of the documented output schema.
+type Group struct {
+	Code          string `json:"code"`
+	FormattedCode string `json:"formatted_code"`
+	Files         []File `json:"files"`
+}
+
+// File is the exported form of a file in a duplicate group.
+type File struct {
+	Path      string `json:"path"`
+	Directory string `json:"directory"`
+	Size      int64  `json:"size"`
+	Mtime     string `json:"mtime"`
+	// Root is the scan root the file was found under.
+	Root string `json:"root,omitempty"`
+	// AliasOf is the code found in the name when the file is in the group
+	// through an alias.
+	AliasOf string `json:"alias_of,omitempty"`
+	// Content is set only for verified groups: "identical" or "different".
+	Content string `json:"content,omitempty"`
+	// ContentGroup numbers the identical subgroups of a verified group,
+	// starting at 1. Files with different content have no number.
+	ContentGroup int `json:"content_group,omitempty"`
+}
+
+// columns is the header of CSV and TSV output, one row per file.
+var columns = []string{"code", "formatted_code", "path", "directory", "size", "mtime", "content", "content_group", "root", "alias_of"}
+
+// IsSupported reports whether format is one of Formats.
+func IsSupported(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert builds the exported form of duplicate groups, formatting codes
+// with display.
+func Convert(groups []db.DuplicateGroup, display *code.Display) []Group {
+	result := make([]Group, 0, len(groups))
+	for _, group := range groups {
+		g := Group{
+			Code:          group.Code,
+			FormattedCode: display.Format(group.Code),
+			Files:         make([]File, 0, len(group.Files)),
+		}
+
+		if group.Verified() {
+			n := 0
+			for _, sg := range group.Subgroups {
+				content, number := ContentDifferent, 0
+				if sg.Identical {
+					n++
+					content, number = ContentIdentical, n
+				}
+				for _, f := range sg.Files {
+					file := ConvertFile(f, group.Code)
+					file.Content = content
+					file.ContentGroup = number
+					g.Files = append(g.Files, file)
+				}
+			}
+		} else {
+			for _, f := range group.Files {
+				g.Files = append(g.Files, ConvertFile(f, group.Code))
+			}
+		}
+
+		result = append(result, g)
+	}
+	return result
+}
+
+// ConvertFile builds the exported form of a file as a member of the group
+// of groupCode.
+func ConvertFile(f db.FileRecord, groupCode string) File {
+	return File{
+		Path:      f.Path,
+		Directory: filepath.Dir(f.Path),
+		Size:      f.Size,
+		Mtime:     f.Mtime.UTC().Format(time.RFC3339),
+		Root:      f.Root,
+		AliasOf:   f.AliasOf[groupCode],
+	}
+}
+
+// Write writes duplicate groups to w in the given structured format,
+// formatting codes with display.
+func Write(w io.Writer, format string, groups []db.DuplicateGroup, display *code.Display) error {
+	exported := Convert(groups, display)
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exported)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, g := range exported {
+			if err := enc.Encode(g); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		return writeDelimited(w, ',', exported)
+	case FormatTSV:
+		return writeDelimited(w, '\t', exported)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+func writeDelimited(w io.Writer, comma rune, groups []Group) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		for _, f := range g.Files {
+			contentGroup := ""
+			if f.ContentGroup > 0 {
+				contentGroup = strconv.Itoa(f.ContentGroup)
+			}
+			row := []string{
+				g.Code,
+				g.FormattedCode,
+				f.Path,
+				f.Directory,
+				strconv.FormatInt(f.Size, 10),
+				f.Mtime,
+				f.Content,
+				contentGroup,
+				f.Root,
+				f.AliasOf,
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,92 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testGroups covers a plain group with an aliased file and a verified
+// group with identical and different content.
+func testGroups() []db.DuplicateGroup {
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	plain := []db.FileRecord{
+		{Path: "/photos/2024/DSC00001.jpg", Code: "DSC00001", Size: 1024, Mtime: mtime, Root: "/photos"},
+		{Path: "/backup/old, \"copy\"/DSC1.jpg", Code: "DSC00001", Size: 2048, Mtime: mtime,
+			Codes: []string{"DSC00001"}, AliasOf: map[string]string{"DSC00001": "DSC1"}},
+	}
+	identical := []db.FileRecord{
+		{Path: "/photos/a/IMG0002.jpg", Code: "IMG0002", Size: 10, Mtime: mtime, Root: "/photos"},
+		{Path: "/photos/b/IMG0002.jpg", Code: "IMG0002", Size: 10, Mtime: mtime, Root: "/photos"},
+	}
+	different := []db.FileRecord{
+		{Path: "/photos/c/IMG0002.jpg", Code: "IMG0002", Size: 10, Mtime: mtime, Root: "/photos"},
+	}
+	return []db.DuplicateGroup{
+		{Code: "DSC00001", Files: plain},
+		{
+			Code:  "IMG0002",
+			Files: append(append([]db.FileRecord{}, identical...), different...),
+			Subgroups: []db.ContentGroup{
+				{Identical: true, Hash: "h1", Files: identical},
+				{Files: different},
+			},
+		},
+	}
+}
+
+func TestWriteGolden(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, testGroups(), nil); err != nil {
+				t.Fatalf("failed to write %s: %v", format, err)
+			}
+
+			golden := filepath.Join("testdata", "groups."+format)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatalf("failed to update %s: %v", golden, err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", golden, err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Errorf("%s output differs from %s:\n%s", format, golden, got)
+			}
+		})
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	want := map[string]string{
+		FormatJSON:   "[]\n",
+		FormatNDJSON: "",
+		FormatCSV:    "code,formatted_code,path,directory,size,mtime,content,content_group,root,alias_of\n",
+		FormatTSV:    "code\tformatted_code\tpath\tdirectory\tsize\tmtime\tcontent\tcontent_group\troot\talias_of\n",
+	}
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		if err := Write(&buf, format, nil, nil); err != nil {
+			t.Fatalf("failed to write %s: %v", format, err)
+		}
+		if buf.String() != want[format] {
+			t.Errorf("expected %s output %q, got %q", format, want[format], buf.String())
+		}
+	}
+}
+
+func TestWriteUnsupported(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, FormatText, nil, nil); err == nil {
+		t.Error("expected an error for the text format")
+	}
+}
@@ -0,0 +1,335 @@
+package fileops
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/sidecar"
+	"github.com/jiikko/fdup/internal/trash"
+)
+
+// rename and moveToTrash move files; tests replace them to make a move fail.
+var (
+	rename      = os.Rename
+	moveToTrash = trash.Move
+)
+
+// Executor performs file operations, keeps the index in sync and records
+// every operation in the journal under one session so it can be undone.
+type Executor struct {
+	database *db.DB
+	session  string
+	sidecars *sidecar.Rules
+}
+
+// New creates an executor with a new session. database may be nil, in which
+// case nothing is indexed or journaled.
+func New(database *db.DB) *Executor {
+	return &Executor{database: database, session: NewSessionID()}
+}
+
+// NewSessionID returns a session ID that sorts by creation time.
+func NewSessionID() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Session returns the journal session ID of the executor.
+func (e *Executor) Session() string {
+	return e.session
+}
+
+// SetSidecars sets the rules of sidecar files, which are removed and moved
+// together with the file they belong to.
+func (e *Executor) SetSidecars(rules *sidecar.Rules) {
+	e.sidecars = rules
+}
+
+// Sidecars returns the sidecar files that operations on path also apply to.
+func (e *Executor) Sidecars(path string) []string {
+	sidecars, _ := e.sidecars.Of(path)
+	return sidecars
+}
+
+// SidecarCache returns a cache that finds sidecars as Sidecars does,
+// reading each directory once, for listing many files. Operations always
+// read the directory again.
+func (e *Executor) SidecarCache() *sidecar.Cache {
+	return e.sidecars.Cache()
+}
+
+// Remove deletes a file or directory item, or moves it to the trash when
+// useTrash is set, and removes it from the index. The sidecars of the file
+// are removed with it, each in its own journal entry so undo restores them.
+// With the trash, a file and its sidecars go together: if one cannot be
+// trashed, those already trashed are restored and nothing is recorded.
+// Permanent deletion cannot be taken back, so every file is checked first
+// and a failure names the files that are left.
+func (e *Executor) Remove(path string, useTrash bool) error {
+	paths := append([]string{path}, e.Sidecars(path)...)
+	if !useTrash {
+		return e.delete(paths)
+	}
+
+	items := make([]*trash.Item, 0, len(paths))
+	for _, p := range paths {
+		item, err := moveToTrash(p)
+		if err != nil {
+			err = bundleError(path, p, err)
+			for i := len(items) - 1; i >= 0; i-- {
+				if rerr := trash.Restore(*items[i]); rerr != nil {
+					err = fmt.Errorf("%w; %s is left in the trash: %v", err, items[i].OriginalPath, rerr)
+				}
+			}
+			return err
+		}
+		items = append(items, item)
+	}
+
+	for _, item := range items {
+		rec := e.indexed(item.OriginalPath)
+		e.record(db.JournalEntry{
+			Action:      db.ActionTrash,
+			Source:      item.OriginalPath,
+			Destination: item.Path,
+			Code:        codeOfRecord(rec),
+			InfoPath:    item.InfoPath,
+			File:        rec,
+		})
+		e.unindex(item.OriginalPath)
+	}
+	return nil
+}
+
+// delete permanently removes paths, the first being the file the others
+// are sidecars of.
+func (e *Executor) delete(paths []string) error {
+	for _, p := range paths {
+		if _, err := os.Lstat(p); err != nil {
+			return bundleError(paths[0], p, err)
+		}
+	}
+
+	for i, p := range paths {
+		rec := e.indexed(p)
+		remove := os.Remove
+		// Directory items are removed with their contents
+		if info, err := os.Lstat(p); err == nil && info.IsDir() {
+			remove = os.RemoveAll
+		}
+		if err := remove(p); err != nil {
+			err = bundleError(paths[0], p, err)
+			if i > 0 {
+				err = fmt.Errorf("%w; %d of %d files were already deleted", err, i, len(paths))
+			}
+			return err
+		}
+		e.record(db.JournalEntry{Action: db.ActionDelete, Source: p, Code: codeOfRecord(rec), File: rec})
+		e.unindex(p)
+	}
+	return nil
+}
+
+// unindex removes path from the index.
+func (e *Executor) unindex(path string) {
+	if e.database != nil {
+		_ = e.database.DeleteFile(path)
+	}
+}
+
+// Move moves a file into destDir, keeping its name, and updates the index.
+// It returns the new path. The sidecars of the file are moved with it: if
+// one cannot be moved, the files already moved are moved back and nothing
+// is recorded. Nothing is moved when the file or a sidecar would replace
+// an existing file; the error then matches os.ErrExist.
+func (e *Executor) Move(path, destDir string) (string, error) {
+	paths := append([]string{path}, e.Sidecars(path)...)
+	if target := conflict(paths, destDir); target != "" {
+		return "", &os.PathError{Op: "move", Path: target, Err: os.ErrExist}
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", err
+	}
+
+	for i, p := range paths {
+		if err := rename(p, filepath.Join(destDir, filepath.Base(p))); err != nil {
+			err = bundleError(path, p, err)
+			for j := i - 1; j >= 0; j-- {
+				moved := filepath.Join(destDir, filepath.Base(paths[j]))
+				if rerr := rename(moved, paths[j]); rerr != nil {
+					err = fmt.Errorf("%w; %s is left at %s: %v", err, paths[j], moved, rerr)
+				}
+			}
+			return "", err
+		}
+	}
+
+	destPath := filepath.Join(destDir, filepath.Base(path))
+	for _, p := range paths {
+		moved := filepath.Join(destDir, filepath.Base(p))
+		e.record(db.JournalEntry{Action: db.ActionMove, Source: p, Destination: moved, Code: e.codeOf(p)})
+		if e.database != nil {
+			if err := e.database.UpdateFilePath(p, moved); err != nil {
+				return destPath, fmt.Errorf("moved to %s but failed to update the index: %w", moved, err)
+			}
+		}
+	}
+	return destPath, nil
+}
+
+// bundleError describes the failure of an operation on p, the file path
+// or one of its sidecars.
+func bundleError(path, p string, err error) error {
+	if p == path {
+		return err
+	}
+	return fmt.Errorf("sidecar %s: %w", p, err)
+}
+
+// MoveConflict returns the first existing file that moving path and its
+// sidecars into destDir would replace, or "" if there is none.
+func (e *Executor) MoveConflict(path, destDir string) string {
+	return conflict(append([]string{path}, e.Sidecars(path)...), destDir)
+}
+
+// conflict returns the first file in destDir named as one of paths, or "".
+func conflict(paths []string, destDir string) string {
+	for _, p := range paths {
+		target := filepath.Join(destDir, filepath.Base(p))
+		if _, err := os.Lstat(target); err == nil {
+			return target
+		}
+	}
+	return ""
+}
+
+// codeOf returns the indexed code of path, or "" if unknown.
+func (e *Executor) codeOf(path string) string {
+	return codeOfRecord(e.indexed(path))
+}
+
+// codeOfRecord returns the code of rec, or "" if rec is nil.
+func codeOfRecord(rec *db.FileRecord) string {
+	if rec == nil {
+		return ""
+	}
+	return rec.Code
+}
+
+// indexed returns the index record of path, or nil if it is not indexed.
+func (e *Executor) indexed(path string) *db.FileRecord {
+	if e.database == nil {
+		return nil
+	}
+	rec, err := e.database.GetFile(path)
+	if err != nil {
+		return nil
+	}
+	return rec
+}
+
+func (e *Executor) record(entry db.JournalEntry) {
+	if e.database == nil {
+		return
+	}
+	entry.SessionID = e.session
+	if err := e.database.AddJournalEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record %s of %s: %v\n", entry.Action, entry.Source, err)
+	}
+}
+
+// UndoResult reports the outcome of undoing a session.
+type UndoResult struct {
+	Restored int
+	// Skipped lists entries that cannot be undone, such as permanent deletions.
+	Skipped []db.JournalEntry
+	// Errors lists entries that failed to undo.
+	Errors []error
+}
+
+// Undo reverses the operations of a session, newest first: moved files are
+// moved back and trashed files are restored, and the index is updated.
+// Entries that were already undone are ignored. With dryRun nothing changes.
+func Undo(database *db.DB, sessionID string, dryRun bool) (*UndoResult, error) {
+	entries, err := database.JournalEntries(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no journal entries for session %s", sessionID)
+	}
+
+	result := &UndoResult{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Undone {
+			continue
+		}
+		if entry.Action == db.ActionDelete || entry.Destination == "" {
+			result.Skipped = append(result.Skipped, entry)
+			continue
+		}
+		if dryRun {
+			result.Restored++
+			continue
+		}
+		if err := undoEntry(database, entry); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s: %w", entry.Source, err))
+			continue
+		}
+		// The file is back even if the journal can't record it
+		result.Restored++
+		if err := database.MarkUndone(entry.ID); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s: failed to mark undone: %w", entry.Source, err))
+		}
+	}
+	return result, nil
+}
+
+func undoEntry(database *db.DB, entry db.JournalEntry) error {
+	switch entry.Action {
+	case db.ActionMove:
+		if _, err := os.Lstat(entry.Source); err == nil {
+			return fmt.Errorf("file already exists")
+		}
+		if err := os.MkdirAll(filepath.Dir(entry.Source), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(entry.Destination, entry.Source); err != nil {
+			return err
+		}
+		return database.UpdateFilePath(entry.Destination, entry.Source)
+
+	case db.ActionTrash:
+		item := trash.Item{OriginalPath: entry.Source, Path: entry.Destination, InfoPath: entry.InfoPath}
+		if err := trash.Restore(item); err != nil {
+			return err
+		}
+		if entry.Code == "" {
+			// The file was not indexed when it was trashed
+			return nil
+		}
+		info, err := os.Stat(entry.Source)
+		if err != nil {
+			return err
+		}
+		// Entries journaled with the record restore every code of the file
+		rec := db.FileRecord{Code: entry.Code}
+		if entry.File != nil {
+			rec = *entry.File
+		}
+		rec.Path = entry.Source
+		rec.Size = info.Size()
+		rec.Mtime = info.ModTime()
+		return database.InsertFile(rec)
+
+	default:
+		return fmt.Errorf("unknown action %q", entry.Action)
+	}
+}
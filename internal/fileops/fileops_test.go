@@ -0,0 +1,311 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/sidecar"
+	"github.com/jiikko/fdup/internal/trash"
+)
+
+func setupTest(t *testing.T) (*db.DB, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	database, err := db.Open(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+	return database, tmpDir
+}
+
+func createFile(t *testing.T, database *db.DB, path, code string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := database.InsertFile(db.FileRecord{Path: path, Code: code, Size: int64(len(code)), Mtime: time.Now()}); err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	database, tmpDir := setupTest(t)
+
+	moved := filepath.Join(tmpDir, "a", "DSC00001.jpg")
+	trashed := filepath.Join(tmpDir, "b", "DSC00002.jpg")
+	createFile(t, database, moved, "DSC00001")
+	createFile(t, database, trashed, "DSC00002")
+
+	ops := New(database)
+	dest, err := ops.Move(moved, filepath.Join(tmpDir, "c"))
+	if err != nil {
+		t.Fatalf("failed to move: %v", err)
+	}
+	if err := ops.Remove(trashed, true); err != nil {
+		t.Fatalf("failed to trash: %v", err)
+	}
+
+	entries, err := database.JournalEntries(ops.Session())
+	if err != nil {
+		t.Fatalf("failed to read journal: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != db.ActionMove || entries[1].Action != db.ActionTrash {
+		t.Fatalf("unexpected journal entries: %+v", entries)
+	}
+	if entries[1].Code != "DSC00002" {
+		t.Errorf("expected code DSC00002 in journal, got %q", entries[1].Code)
+	}
+
+	result, err := Undo(database, ops.Session(), false)
+	if err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	if result.Restored != 2 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected undo result: %+v", result)
+	}
+
+	for _, path := range []string{moved, trashed} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be restored: %v", path, err)
+		}
+		rec, err := database.GetFile(path)
+		if err != nil || rec == nil {
+			t.Errorf("expected %s to be indexed again: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("expected moved file to leave its destination")
+	}
+
+	// A second undo has nothing left to do
+	result, err = Undo(database, ops.Session(), false)
+	if err != nil {
+		t.Fatalf("failed to undo again: %v", err)
+	}
+	if result.Restored != 0 {
+		t.Errorf("expected nothing to undo, got %d", result.Restored)
+	}
+}
+
+func TestUndoRestoresTheFullRecord(t *testing.T) {
+	database, tmpDir := setupTest(t)
+
+	path := filepath.Join(tmpDir, "disk", "IMG_0001 DSC00002.jpg")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	indexed := db.FileRecord{
+		Path:    path,
+		Code:    "IMG0001",
+		Codes:   []string{"IMG0001", "DSC00002"},
+		AliasOf: map[string]string{"DSC00002": "DSC2"},
+		Size:    4,
+		Mtime:   time.Now(),
+		Root:    filepath.Join(tmpDir, "disk"),
+		Volume:  "uuid-1",
+	}
+	if err := database.InsertFile(indexed); err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+
+	ops := New(database)
+	if err := ops.Remove(path, true); err != nil {
+		t.Fatalf("failed to trash: %v", err)
+	}
+	if _, err := Undo(database, ops.Session(), false); err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+
+	rec, err := database.GetFile(path)
+	if err != nil || rec == nil {
+		t.Fatalf("expected the file to be indexed again: %v", err)
+	}
+	if rec.Code != "IMG0001" || len(rec.Codes) != 2 || rec.Codes[1] != "DSC00002" {
+		t.Errorf("expected codes IMG0001 and DSC00002, got %q %v", rec.Code, rec.Codes)
+	}
+	if rec.AliasOf["DSC00002"] != "DSC2" {
+		t.Errorf("expected DSC00002 to be an alias of DSC2, got %v", rec.AliasOf)
+	}
+	if rec.Root != indexed.Root || rec.Volume != "uuid-1" {
+		t.Errorf("expected root %s on volume uuid-1, got %s on %q", indexed.Root, rec.Root, rec.Volume)
+	}
+}
+
+func TestUndoSkipsDeletions(t *testing.T) {
+	database, tmpDir := setupTest(t)
+
+	path := filepath.Join(tmpDir, "a", "DSC00001.jpg")
+	createFile(t, database, path, "DSC00001")
+
+	ops := New(database)
+	if err := ops.Remove(path, false); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	result, err := Undo(database, ops.Session(), false)
+	if err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	if len(result.Skipped) != 1 || result.Restored != 0 {
+		t.Errorf("expected deletion to be skipped, got %+v", result)
+	}
+}
+
+func TestSidecarsFollowTheirFile(t *testing.T) {
+	database, tmpDir := setupTest(t)
+
+	raw := filepath.Join(tmpDir, "a", "DSC00001.ARW")
+	other := filepath.Join(tmpDir, "b", "DSC00002.ARW")
+	createFile(t, database, raw, "DSC00001")
+	createFile(t, database, other, "DSC00002")
+	for _, name := range []string{"a/DSC00001.JPG", "a/DSC00001.xmp", "b/DSC00002.xmp"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to create sidecar: %v", err)
+		}
+	}
+
+	ops := New(database)
+	ops.SetSidecars(sidecar.New([]string{"jpg", "xmp"}))
+	if _, err := ops.Move(raw, filepath.Join(tmpDir, "c")); err != nil {
+		t.Fatalf("failed to move: %v", err)
+	}
+	if err := ops.Remove(other, true); err != nil {
+		t.Fatalf("failed to trash: %v", err)
+	}
+	for _, name := range []string{"c/DSC00001.ARW", "c/DSC00001.JPG", "c/DSC00001.xmp"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("expected %s to be moved: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "b", "DSC00002.xmp")); !os.IsNotExist(err) {
+		t.Errorf("expected the sidecar to be trashed, got %v", err)
+	}
+
+	result, err := Undo(database, ops.Session(), false)
+	if err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	if result.Restored != 5 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected undo result: %+v", result)
+	}
+	for _, name := range []string{"a/DSC00001.ARW", "a/DSC00001.JPG", "a/DSC00001.xmp", "b/DSC00002.ARW", "b/DSC00002.xmp"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("expected %s to be restored: %v", name, err)
+		}
+	}
+}
+
+func TestMoveRefusesToReplace(t *testing.T) {
+	database, tmpDir := setupTest(t)
+
+	src := filepath.Join(tmpDir, "a", "ABC001.txt")
+	other := filepath.Join(tmpDir, "b", "ABC001.txt")
+	createFile(t, database, src, "ABC001")
+	createFile(t, database, other, "ABC001-copy")
+
+	ops := New(database)
+	if _, err := ops.Move(src, filepath.Dir(other)); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected an error for an existing file, got %v", err)
+	}
+	if data, _ := os.ReadFile(other); string(data) != "ABC001-copy" {
+		t.Errorf("expected the existing file to be left alone, got %q", data)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("expected the file to stay: %v", err)
+	}
+
+	// A sidecar that would replace a file keeps its whole bundle in place
+	raw := filepath.Join(tmpDir, "a", "DSC00001.ARW")
+	createFile(t, database, raw, "DSC00001")
+	for _, name := range []string{"a/DSC00001.xmp", "c/DSC00001.xmp"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to create sidecar: %v", err)
+		}
+	}
+	ops.SetSidecars(sidecar.New([]string{"xmp"}))
+	if got := ops.MoveConflict(raw, filepath.Join(tmpDir, "c")); got != filepath.Join(tmpDir, "c", "DSC00001.xmp") {
+		t.Errorf("expected the sidecar conflict, got %q", got)
+	}
+	if _, err := ops.Move(raw, filepath.Join(tmpDir, "c")); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected an error for an existing sidecar, got %v", err)
+	}
+	if _, err := os.Stat(raw); err != nil {
+		t.Errorf("expected the file to stay: %v", err)
+	}
+	if rec, _ := database.GetFile(raw); rec == nil {
+		t.Error("expected the index to be unchanged")
+	}
+}
+
+func TestFailingSidecarKeepsTheBundleTogether(t *testing.T) {
+	database, tmpDir := setupTest(t)
+
+	raw := filepath.Join(tmpDir, "a", "DSC00001.ARW")
+	createFile(t, database, raw, "DSC00001")
+	bundle := []string{raw}
+	for _, name := range []string{"DSC00001.JPG", "DSC00001.xmp"} {
+		path := filepath.Join(tmpDir, "a", name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("failed to create sidecar: %v", err)
+		}
+		bundle = append(bundle, path)
+	}
+	failing := bundle[2]
+	errFail := errors.New("injected failure")
+
+	origRename, origTrash := rename, moveToTrash
+	t.Cleanup(func() { rename, moveToTrash = origRename, origTrash })
+	rename = func(from, to string) error {
+		if from == failing {
+			return errFail
+		}
+		return os.Rename(from, to)
+	}
+	moveToTrash = func(path string) (*trash.Item, error) {
+		if path == failing {
+			return nil, errFail
+		}
+		return trash.Move(path)
+	}
+
+	ops := New(database)
+	ops.SetSidecars(sidecar.New([]string{"jpg", "xmp"}))
+	if _, err := ops.Move(raw, filepath.Join(tmpDir, "c")); !errors.Is(err, errFail) {
+		t.Errorf("expected the move to fail, got %v", err)
+	}
+	if err := ops.Remove(raw, true); !errors.Is(err, errFail) {
+		t.Errorf("expected the trash to fail, got %v", err)
+	}
+
+	for _, path := range bundle {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to stay in place: %v", path, err)
+		}
+	}
+	if rec, err := database.GetFile(raw); err != nil || rec == nil {
+		t.Errorf("expected the file to stay indexed, got %v", err)
+	}
+	entries, err := database.JournalEntries(ops.Session())
+	if err != nil {
+		t.Fatalf("failed to read journal: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected nothing journaled, got %+v", entries)
+	}
+}
@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter selects files by extension, size and modification time. The zero
+// value, and a nil *Filter, select every file.
+type Filter struct {
+	// Extensions lists the only extensions selected, such as "mp4" or
+	// ".mp4". Empty selects any extension. Extensions compare
+	// case-insensitively.
+	Extensions []string
+	// ExcludeExtensions lists extensions never selected.
+	ExcludeExtensions []string
+	// MinSize is the smallest size selected, in bytes.
+	MinSize Size
+	// MaxSize is the largest size selected, in bytes. Zero means no limit.
+	MaxSize Size
+	// ModifiedAfter selects files modified at or after this time.
+	ModifiedAfter Time
+	// ModifiedBefore selects files modified before this time.
+	ModifiedBefore Time
+}
+
+// Match reports whether a file with this name, size and mtime is selected.
+func (f *Filter) Match(name string, size int64, mtime time.Time) bool {
+	if f == nil {
+		return true
+	}
+	ext := filepath.Ext(name)
+	if len(f.Extensions) > 0 && !HasExtension(f.Extensions, ext) {
+		return false
+	}
+	if HasExtension(f.ExcludeExtensions, ext) {
+		return false
+	}
+	if size < int64(f.MinSize) || (f.MaxSize > 0 && size > int64(f.MaxSize)) {
+		return false
+	}
+	now := time.Now()
+	if !f.ModifiedAfter.IsZero() && mtime.Before(f.ModifiedAfter.Resolve(now)) {
+		return false
+	}
+	if !f.ModifiedBefore.IsZero() && !mtime.Before(f.ModifiedBefore.Resolve(now)) {
+		return false
+	}
+	return true
+}
+
+// HasExtension reports whether ext, with or without its dot, is in exts.
+// A file without an extension matches nothing.
+func HasExtension(exts []string, ext string) bool {
+	ext = strings.TrimPrefix(ext, ".")
+	if ext == "" {
+		return false
+	}
+	for _, e := range exts {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size is a size in bytes. As text it is a number with an optional unit
+// of B, KB, MB, GB or TB, in powers of 1024, such as "1" or "1.5GB".
+type Size int64
+
+// ParseSize parses a size such as "500", "10KB" or "1.5GB".
+func ParseSize(s string) (Size, error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(text, u.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, u.suffix))
+			multiplier = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use a number with an optional unit such as 10MB)", s)
+	}
+	return Size(n * float64(multiplier)), nil
+}
+
+// UnmarshalText parses a size written as ParseSize accepts.
+func (s *Size) UnmarshalText(text []byte) error {
+	size, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// Time is a point in time given either absolutely or as an age, such as
+// "30d", that is relative to when it is used.
+type Time struct {
+	At  time.Time
+	Age time.Duration
+	// text is the form it was parsed from, kept for marshaling.
+	text string
+}
+
+// ParseTime parses a date ("2024-01-31"), an RFC 3339 time, or an age:
+// a number with a unit of s, m, h, d (days) or w (weeks), such as "90d".
+func ParseTime(s string) (Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return Time{At: t, text: s}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return Time{At: t, text: s}, nil
+	}
+	if age, ok := parseAge(s); ok {
+		return Time{Age: age, text: s}, nil
+	}
+	return Time{}, fmt.Errorf("invalid time %q (use a date such as 2024-01-31 or an age such as 30d)", s)
+}
+
+// parseAge parses a duration that also accepts days and weeks.
+func parseAge(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	unit := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[s[len(s)-1]]
+	if unit == 0 {
+		d, err := time.ParseDuration(s)
+		return d, err == nil && d > 0
+	}
+	n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n * float64(unit)), true
+}
+
+// IsZero reports whether t is unset.
+func (t Time) IsZero() bool {
+	return t.At.IsZero() && t.Age == 0
+}
+
+// Resolve returns the time t stands for when used at now.
+func (t Time) Resolve(now time.Time) time.Time {
+	if t.Age != 0 {
+		return now.Add(-t.Age)
+	}
+	return t.At
+}
+
+// UnmarshalText parses a time written as ParseTime accepts.
+func (t *Time) UnmarshalText(text []byte) error {
+	parsed, err := ParseTime(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// MarshalText returns the text t was parsed from.
+func (t Time) MarshalText() ([]byte, error) {
+	if t.text == "" && !t.At.IsZero() {
+		return []byte(t.At.Format(time.RFC3339)), nil
+	}
+	return []byte(t.text), nil
+}
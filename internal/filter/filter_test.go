@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Size
+	}{
+		{"0", 0},
+		{"500", 500},
+		{"10B", 10},
+		{"10KB", 10 << 10},
+		{"1.5gb", 3 << 29},
+		{"2 M", 2 << 20},
+		{"1TB", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.input, tt.want, got)
+		}
+	}
+
+	for _, input := range []string{"", "MB", "-1", "10XB"} {
+		if _, err := ParseSize(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	date, err := ParseTime("2024-01-31")
+	if err != nil {
+		t.Fatalf("failed to parse date: %v", err)
+	}
+	if want := time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local); !date.Resolve(now).Equal(want) {
+		t.Errorf("expected %v, got %v", want, date.Resolve(now))
+	}
+
+	ages := map[string]time.Duration{"30d": 30 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "12h": 12 * time.Hour}
+	for input, age := range ages {
+		parsed, err := ParseTime(input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", input, err)
+			continue
+		}
+		if got := parsed.Resolve(now); !got.Equal(now.Add(-age)) {
+			t.Errorf("%s: expected %v, got %v", input, now.Add(-age), got)
+		}
+	}
+
+	for _, input := range []string{"", "yesterday", "-3d", "2024-13-01"} {
+		if _, err := ParseTime(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	monthAgo, _ := ParseTime("30d")
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		file   string
+		size   int64
+		mtime  time.Time
+		want   bool
+	}{
+		{"nil filter", nil, "a.xmp", 0, old, true},
+		{"allowed extension", &Filter{Extensions: []string{"mp4", ".MKV"}}, "a.mkv", 1, old, true},
+		{"other extension", &Filter{Extensions: []string{"mp4"}}, "a.xmp", 1, old, false},
+		{"no extension", &Filter{Extensions: []string{"mp4"}}, "mp4", 1, old, false},
+		{"excluded extension", &Filter{ExcludeExtensions: []string{"xmp", "thm"}}, "A.THM", 1, old, false},
+		{"empty file", &Filter{MinSize: 1}, "a.mp4", 0, old, false},
+		{"min size", &Filter{MinSize: 1}, "a.mp4", 1, old, true},
+		{"max size", &Filter{MaxSize: 10}, "a.mp4", 11, old, false},
+		{"modified after", &Filter{ModifiedAfter: monthAgo}, "a.mp4", 1, old, false},
+		{"modified before", &Filter{ModifiedBefore: monthAgo}, "a.mp4", 1, old, true},
+		{"recent file", &Filter{ModifiedBefore: monthAgo}, "a.mp4", 1, recent, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.file, tt.size, tt.mtime); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
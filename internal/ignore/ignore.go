@@ -0,0 +1,216 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of the ignore files read from scanned directories.
+// Their rules apply to the directory they are in and everything below it.
+const FileName = ".fdupignore"
+
+// Rule is one pattern of an ignore list, with the semantics of a line in a
+// .gitignore file.
+type Rule struct {
+	// Pattern is the line the rule was parsed from.
+	Pattern  string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// Parse parses ignore patterns. Blank lines and lines starting with # are
+// skipped.
+//
+// Patterns follow gitignore: a leading ! re-includes paths excluded by an
+// earlier pattern, a trailing / matches directories only, and a pattern
+// with a / anywhere else is anchored to the directory of the list, while
+// other patterns match a name at any depth. *, ? and [...] match within a
+// path component and ** matches any number of directories.
+func Parse(lines []string) ([]Rule, error) {
+	var rules []Rule
+	for _, line := range lines {
+		rule, ok, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func parseLine(line string) (Rule, bool, error) {
+	rule := Rule{Pattern: line}
+
+	p := strings.TrimRight(line, "\r")
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(p, " ") && !strings.HasSuffix(p, `\ `) {
+		p = p[:len(p)-1]
+	}
+	if p == "" || strings.HasPrefix(p, "#") {
+		return rule, false, nil
+	}
+
+	if strings.HasPrefix(p, "!") {
+		rule.negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\!`) || strings.HasPrefix(p, `\#`) {
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		rule.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return rule, false, nil
+	}
+
+	// Patterns without a slash match at any depth
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	rule.segments = strings.Split(p, "/")
+	if !anchored {
+		rule.segments = append([]string{"**"}, rule.segments...)
+	}
+
+	for _, seg := range rule.segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return rule, false, fmt.Errorf("invalid ignore pattern %q: %w", line, err)
+		}
+	}
+	return rule, true, nil
+}
+
+// ReadFile reads the rules of an ignore file. It returns no rules and no
+// error if the file does not exist.
+func ReadFile(filename string) ([]Rule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	rules, err := Parse(lines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// match reports whether the rule matches rel, a slash-separated path
+// relative to the directory of the rule's list.
+func (r Rule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path components against pattern components,
+// where ** matches any number of components.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			// A trailing ** matches everything inside, but not the directory itself
+			if len(rest) == 0 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Matcher decides whether paths are ignored using the rules of a directory
+// and of the directories above it. Rules of deeper directories take
+// precedence, and within a list the last matching rule wins.
+type Matcher struct {
+	parent *Matcher
+	// base is the slash-separated directory of the rules relative to the
+	// top of the tree, or "" for the top.
+	base  string
+	rules []Rule
+}
+
+// New returns a matcher for rules that apply to the whole tree.
+func New(rules []Rule) *Matcher {
+	return &Matcher{rules: rules}
+}
+
+// Child returns a matcher that adds rules for the directory dir, relative
+// to the top of the tree. It returns m itself when there are no rules.
+func (m *Matcher) Child(dir string, rules []Rule) *Matcher {
+	if len(rules) == 0 {
+		return m
+	}
+	base := path.Clean(filepath.ToSlash(dir))
+	if base == "." {
+		base = ""
+	}
+	return &Matcher{parent: m, base: base, rules: rules}
+}
+
+// Match reports whether relPath, relative to the top of the tree, is
+// matched by an ignore rule. Parent directories are not considered; see
+// Excluded.
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	for cur := m; cur != nil; cur = cur.parent {
+		rel := relPath
+		if cur.base != "" {
+			if !strings.HasPrefix(relPath, cur.base+"/") {
+				continue
+			}
+			rel = relPath[len(cur.base)+1:]
+		}
+		for i := len(cur.rules) - 1; i >= 0; i-- {
+			if cur.rules[i].match(rel, isDir) {
+				return !cur.rules[i].negate
+			}
+		}
+	}
+	return false
+}
+
+// Excluded reports whether relPath or one of its parent directories is
+// ignored. As with git, a path in an ignored directory cannot be
+// re-included.
+func (m *Matcher) Excluded(relPath string, isDir bool) bool {
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := 1; i < len(parts); i++ {
+		if m.Match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.Match(relPath, isDir)
+}
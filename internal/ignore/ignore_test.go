@@ -0,0 +1,89 @@
+package ignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		// Names match at any depth
+		{[]string{"*.log"}, "a/b/debug.log", false, true},
+		{[]string{"node_modules/"}, "web/node_modules", true, true},
+		{[]string{"node_modules/"}, "web/node_modules", false, false},
+		{[]string{".DS_Store"}, ".DS_Store", false, true},
+		// A slash anchors the pattern
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "src/build", true, false},
+		{[]string{"doc/*.pdf"}, "doc/a.pdf", false, true},
+		{[]string{"doc/*.pdf"}, "doc/x/a.pdf", false, false},
+		{[]string{"doc/*.pdf"}, "x/doc/a.pdf", false, false},
+		// Double asterisks
+		{[]string{"**/cache"}, "a/b/cache", true, true},
+		{[]string{"raw/**"}, "raw/a/b.arw", false, true},
+		{[]string{"raw/**"}, "raw", true, false},
+		{[]string{"a/**/z.txt"}, "a/z.txt", false, true},
+		{[]string{"a/**/z.txt"}, "a/b/c/z.txt", false, true},
+		// Character classes and single characters
+		{[]string{"IMG_[0-9][0-9].jpg"}, "IMG_12.jpg", false, true},
+		{[]string{"IMG_[0-9][0-9].jpg"}, "IMG_1a.jpg", false, false},
+		{[]string{"?.tmp"}, "a.tmp", false, true},
+		// Negation, where the last match wins
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "other.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		// Comments, blank lines and escapes
+		{[]string{"# comment", "", `\#hash`}, "#hash", false, true},
+		{[]string{`\!bang`}, "!bang", false, true},
+		{[]string{"trailing   "}, "trailing", false, true},
+	}
+	for _, tt := range tests {
+		rules, err := Parse(tt.patterns)
+		if err != nil {
+			t.Fatalf("%v: failed to parse: %v", tt.patterns, err)
+		}
+		if got := New(rules).Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%v: expected %s to be ignored: %v, got %v", tt.patterns, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestMatcherChild(t *testing.T) {
+	top, _ := Parse([]string{"*.tmp", "shared/"})
+	nested, _ := Parse([]string{"!important.tmp", "/local"})
+	m := New(top).Child("team", nested)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		// Deeper rules take precedence
+		{"team/important.tmp", false, false},
+		{"team/x/important.tmp", false, false},
+		{"important.tmp", false, true},
+		{"team/other.tmp", false, true},
+		// Anchored to the directory of the rules
+		{"team/local", true, true},
+		{"team/x/local", true, false},
+		{"local", true, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+
+	// Paths in an ignored directory cannot be re-included
+	if !m.Excluded("team/shared/important.tmp", false) {
+		t.Error("expected a file in an ignored directory to be excluded")
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	if _, err := Parse([]string{"[a-"}); err == nil {
+		t.Error("expected an error for an unclosed character class")
+	}
+}
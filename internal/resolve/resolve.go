@@ -0,0 +1,231 @@
+package resolve
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+// Rule decides which files of a duplicate group are the best candidates to keep.
+type Rule string
+
+// Available rules.
+const (
+	Newest       Rule = "newest"
+	Oldest       Rule = "oldest"
+	Largest      Rule = "largest"
+	Smallest     Rule = "smallest"
+	ShortestPath Rule = "shortest-path"
+	LongestPath  Rule = "longest-path"
+	Preferred    Rule = "preferred"
+)
+
+// Rules lists all available rules.
+var Rules = []Rule{Newest, Oldest, Largest, Smallest, ShortestPath, LongestPath, Preferred}
+
+// ParseRule parses a rule name.
+func ParseRule(name string) (Rule, error) {
+	for _, r := range Rules {
+		if string(r) == name {
+			return r, nil
+		}
+	}
+	names := make([]string, len(Rules))
+	for i, r := range Rules {
+		names[i] = string(r)
+	}
+	return "", fmt.Errorf("unknown rule %q (use %s)", name, strings.Join(names, ", "))
+}
+
+// Resolver chooses the file to keep in each duplicate group.
+// Rules are applied in order; each one narrows the candidates to the files
+// it ranks best, and later rules only break ties left by earlier ones.
+type Resolver struct {
+	rules     []Rule
+	preferred []string
+	// offline holds the UUIDs of volumes that are not mounted.
+	offline map[string]string
+}
+
+// New creates a resolver. preferred is the ordered directory list used by
+// the preferred rule; earlier directories win.
+func New(rules []Rule, preferred []string) (*Resolver, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rule given")
+	}
+	cleaned := make([]string, len(preferred))
+	for i, dir := range preferred {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		cleaned[i] = abs
+	}
+	for _, r := range rules {
+		if r == Preferred && len(cleaned) == 0 {
+			return nil, fmt.Errorf("rule %q requires at least one preferred directory", Preferred)
+		}
+	}
+	return &Resolver{rules: rules, preferred: cleaned}, nil
+}
+
+// SetOffline sets the volumes that are not mounted, keyed by UUID as
+// returned by volume.Offline. PlanAll leaves files on them alone.
+func (r *Resolver) SetOffline(volumes map[string]string) {
+	r.offline = volumes
+}
+
+// Plan is the resolution of one duplicate group.
+type Plan struct {
+	Code string
+	// Keep is the file to keep. It is nil when the rules end in a tie.
+	Keep *db.FileRecord
+	// Remove lists the files to remove when Keep is set.
+	Remove []db.FileRecord
+	// Tied lists the equally ranked candidates when Keep is nil.
+	Tied []db.FileRecord
+}
+
+// Ambiguous reports whether the rules could not pick a single file.
+func (p Plan) Ambiguous() bool {
+	return p.Keep == nil
+}
+
+// Plan applies the rules to a group.
+func (r *Resolver) Plan(group db.DuplicateGroup) Plan {
+	candidates := group.Files
+	for _, rule := range r.rules {
+		if len(candidates) <= 1 {
+			break
+		}
+		candidates = r.best(rule, candidates)
+	}
+
+	plan := Plan{Code: group.Code}
+	if len(candidates) != 1 {
+		plan.Tied = candidates
+		return plan
+	}
+
+	keep := candidates[0]
+	plan.Keep = &keep
+	for _, f := range group.Files {
+		if f.Path != keep.Path {
+			plan.Remove = append(plan.Remove, f)
+		}
+	}
+	return plan
+}
+
+// PlanAll applies the rules to every group. Verified groups are split so
+// only files with identical content are resolved against each other.
+// A file with several codes can be in several groups, so files kept or tied
+// in one plan are never removed by another, and each file is removed by one
+// plan at most. Files on offline volumes are neither kept nor removed, and
+// groups with fewer than two files left are not planned.
+func (r *Resolver) PlanAll(groups []db.DuplicateGroup) []Plan {
+	var plans []Plan
+	plan := func(code string, files []db.FileRecord) {
+		if files = r.online(files); len(files) > 1 {
+			plans = append(plans, r.Plan(db.DuplicateGroup{Code: code, Files: files}))
+		}
+	}
+	for _, group := range groups {
+		if !group.Verified() {
+			plan(group.Code, group.Files)
+			continue
+		}
+		for _, sg := range group.Subgroups {
+			if sg.Identical {
+				plan(group.Code, sg.Files)
+			}
+		}
+	}
+
+	protected := make(map[string]bool)
+	for _, plan := range plans {
+		if plan.Keep != nil {
+			protected[plan.Keep.Path] = true
+		}
+		for _, f := range plan.Tied {
+			protected[f.Path] = true
+		}
+	}
+	removed := make(map[string]bool)
+	for i := range plans {
+		var remove []db.FileRecord
+		for _, f := range plans[i].Remove {
+			if protected[f.Path] || removed[f.Path] {
+				continue
+			}
+			removed[f.Path] = true
+			remove = append(remove, f)
+		}
+		plans[i].Remove = remove
+	}
+	return plans
+}
+
+// online returns the files that are not on an offline volume.
+func (r *Resolver) online(files []db.FileRecord) []db.FileRecord {
+	if len(r.offline) == 0 {
+		return files
+	}
+	var result []db.FileRecord
+	for _, f := range files {
+		if _, ok := r.offline[f.Volume]; !ok {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// best returns the files ranked highest by rule.
+func (r *Resolver) best(rule Rule, files []db.FileRecord) []db.FileRecord {
+	var score func(f db.FileRecord) int64
+	switch rule {
+	case Newest:
+		score = func(f db.FileRecord) int64 { return f.Mtime.UnixNano() }
+	case Oldest:
+		score = func(f db.FileRecord) int64 { return -f.Mtime.UnixNano() }
+	case Largest:
+		score = func(f db.FileRecord) int64 { return f.Size }
+	case Smallest:
+		score = func(f db.FileRecord) int64 { return -f.Size }
+	case ShortestPath:
+		score = func(f db.FileRecord) int64 { return -int64(len(f.Path)) }
+	case LongestPath:
+		score = func(f db.FileRecord) int64 { return int64(len(f.Path)) }
+	case Preferred:
+		score = func(f db.FileRecord) int64 { return -int64(r.preferredRank(f.Path)) }
+	default:
+		return files
+	}
+
+	var result []db.FileRecord
+	var top int64
+	for i, f := range files {
+		s := score(f)
+		if i == 0 || s > top {
+			top = s
+			result = []db.FileRecord{f}
+		} else if s == top {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// preferredRank returns the index of the first preferred directory that
+// contains path, or len(preferred) if none does.
+func (r *Resolver) preferredRank(path string) int {
+	for i, dir := range r.preferred {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return len(r.preferred)
+}
@@ -0,0 +1,148 @@
+package resolve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+func testGroup() db.DuplicateGroup {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return db.DuplicateGroup{
+		Code: "DSC00001",
+		Files: []db.FileRecord{
+			{Path: "/photos/a/DSC00001.jpg", Size: 100, Mtime: base},
+			{Path: "/backup/long/path/DSC00001.jpg", Size: 300, Mtime: base.Add(time.Hour)},
+			{Path: "/tmp/DSC00001.jpg", Size: 300, Mtime: base.Add(time.Hour)},
+		},
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []Rule
+		preferred []string
+		keep      string
+		tied      int
+	}{
+		{name: "oldest", rules: []Rule{Oldest}, keep: "/photos/a/DSC00001.jpg"},
+		{name: "smallest", rules: []Rule{Smallest}, keep: "/photos/a/DSC00001.jpg"},
+		{name: "shortest path", rules: []Rule{ShortestPath}, keep: "/tmp/DSC00001.jpg"},
+		{name: "newest tie", rules: []Rule{Newest}, tied: 2},
+		{name: "newest with tie-break", rules: []Rule{Newest, LongestPath}, keep: "/backup/long/path/DSC00001.jpg"},
+		{name: "preferred", rules: []Rule{Preferred}, preferred: []string{"/backup", "/photos"}, keep: "/backup/long/path/DSC00001.jpg"},
+		{name: "preferred order", rules: []Rule{Preferred}, preferred: []string{"/photos", "/backup"}, keep: "/photos/a/DSC00001.jpg"},
+		{name: "preferred no match", rules: []Rule{Preferred}, preferred: []string{"/elsewhere"}, tied: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.rules, tt.preferred)
+			if err != nil {
+				t.Fatalf("failed to create resolver: %v", err)
+			}
+			plan := r.Plan(testGroup())
+
+			if tt.tied > 0 {
+				if !plan.Ambiguous() {
+					t.Fatalf("expected tie, got keep %s", plan.Keep.Path)
+				}
+				if len(plan.Tied) != tt.tied {
+					t.Errorf("expected %d tied files, got %d", tt.tied, len(plan.Tied))
+				}
+				return
+			}
+
+			if plan.Ambiguous() {
+				t.Fatalf("expected keep %s, got tie %v", tt.keep, plan.Tied)
+			}
+			if plan.Keep.Path != tt.keep {
+				t.Errorf("expected keep %s, got %s", tt.keep, plan.Keep.Path)
+			}
+			if len(plan.Remove) != 2 {
+				t.Errorf("expected 2 files to remove, got %d", len(plan.Remove))
+			}
+		})
+	}
+}
+
+func TestNewRequiresPreferredDirs(t *testing.T) {
+	if _, err := New([]Rule{Preferred}, nil); err == nil {
+		t.Error("expected error for preferred rule without directories")
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	if r, err := ParseRule("shortest-path"); err != nil || r != ShortestPath {
+		t.Errorf("expected shortest-path, got %q, %v", r, err)
+	}
+	if _, err := ParseRule("biggest"); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
+
+func TestPlanAllProtectsFilesInSeveralGroups(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// stack.tif has two codes; it is the newest in the first group only
+	stack := db.FileRecord{Path: "/a/DSC00001-DSC00002_stack.tif", Mtime: base.Add(2 * time.Hour)}
+	groups := []db.DuplicateGroup{
+		{Code: "DSC00001", Files: []db.FileRecord{stack, {Path: "/b/DSC00001.jpg", Mtime: base}}},
+		{Code: "DSC00002", Files: []db.FileRecord{stack, {Path: "/b/DSC00002.jpg", Mtime: base.Add(3 * time.Hour)}}},
+	}
+
+	r, err := New([]Rule{Newest}, nil)
+	if err != nil {
+		t.Fatalf("failed to create resolver: %v", err)
+	}
+	plans := r.PlanAll(groups)
+	if len(plans) != 2 {
+		t.Fatalf("expected 2 plans, got %d", len(plans))
+	}
+	for _, plan := range plans {
+		for _, f := range plan.Remove {
+			if f.Path == stack.Path {
+				t.Errorf("%s: file kept by another plan must not be removed", plan.Code)
+			}
+		}
+	}
+	if len(plans[0].Remove) != 1 || len(plans[1].Remove) != 0 {
+		t.Errorf("unexpected removals: %+v", plans)
+	}
+}
+
+func TestPlanAllLeavesOfflineVolumesAlone(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	archived := db.FileRecord{Path: "/media/disk/DSC00001.jpg", Mtime: base.Add(2 * time.Hour), Volume: "uuid-1"}
+	groups := []db.DuplicateGroup{
+		// The newest copy is offline, so one of the others is kept
+		{Code: "DSC00001", Files: []db.FileRecord{
+			archived,
+			{Path: "/a/DSC00001.jpg", Mtime: base.Add(time.Hour)},
+			{Path: "/b/DSC00001.jpg", Mtime: base},
+		}},
+		// Only one copy is online, so there is nothing to resolve
+		{Code: "DSC00002", Files: []db.FileRecord{
+			{Path: "/media/disk/DSC00002.jpg", Mtime: base, Volume: "uuid-1"},
+			{Path: "/a/DSC00002.jpg", Mtime: base},
+		}},
+	}
+
+	r, err := New([]Rule{Newest}, nil)
+	if err != nil {
+		t.Fatalf("failed to create resolver: %v", err)
+	}
+	r.SetOffline(map[string]string{"uuid-1": "archive"})
+	plans := r.PlanAll(groups)
+	if len(plans) != 1 {
+		t.Fatalf("expected 1 plan, got %+v", plans)
+	}
+	plan := plans[0]
+	if plan.Keep == nil || plan.Keep.Path != "/a/DSC00001.jpg" {
+		t.Errorf("expected /a/DSC00001.jpg to be kept, got %+v", plan.Keep)
+	}
+	if len(plan.Remove) != 1 || plan.Remove[0].Path != "/b/DSC00001.jpg" {
+		t.Errorf("expected only /b/DSC00001.jpg to be removed, got %+v", plan.Remove)
+	}
+}
@@ -4,17 +4,45 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/filter"
+	"github.com/jiikko/fdup/internal/ignore"
+	"github.com/jiikko/fdup/internal/sidecar"
 )
 
 // Scanner scans directories for files matching patterns.
 type Scanner struct {
-	extractor    *code.Extractor
-	ignorePatterns []string
-	rootDir      string
+	extractor *code.Extractor
+	roots     []Root
+	// matchers holds the ignore rules of the config for each root path.
+	// Rules of .fdupignore files are added while walking.
+	matchers map[string]*ignore.Matcher
+	jobs     int
+	// dirItems is set when some pattern indexes directories as items.
+	dirItems bool
+	aliases  *code.Aliases
+	// filter selects the files recorded; nil records every file.
+	filter *filter.Filter
+	// sidecars finds files that belong to another file and are not
+	// recorded themselves.
+	sidecars *sidecar.Rules
+}
+
+// Root is a directory scanned by a Scanner.
+type Root struct {
+	Path string
+	// Ignore lists patterns ignored under this root in addition to the
+	// scanner's ignore rules.
+	Ignore []string
+	// Volume is the UUID of the volume the root is on, recorded with each
+	// file found under it.
+	Volume string
 }
 
 // ScanResult contains the results of a scan operation.
@@ -28,140 +56,641 @@ type ScanResult struct {
 type ProgressFunc func(current, total int)
 
 // New creates a new scanner with the given patterns and ignore rules.
-func New(patterns []string, ignore []string, rootDir string) (*Scanner, error) {
+// ignorePatterns applies to every root; a root's own patterns take
+// precedence over it. Root paths are made absolute.
+func New(patterns []code.Pattern, ignorePatterns []string, roots []Root) (*Scanner, error) {
 	extractor, err := code.NewExtractor(patterns)
 	if err != nil {
 		return nil, err
 	}
+	globalRules, err := ignore.Parse(ignorePatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	absRoots := make([]Root, len(roots))
+	matchers := make(map[string]*ignore.Matcher, len(roots))
+	for i, r := range roots {
+		abs, err := filepath.Abs(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		absRoots[i] = Root{Path: abs, Ignore: r.Ignore, Volume: r.Volume}
+
+		rootRules, err := ignore.Parse(r.Ignore)
+		if err != nil {
+			return nil, err
+		}
+		matchers[abs] = ignore.New(append(append([]ignore.Rule{}, globalRules...), rootRules...))
+	}
+
 	return &Scanner{
-		extractor:    extractor,
-		ignorePatterns: ignore,
-		rootDir:      rootDir,
+		extractor: extractor,
+		roots:     absRoots,
+		matchers:  matchers,
+		jobs:      runtime.NumCPU(),
+		dirItems:  extractor.HasDirPatterns(),
 	}, nil
 }
 
-// Scan scans the directory and returns file records.
+// Roots returns the roots of the scanner with absolute paths.
+func (s *Scanner) Roots() []Root {
+	return s.roots
+}
+
+// RootPaths returns the absolute paths of the roots.
+func (s *Scanner) RootPaths() []string {
+	paths := make([]string, len(s.roots))
+	for i, r := range s.roots {
+		paths[i] = r.Path
+	}
+	return paths
+}
+
+// SetJobs sets the number of concurrent workers used by Scan.
+// Values below 1 are treated as 1.
+func (s *Scanner) SetJobs(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.jobs = n
+}
+
+// SetAliases sets the aliases resolving extracted codes to canonical codes.
+func (s *Scanner) SetAliases(aliases *code.Aliases) {
+	s.aliases = aliases
+}
+
+// SetFilter sets the filter selecting the files recorded by extension, size
+// and mtime. Directory items are recorded whole, without filtering.
+func (s *Scanner) SetFilter(f *filter.Filter) {
+	s.filter = f
+}
+
+// SetSidecars sets the rules of sidecar files, which are not recorded when
+// their primary file is in the same directory.
+func (s *Scanner) SetSidecars(rules *sidecar.Rules) {
+	s.sidecars = rules
+}
+
+// walkedFile is a file found while walking, before its code is extracted.
+type walkedFile struct {
+	path  string
+	root  Root
+	entry fs.DirEntry
+	// dir is set for a directory matched by a directory pattern, which is
+	// indexed as one item instead of being walked.
+	dir bool
+}
+
+// errorList collects errors from concurrent workers.
+type errorList struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (l *errorList) add(err error) {
+	l.mu.Lock()
+	l.errors = append(l.errors, err)
+	l.mu.Unlock()
+}
+
+// Scan scans every root and returns file records sorted by path.
+// See Stream for how roots are walked.
 func (s *Scanner) Scan(progress ProgressFunc) ([]db.FileRecord, *ScanResult, error) {
-	var files []string
-	var errors []error
+	out := make(chan db.FileRecord)
+	var records []db.FileRecord
+	done := make(chan struct{})
+	go func() {
+		for rec := range out {
+			records = append(records, rec)
+		}
+		close(done)
+	}()
 
-	// First pass: collect all files
-	err := filepath.WalkDir(s.rootDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			errors = append(errors, err)
-			return nil
+	result, err := s.Stream(progress, out)
+	<-done
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	return records, result, nil
+}
+
+// Stream scans every root and sends file records to out as they are
+// built, closing out when done. The caller must receive every record.
+// Directories are read concurrently, and codes are extracted and file info
+// is collected by a pool of workers while the walk goes on, so records
+// are sent in no particular order and never held all at once.
+// A root nested in another root is only walked as its own root.
+// Directories matched by a directory pattern are recorded as single items.
+// Progress totals count the files found so far, growing until the walk ends.
+func (s *Scanner) Stream(progress ProgressFunc, out chan<- db.FileRecord) (*ScanResult, error) {
+	defer close(out)
+	for _, root := range s.roots {
+		if _, err := os.Stat(root.Path); err != nil {
+			return nil, err
 		}
+	}
 
-		relPath, _ := filepath.Rel(s.rootDir, path)
-		if s.shouldIgnore(relPath, d.IsDir()) {
-			if d.IsDir() {
-				return filepath.SkipDir
+	var errs errorList
+	var processed, total, added int
+	var progressMu sync.Mutex
+
+	files := make(chan walkedFile, s.jobs)
+	go func() {
+		for _, root := range s.roots {
+			s.walk(root, &errs, func(f walkedFile) {
+				progressMu.Lock()
+				total++
+				progressMu.Unlock()
+				files <- f
+			})
+		}
+		close(files)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				rec, ok := s.record(f, &errs)
+				if ok {
+					out <- rec
+				}
+				// Report under the lock so progress never goes backwards
+				progressMu.Lock()
+				processed++
+				if ok {
+					added++
+				}
+				if progress != nil {
+					progress(processed, total)
+				}
+				progressMu.Unlock()
 			}
-			return nil
+		}()
+	}
+	wg.Wait()
+
+	return &ScanResult{
+		TotalFiles: total,
+		AddedFiles: added,
+		Errors:     errs.errors,
+	}, nil
+}
+
+// queuedDir is a directory waiting to be read by walk, with the ignore
+// rules that apply in it.
+type queuedDir struct {
+	path    string
+	matcher *ignore.Matcher
+}
+
+// walk reads the directory tree under root with s.jobs concurrent readers
+// and passes every file that is not ignored to emit.
+// Directories found are put on a shared stack that the readers take from,
+// so no more than s.jobs directories are read at a time.
+func (s *Scanner) walk(root Root, errs *errorList, emit func(walkedFile)) {
+	var (
+		mu    sync.Mutex
+		ready = sync.NewCond(&mu)
+		queue = []queuedDir{{path: root.Path, matcher: s.matchers[root.Path]}}
+		// pending counts directories queued or being read
+		pending = 1
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 {
+					ready.Wait()
+				}
+				if len(queue) == 0 {
+					mu.Unlock()
+					return
+				}
+				qd := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				subdirs, found := s.readDir(root, qd, errs)
+				for _, f := range found {
+					emit(f)
+				}
+
+				mu.Lock()
+				queue = append(queue, subdirs...)
+				pending += len(subdirs) - 1
+				ready.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// readDir reads one queued directory and returns its subdirectories to
+// walk and the files in it that are not ignored.
+func (s *Scanner) readDir(root Root, qd queuedDir, errs *errorList) ([]queuedDir, []walkedFile) {
+	dir := qd.path
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		errs.add(err)
+	}
+	m := qd.matcher
+	for _, d := range entries {
+		if d.Name() == ignore.FileName && d.Type().IsRegular() {
+			if m, err = s.withIgnoreFile(m, root, dir); err != nil {
+				errs.add(err)
+				m = qd.matcher
+			}
+			break
+		}
+	}
+
+	var subdirs []queuedDir
+	var found []walkedFile
+	for _, d := range entries {
+		path := filepath.Join(dir, d.Name())
+		relPath, _ := filepath.Rel(root.Path, path)
+		if m.Match(relPath, d.IsDir()) {
+			continue
 		}
 
 		// Skip symlinks
 		if d.Type()&fs.ModeSymlink != 0 {
-			return nil
+			continue
 		}
 
-		if !d.IsDir() {
-			files = append(files, path)
+		if d.IsDir() {
+			if s.isRoot(path) {
+				continue
+			}
+			if s.dirItems {
+				if _, ok := s.extractor.MatchDir(relPath); ok {
+					found = append(found, walkedFile{path: path, root: root, entry: d, dir: true})
+					continue
+				}
+			}
+			subdirs = append(subdirs, queuedDir{path: path, matcher: m})
+		} else {
+			found = append(found, walkedFile{path: path, root: root, entry: d})
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, nil, err
 	}
 
-	// Second pass: extract codes and create records
-	var records []db.FileRecord
-	total := len(files)
+	if s.sidecars != nil {
+		found = s.dropSidecars(found)
+	}
+	return subdirs, found
+}
 
-	for i, path := range files {
-		if progress != nil {
-			progress(i+1, total)
+// dropSidecars removes the sidecars of other files from the files found
+// in one directory.
+func (s *Scanner) dropSidecars(found []walkedFile) []walkedFile {
+	var names []string
+	for _, f := range found {
+		if !f.dir && f.entry.Type().IsRegular() {
+			names = append(names, f.entry.Name())
 		}
-
-		filename := filepath.Base(path)
-		normalized, found := s.extractor.Extract(filename)
-		if !found {
+	}
+	primaries := s.sidecars.Primaries(names)
+	if len(primaries) == 0 {
+		return found
+	}
+	kept := found[:0]
+	for _, f := range found {
+		if _, ok := primaries[f.entry.Name()]; ok && !f.dir {
 			continue
 		}
+		kept = append(kept, f)
+	}
+	return kept
+}
 
-		info, err := os.Stat(path)
+// record extracts the code of a walked file and builds its record.
+func (s *Scanner) record(f walkedFile, errs *errorList) (db.FileRecord, bool) {
+	relPath, _ := filepath.Rel(f.root.Path, f.path)
+	if f.dir {
+		match, _ := s.extractor.MatchDir(relPath)
+		rec, err := s.dirRecord(f.root, f.path, match)
 		if err != nil {
-			errors = append(errors, err)
-			continue
+			errs.add(err)
+			return db.FileRecord{}, false
 		}
+		return rec, true
+	}
 
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			errors = append(errors, err)
-			continue
-		}
+	match, found := s.extractor.Match(relPath)
+	if !found {
+		return db.FileRecord{}, false
+	}
 
-		records = append(records, db.FileRecord{
-			Path:  absPath,
-			Code:  normalized,
-			Size:  info.Size(),
-			Mtime: info.ModTime(),
-		})
+	// Info comes from the directory read where possible, avoiding another stat
+	info, err := f.entry.Info()
+	if err != nil {
+		errs.add(err)
+		return db.FileRecord{}, false
 	}
 
-	result := &ScanResult{
-		TotalFiles: total,
-		AddedFiles: len(records),
-		Errors:     errors,
+	if match, found = s.filterFile(relPath, info, match); !found {
+		return db.FileRecord{}, false
 	}
 
-	return records, result, nil
+	rec := db.FileRecord{
+		Path:   f.path,
+		Code:   match.Code,
+		Codes:  match.Codes,
+		Size:   info.Size(),
+		Mtime:  info.ModTime(),
+		Root:   f.root.Path,
+		Volume: f.root.Volume,
+	}
+	s.resolveAliases(&rec)
+	return rec, true
 }
 
-// shouldIgnore checks if a path should be ignored based on patterns.
-func (s *Scanner) shouldIgnore(relPath string, isDir bool) bool {
-	// Split path into components for matching
-	parts := strings.Split(relPath, string(filepath.Separator))
+// filterFile applies the scanner's filter and the filters of patterns to a
+// file whose name matched without them. It returns the match of the first
+// pattern whose filter selects the file.
+func (s *Scanner) filterFile(relPath string, info fs.FileInfo, match code.Match) (code.Match, bool) {
+	if !s.filter.Match(info.Name(), info.Size(), info.ModTime()) {
+		return code.Match{}, false
+	}
+	if !s.extractor.HasFilters() {
+		return match, true
+	}
+	return s.extractor.MatchFile(relPath, info.Size(), info.ModTime())
+}
 
-	for _, pattern := range s.ignorePatterns {
-		// Directory pattern (ends with /)
-		if strings.HasSuffix(pattern, "/") {
-			dirPattern := strings.TrimSuffix(pattern, "/")
-			// Check if any path component matches the directory pattern
-			for _, part := range parts {
-				if matchPattern(part, dirPattern) {
-					return true
-				}
+// resolveAliases replaces the codes of rec with their canonical codes and
+// records the codes found in the name.
+func (s *Scanner) resolveAliases(rec *db.FileRecord) {
+	if s.aliases == nil {
+		return
+	}
+	var codes []string
+	seen := make(map[string]bool)
+	for _, c := range rec.AllCodes() {
+		canonical, aliased := s.aliases.Resolve(c)
+		if seen[canonical] {
+			// A code found directly wins over the same code reached by alias
+			if !aliased {
+				delete(rec.AliasOf, canonical)
 			}
 			continue
 		}
+		seen[canonical] = true
+		codes = append(codes, canonical)
+		if aliased {
+			if rec.AliasOf == nil {
+				rec.AliasOf = make(map[string]string)
+			}
+			rec.AliasOf[canonical] = c
+		}
+	}
+	rec.Code = codes[0]
+	rec.Codes = nil
+	if len(codes) > 1 {
+		rec.Codes = codes
+	}
+	if len(rec.AliasOf) == 0 {
+		rec.AliasOf = nil
+	}
+}
 
-		// File pattern - match against full path and each component
-		if matchPattern(relPath, pattern) {
-			return true
+// dirRecord builds the record of a directory item. Its size is the total
+// size of the files in it that are not ignored, and its mtime the latest
+// mtime of the directory and those files.
+func (s *Scanner) dirRecord(root Root, dir string, match code.Match) (db.FileRecord, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return db.FileRecord{}, err
+	}
+	rec := db.FileRecord{
+		Path:   dir,
+		Code:   match.Code,
+		Codes:  match.Codes,
+		Mtime:  info.ModTime(),
+		Root:   root.Path,
+		Volume: root.Volume,
+	}
+
+	// Ignore rules of each directory walked, including its .fdupignore
+	matchers := map[string]*ignore.Matcher{dir: s.matcherFor(root, dir)}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-		// Match against filename
-		if matchPattern(filepath.Base(relPath), pattern) {
-			return true
+		if path == dir {
+			return nil
+		}
+		m := matchers[filepath.Dir(path)]
+		relPath, _ := filepath.Rel(root.Path, path)
+		if m.Match(relPath, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		// Match against any path component (for patterns like .git)
-		for _, part := range parts {
-			if matchPattern(part, pattern) {
-				return true
+		if d.IsDir() {
+			if matchers[path], err = s.withIgnoreFile(m, root, path); err != nil {
+				return err
 			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
 		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rec.Size += fi.Size()
+		if fi.ModTime().After(rec.Mtime) {
+			rec.Mtime = fi.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return db.FileRecord{}, err
 	}
-	return false
+	s.resolveAliases(&rec)
+	return rec, nil
 }
 
-// matchPattern does simple glob matching (* wildcard).
-func matchPattern(name, pattern string) bool {
-	// Handle simple * wildcard
-	if strings.Contains(pattern, "*") {
-		matched, _ := filepath.Match(pattern, name)
-		return matched
+// Record builds the record of a single file or directory item, as Scan
+// would. path is absolute. For a file inside a directory item, the record
+// of the directory is returned. It reports false for symlinks, for
+// directories that are not items, for sidecars of other files, and when
+// the path has no code.
+func (s *Scanner) Record(path string) (db.FileRecord, bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return db.FileRecord{}, false, err
+	}
+
+	root, inRoot := s.rootOf(path)
+	if inRoot && s.dirItems {
+		if item, match, ok := s.itemOf(root, path); ok && (item != path || info.IsDir()) {
+			rec, err := s.dirRecord(root, item, match)
+			return rec, err == nil, err
+		}
+	}
+	if !info.Mode().IsRegular() || s.sidecars.IsSidecar(path) {
+		return db.FileRecord{}, false, nil
 	}
-	return name == pattern
+
+	relPath := filepath.Base(path)
+	if inRoot {
+		relPath, _ = filepath.Rel(root.Path, path)
+	}
+	match, found := s.extractor.Match(relPath)
+	if !found {
+		return db.FileRecord{}, false, nil
+	}
+	if match, found = s.filterFile(relPath, info, match); !found {
+		return db.FileRecord{}, false, nil
+	}
+
+	rec := db.FileRecord{
+		Path:  path,
+		Code:  match.Code,
+		Codes: match.Codes,
+		Size:  info.Size(),
+		Mtime: info.ModTime(),
+	}
+	if inRoot {
+		rec.Root = root.Path
+		rec.Volume = root.Volume
+	}
+	s.resolveAliases(&rec)
+	return rec, true, nil
+}
+
+// Item returns the directory item that path is: path itself when it is a
+// directory matched by a directory pattern, or the directory item it is in.
+// path is absolute.
+func (s *Scanner) Item(path string) (string, bool) {
+	if !s.dirItems {
+		return "", false
+	}
+	root, ok := s.rootOf(path)
+	if !ok {
+		return "", false
+	}
+	item, _, ok := s.itemOf(root, path)
+	if ok && item == path {
+		info, err := os.Stat(path)
+		ok = err == nil && info.IsDir()
+	}
+	return item, ok
+}
+
+// itemOf returns the outermost directory item among path and its parents
+// under root, as the walk would find it. path itself is included without
+// checking that it is a directory.
+func (s *Scanner) itemOf(root Root, path string) (string, code.Match, bool) {
+	relPath, err := filepath.Rel(root.Path, path)
+	if err != nil || relPath == "." {
+		return "", code.Match{}, false
+	}
+	m := s.matcherFor(root, filepath.Dir(path))
+	parts := strings.Split(relPath, string(filepath.Separator))
+	for i := 1; i <= len(parts); i++ {
+		sub := filepath.Join(parts[:i]...)
+		if m.Match(sub, true) {
+			return "", code.Match{}, false
+		}
+		if match, ok := s.extractor.MatchDir(sub); ok {
+			return filepath.Join(root.Path, sub), match, true
+		}
+	}
+	return "", code.Match{}, false
+}
+
+// Ignored reports whether path, or a directory above it, is matched by the
+// ignore rules of the root it belongs to, including those of .fdupignore
+// files. path is absolute or relative to the working directory; paths
+// outside every root are always ignored.
+func (s *Scanner) Ignored(path string, isDir bool) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	root, ok := s.rootOf(absPath)
+	if !ok {
+		return true
+	}
+	relPath, _ := filepath.Rel(root.Path, absPath)
+	if relPath == "." {
+		return false
+	}
+	return s.matcherFor(root, filepath.Dir(absPath)).Excluded(relPath, isDir)
+}
+
+// withIgnoreFile adds the rules of the .fdupignore file in dir, if any, to m.
+func (s *Scanner) withIgnoreFile(m *ignore.Matcher, root Root, dir string) (*ignore.Matcher, error) {
+	rules, err := ignore.ReadFile(filepath.Join(dir, ignore.FileName))
+	if err != nil {
+		return m, err
+	}
+	relDir, _ := filepath.Rel(root.Path, dir)
+	return m.Child(relDir, rules), nil
+}
+
+// matcherFor returns the ignore rules that apply in dir, a directory under
+// root, reading the .fdupignore files from root down to dir. Unreadable
+// ignore files are skipped; Scan reports them.
+func (s *Scanner) matcherFor(root Root, dir string) *ignore.Matcher {
+	m := s.matchers[root.Path]
+	relDir, err := filepath.Rel(root.Path, dir)
+	if err != nil || relDir == ".." || strings.HasPrefix(relDir, ".."+string(filepath.Separator)) {
+		return m
+	}
+
+	cur := root.Path
+	m, _ = s.withIgnoreFile(m, root, cur)
+	if relDir == "." {
+		return m
+	}
+	for _, part := range strings.Split(relDir, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		m, _ = s.withIgnoreFile(m, root, cur)
+	}
+	return m
+}
+
+// rootOf returns the innermost root containing path.
+func (s *Scanner) rootOf(path string) (Root, bool) {
+	var best Root
+	found := false
+	for _, r := range s.roots {
+		rel, err := filepath.Rel(r.Path, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if !found || len(r.Path) > len(best.Path) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// isRoot reports whether path is one of the scanner's roots.
+func (s *Scanner) isRoot(path string) bool {
+	for _, r := range s.roots {
+		if r.Path == path {
+			return true
+		}
+	}
+	return false
 }
 
 // ExtractCode extracts a code from a filename using the scanner's extractor.
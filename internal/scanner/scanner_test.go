@@ -0,0 +1,496 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/code"
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/filter"
+	"github.com/jiikko/fdup/internal/sidecar"
+)
+
+func TestScanMultipleRoots(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := []string{
+		"nas/a/DSC00001.jpg",
+		"pics/b/DSC00001.jpg",
+		"pics/skip/DSC00002.jpg",
+		"pics/nested/DSC00003.jpg",
+		"pics/b/notes.txt",
+	}
+	for _, f := range files {
+		path := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	nas := filepath.Join(tmpDir, "nas")
+	pics := filepath.Join(tmpDir, "pics")
+	nested := filepath.Join(pics, "nested")
+	s, err := New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, nil, []Root{
+		{Path: nas},
+		{Path: pics, Ignore: []string{"skip/"}},
+		{Path: nested},
+	})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+
+	records, _, err := s.Scan(nil)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	want := map[string]string{
+		filepath.Join(nas, "a", "DSC00001.jpg"):  nas,
+		filepath.Join(nested, "DSC00003.jpg"):    nested,
+		filepath.Join(pics, "b", "DSC00001.jpg"): pics,
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got %+v", len(want), records)
+	}
+	for _, rec := range records {
+		root, ok := want[rec.Path]
+		if !ok {
+			t.Errorf("unexpected record %s", rec.Path)
+			continue
+		}
+		if rec.Root != root {
+			t.Errorf("expected root %s for %s, got %s", root, rec.Path, rec.Root)
+		}
+	}
+
+	// Root ignore rules apply only under their root
+	if !s.Ignored(filepath.Join(pics, "skip", "DSC00002.jpg"), false) {
+		t.Error("expected file under ignored directory to be ignored")
+	}
+	if s.Ignored(filepath.Join(nas, "skip", "DSC00002.jpg"), false) {
+		t.Error("expected ignore rule of another root not to apply")
+	}
+	if !s.Ignored(filepath.Join(tmpDir, "DSC00004.jpg"), false) {
+		t.Error("expected file outside every root to be ignored")
+	}
+}
+
+func TestScanDirectoryItems(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"PRJ-001/final/cover.png": "12345",
+		"PRJ-001/notes.txt":       "678",
+		"PRJ-001/cache.tmp":       "ignored",
+		"misc/DSC00001.jpg":       "",
+	}
+	for f, content := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	s, err := New([]code.Pattern{
+		{Regex: `^([A-Z]{3}-\d{3})$`, Directory: true},
+		{Regex: `([A-Z]{2,5})(\d{3,5})`},
+	}, []string{"*.tmp"}, []Root{{Path: root}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+
+	records, _, err := s.Scan(nil)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	item := records[0]
+	if item.Path != filepath.Join(root, "PRJ-001") || item.Code != "PRJ001" {
+		t.Errorf("expected the directory as an item, got %+v", item)
+	}
+	if item.Size != 8 {
+		t.Errorf("expected the size of the files that are not ignored, got %d", item.Size)
+	}
+
+	// A file in the directory is recorded as the directory
+	rec, ok, err := s.Record(filepath.Join(root, "PRJ-001", "final", "cover.png"))
+	if err != nil || !ok {
+		t.Fatalf("expected a record, got %v, %v", ok, err)
+	}
+	if rec.Path != item.Path || rec.Size != item.Size {
+		t.Errorf("expected the record of the directory, got %+v", rec)
+	}
+	if dir, ok := s.Item(filepath.Join(root, "PRJ-001", "final")); !ok || dir != item.Path {
+		t.Errorf("expected %s to be in the item, got %q", "final", dir)
+	}
+	if _, ok := s.Item(filepath.Join(root, "misc")); ok {
+		t.Error("expected misc not to be an item")
+	}
+}
+
+func TestScanHonorsIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"shared/.fdupignore":           "*.jpg\n!keep/*.jpg\n/drafts/\n",
+		"shared/DSC00001.jpg":          "",
+		"shared/keep/DSC00002.jpg":     "",
+		"shared/drafts/DSC00003.png":   "",
+		"shared/x/drafts/DSC00004.png": "",
+		"DSC00005.jpg":                 "",
+		"raw/a/DSC00006.arw":           "",
+	}
+	for f, content := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	s, err := New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, []string{"raw/**"}, []Root{{Path: root}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	records, _, err := s.Scan(nil)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	var got []string
+	for _, rec := range records {
+		rel, _ := filepath.Rel(root, rec.Path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{"DSC00005.jpg", "shared/keep/DSC00002.jpg", "shared/x/drafts/DSC00004.png"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+			break
+		}
+	}
+
+	// Ignored answers the same for single paths
+	if !s.Ignored(filepath.Join(root, "shared", "drafts", "DSC00003.png"), false) {
+		t.Error("expected a file in an ignored directory to be ignored")
+	}
+	if s.Ignored(filepath.Join(root, "shared", "keep", "DSC00002.jpg"), false) {
+		t.Error("expected a re-included file not to be ignored")
+	}
+}
+
+func TestScanAppliesFilters(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a/DSC00001.mp4":    "video",
+		"a/DSC00001.xmp":    "sidecar",
+		"b/DSC00001.mp4":    "",
+		"b/IMG00002.jpg":    "small",
+		"b/IMG00003.jpg":    "large enough",
+		"c/DSC00004.mp4":    "old",
+		"c/archive/XYZ0005": "no extension",
+	}
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for f, content := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(root, "c", "DSC00004.mp4"), old, old); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	since, err := filter.ParseTime("2021-01-01")
+	if err != nil {
+		t.Fatalf("failed to parse time: %v", err)
+	}
+	patterns := []code.Pattern{
+		// Images must be larger than 5 bytes
+		{Regex: `IMG(\d{5})`, Filter: &filter.Filter{MinSize: 6}},
+		{Regex: `([A-Z]{3})(\d{4,5})`},
+	}
+	s, err := New(patterns, nil, []Root{{Path: root}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	s.SetFilter(&filter.Filter{ExcludeExtensions: []string{"xmp"}, MinSize: 1, ModifiedAfter: since})
+
+	records, _, err := s.Scan(nil)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, rec := range records {
+		rel, _ := filepath.Rel(root, rec.Path)
+		got[filepath.ToSlash(rel)] = rec.Code
+	}
+	// The small image falls through to the second pattern
+	want := map[string]string{
+		"a/DSC00001.mp4":    "DSC00001",
+		"b/IMG00002.jpg":    "IMG00002",
+		"b/IMG00003.jpg":    "00003",
+		"c/archive/XYZ0005": "XYZ0005",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for path, c := range want {
+		if got[path] != c {
+			t.Errorf("%s: expected %s, got %q", path, c, got[path])
+		}
+	}
+
+	// Record filters single files the same way
+	if _, ok, err := s.Record(filepath.Join(root, "a", "DSC00001.xmp")); err != nil || ok {
+		t.Errorf("expected the sidecar not to be recorded, got %v, %v", ok, err)
+	}
+}
+
+func TestScanSkipsSidecars(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{"a/DSC00001.ARW", "a/DSC00001.JPG", "a/DSC00001.ARW.xmp", "b/DSC00001.JPG", "b/DSC00001.xmp"} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	s, err := New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, nil, []Root{{Path: root}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	s.SetSidecars(sidecar.New([]string{"jpg", "xmp"}))
+	records, _, err := s.Scan(nil)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	// Without a primary file, the first sidecar extension is indexed
+	var got []string
+	for _, rec := range records {
+		rel, _ := filepath.Rel(root, rec.Path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{"a/DSC00001.ARW", "b/DSC00001.JPG"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+			break
+		}
+	}
+
+	if _, ok, err := s.Record(filepath.Join(root, "a", "DSC00001.JPG")); err != nil || ok {
+		t.Errorf("expected the sidecar not to be recorded, got %v, %v", ok, err)
+	}
+}
+
+func TestScanConcurrentWalk(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	// A wide and deep tree, with ignored files at every level
+	want := make(map[string]bool)
+	n := 0
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 4; j++ {
+			dir := fmt.Sprintf("d%d/s%d/t%d", i, j, (i+j)%3)
+			for k := 0; k < 3; k++ {
+				n++
+				name := fmt.Sprintf("%s/DSC%05d.jpg", dir, n)
+				write(name, "")
+				want[name] = true
+			}
+			write(dir+"/cache/DSC99999.jpg", "")
+			write(fmt.Sprintf("%s/DSC%05d.tmp", dir, n), "")
+		}
+	}
+	// Rules of an ignore file deep in the tree apply only below it
+	write("d3/s1/.fdupignore", "t*/\n")
+	for name := range want {
+		if strings.HasPrefix(name, "d3/s1/t") {
+			delete(want, name)
+		}
+	}
+
+	for _, jobs := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("jobs=%d", jobs), func(t *testing.T) {
+			s, err := New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, []string{"cache/", "*.tmp"}, []Root{{Path: root}})
+			if err != nil {
+				t.Fatalf("failed to create scanner: %v", err)
+			}
+			s.SetJobs(jobs)
+
+			last := 0
+			records, result, err := s.Scan(func(current, total int) {
+				if current != last+1 || current > total {
+					t.Errorf("unexpected progress %d/%d after %d", current, total, last)
+				}
+				last = current
+			})
+			if err != nil {
+				t.Fatalf("scan failed: %v", err)
+			}
+			if len(result.Errors) != 0 {
+				t.Errorf("unexpected errors: %v", result.Errors)
+			}
+			if last != result.TotalFiles {
+				t.Errorf("expected progress to reach %d, got %d", result.TotalFiles, last)
+			}
+
+			if len(records) != len(want) {
+				t.Errorf("expected %d records, got %d", len(want), len(records))
+			}
+			for i, rec := range records {
+				rel, _ := filepath.Rel(root, rec.Path)
+				if !want[filepath.ToSlash(rel)] {
+					t.Errorf("unexpected record %s", rel)
+				}
+				if i > 0 && records[i-1].Path >= rec.Path {
+					t.Errorf("expected records sorted without duplicates, got %s after %s", rec.Path, records[i-1].Path)
+				}
+			}
+		})
+	}
+}
+
+func TestScanCollectsErrors(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a/.fdupignore":  "[\n",
+		"a/DSC00001.jpg": "",
+		"b/DSC00002.jpg": "",
+		"c/DSC00003.jpg": "",
+	}
+	for f, content := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	wantErrors := 1
+	// Permissions do not stop root from reading a directory
+	unreadable := filepath.Join(root, "c")
+	if os.Geteuid() != 0 {
+		if err := os.Chmod(unreadable, 0); err != nil {
+			t.Fatalf("failed to chmod: %v", err)
+		}
+		t.Cleanup(func() { _ = os.Chmod(unreadable, 0755) })
+		wantErrors++
+	}
+
+	s, err := New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, nil, []Root{{Path: root}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	s.SetJobs(4)
+	records, result, err := s.Scan(nil)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	// Errors are reported and the rest of the tree is still scanned
+	if len(result.Errors) != wantErrors {
+		t.Errorf("expected %d errors, got %v", wantErrors, result.Errors)
+	}
+	found := make(map[string]bool)
+	for _, rec := range records {
+		found[filepath.Base(rec.Path)] = true
+	}
+	if !found["DSC00001.jpg"] || !found["DSC00002.jpg"] {
+		t.Errorf("expected the readable files to be recorded, got %+v", records)
+	}
+
+	// A missing root fails the whole scan
+	s, err = New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, nil, []Root{{Path: filepath.Join(root, "missing")}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	if _, _, err := s.Scan(nil); err == nil {
+		t.Error("expected a missing root to fail the scan")
+	}
+}
+
+func TestStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, f := range []string{"a/DSC00001.jpg", "b/c/DSC00002.jpg", "b/notes.txt"} {
+		path := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	s, err := New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, nil, []Root{{Path: tmpDir}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+
+	// Records arrive before the scan returns; an unbuffered channel would
+	// block forever otherwise
+	out := make(chan db.FileRecord)
+	got := make(chan int)
+	go func() {
+		n := 0
+		for range out {
+			n++
+		}
+		got <- n
+	}()
+	result, err := s.Stream(nil, out)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if n := <-got; n != 2 || result.AddedFiles != 2 || result.TotalFiles != 3 {
+		t.Errorf("expected 2 of 3 files streamed, got %d and %+v", n, result)
+	}
+
+	// A missing root fails the scan before anything is sent
+	s, err = New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, nil, []Root{{Path: filepath.Join(tmpDir, "missing")}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	out = make(chan db.FileRecord, 1)
+	if _, err := s.Stream(nil, out); err == nil {
+		t.Error("expected an error for a missing root")
+	}
+	if _, ok := <-out; ok {
+		t.Error("expected the channel to be closed without records")
+	}
+}
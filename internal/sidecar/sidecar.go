@@ -0,0 +1,211 @@
+package sidecar
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jiikko/fdup/internal/filter"
+)
+
+// Rules find the sidecar files of a file: files in the same directory with
+// one of the sidecar extensions whose name is the file's name with its
+// extension replaced or extended, such as DSC00001.xmp or DSC00001.ARW.xmp
+// next to DSC00001.ARW. A file and its sidecars form one logical item.
+//
+// When the files of a stem all have sidecar extensions, the one whose
+// extension is listed first is the primary file, such as DSC00001.JPG for
+// DSC00001.xmp with the extensions jpg and xmp.
+type Rules struct {
+	extensions []string
+}
+
+// New returns rules for the given sidecar extensions, with or without the
+// dot. It returns nil, which finds no sidecars, when there are none.
+func New(extensions []string) *Rules {
+	if len(extensions) == 0 {
+		return nil
+	}
+	return &Rules{extensions: extensions}
+}
+
+// isSidecarExt reports whether name has a sidecar extension.
+func (r *Rules) isSidecarExt(name string) bool {
+	return filter.HasExtension(r.extensions, filepath.Ext(name))
+}
+
+// rank returns the position of the extension of name in the rules.
+func (r *Rules) rank(name string) int {
+	for i, ext := range r.extensions {
+		if filter.HasExtension([]string{ext}, filepath.Ext(name)) {
+			return i
+		}
+	}
+	return len(r.extensions)
+}
+
+// Primaries maps each sidecar among names, the regular files of one
+// directory, to the name of its primary file.
+func (r *Rules) Primaries(names []string) map[string]string {
+	if r == nil {
+		return nil
+	}
+
+	// Primary files by stem and by full name
+	primaries := make(map[string]string)
+	for _, name := range names {
+		if !r.isSidecarExt(name) {
+			primaries[name] = name
+			if stem := stemOf(name); stem != name {
+				if _, ok := primaries[stem]; !ok {
+					primaries[stem] = name
+				}
+			}
+		}
+	}
+
+	result := make(map[string]string)
+	// Sidecars without a primary file, by stem
+	orphans := make(map[string][]string)
+	for _, name := range names {
+		if !r.isSidecarExt(name) {
+			continue
+		}
+		if primary, ok := primaries[stemOf(name)]; ok {
+			result[name] = primary
+		} else {
+			orphans[stemOf(name)] = append(orphans[stemOf(name)], name)
+		}
+	}
+	for _, group := range orphans {
+		primary := group[0]
+		for _, name := range group[1:] {
+			if r.rank(name) < r.rank(primary) || (r.rank(name) == r.rank(primary) && name < primary) {
+				primary = name
+			}
+		}
+		for _, name := range group {
+			if name != primary {
+				result[name] = primary
+			}
+		}
+	}
+	return result
+}
+
+// Of returns the paths of the sidecars that belong to the file at path,
+// sorted by name. A sidecar of another file, a directory and a file
+// without sidecars have none.
+func (r *Rules) Of(path string) ([]string, error) {
+	if r == nil {
+		return nil, nil
+	}
+	l, err := r.list(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	return l.sidecarsOf(path), nil
+}
+
+// Cache finds sidecars as Rules.Of does, reading each directory once.
+// It does not see later changes, so it serves one pass over many files,
+// such as rendering a page; operations on files use Rules.Of.
+type Cache struct {
+	rules *Rules
+	dirs  map[string]*listing
+}
+
+// Cache returns an empty cache for the rules. A cache of nil rules finds
+// no sidecars.
+func (r *Rules) Cache() *Cache {
+	return &Cache{rules: r, dirs: make(map[string]*listing)}
+}
+
+// Of returns the sidecars of the file at path, as Rules.Of does.
+func (c *Cache) Of(path string) ([]string, error) {
+	if c.rules == nil {
+		return nil, nil
+	}
+	dir := filepath.Dir(path)
+	l, ok := c.dirs[dir]
+	if !ok {
+		var err error
+		if l, err = c.rules.list(dir); err != nil {
+			return nil, err
+		}
+		c.dirs[dir] = l
+	}
+	return l.sidecarsOf(path), nil
+}
+
+// listing holds the regular files of a directory and their primaries.
+type listing struct {
+	files     map[string]bool
+	primaries map[string]string
+}
+
+// list reads dir and pairs its sidecars with their primary files.
+func (r *Rules) list(dir string) (*listing, error) {
+	names, err := regularFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &listing{files: make(map[string]bool, len(names)), primaries: r.Primaries(names)}
+	for _, name := range names {
+		l.files[name] = true
+	}
+	return l, nil
+}
+
+// sidecarsOf returns the sidecars of the file at path, which is in the
+// listed directory, sorted by name.
+func (l *listing) sidecarsOf(path string) []string {
+	name := filepath.Base(path)
+	// Directories, symlinks and missing files have no sidecars
+	if !l.files[name] {
+		return nil
+	}
+	var sidecars []string
+	for other, primary := range l.primaries {
+		if primary == name {
+			sidecars = append(sidecars, filepath.Join(filepath.Dir(path), other))
+		}
+	}
+	sort.Strings(sidecars)
+	return sidecars
+}
+
+// IsSidecar reports whether the file at path is the sidecar of another
+// file in its directory.
+func (r *Rules) IsSidecar(path string) bool {
+	if r == nil || !r.isSidecarExt(path) {
+		return false
+	}
+	names, err := regularFiles(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	_, ok := r.Primaries(names)[filepath.Base(path)]
+	return ok
+}
+
+// regularFiles returns the names of the regular files in dir.
+func regularFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// stemOf returns name without its extension.
+func stemOf(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
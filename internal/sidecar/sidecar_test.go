@@ -0,0 +1,106 @@
+package sidecar
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrimaries(t *testing.T) {
+	r := New([]string{"xmp", ".THM", "jpg"})
+	names := []string{
+		"DSC00001.ARW", "DSC00001.JPG", "DSC00001.xmp", "DSC00001.ARW.xmp",
+		"DSC00002.JPG", "DSC00002.xmp",
+		"MOV00003.thm",
+	}
+	want := map[string]string{
+		"DSC00001.JPG":     "DSC00001.ARW",
+		"DSC00001.xmp":     "DSC00001.ARW",
+		"DSC00001.ARW.xmp": "DSC00001.ARW",
+		// Without a primary file, the extension listed first wins
+		"DSC00002.JPG": "DSC00002.xmp",
+	}
+	if got := r.Primaries(names); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if got := New(nil).Primaries(names); got != nil {
+		t.Errorf("expected no sidecars without rules, got %v", got)
+	}
+}
+
+func TestOf(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"DSC00001.ARW", "DSC00001.JPG", "DSC00001.xmp", "DSC00002.JPG", "DSC00002.xmp", "DSC00003.ARW"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	r := New([]string{"jpg", "xmp"})
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"DSC00001.ARW", []string{"DSC00001.JPG", "DSC00001.xmp"}},
+		// A sidecar of another file has none of its own
+		{"DSC00001.JPG", nil},
+		// Without a primary file, the extension listed first is the primary
+		{"DSC00002.JPG", []string{"DSC00002.xmp"}},
+		{"DSC00002.xmp", nil},
+		{"DSC00003.ARW", nil},
+	}
+	for _, tt := range tests {
+		got, err := r.Of(filepath.Join(dir, tt.name))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		var names []string
+		for _, p := range got {
+			names = append(names, filepath.Base(p))
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, names)
+		}
+	}
+
+	if !r.IsSidecar(filepath.Join(dir, "DSC00001.xmp")) || r.IsSidecar(filepath.Join(dir, "DSC00002.JPG")) {
+		t.Error("expected DSC00001.xmp to be a sidecar and DSC00002.JPG not")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"DSC00001.ARW", "DSC00001.xmp", "DSC00002.ARW"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	c := New([]string{"xmp"}).Cache()
+
+	got, err := c.Of(filepath.Join(dir, "DSC00001.ARW"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || filepath.Base(got[0]) != "DSC00001.xmp" {
+		t.Errorf("expected DSC00001.xmp, got %v", got)
+	}
+
+	// The directory is read once, so a sidecar added later is not seen
+	if err := os.WriteFile(filepath.Join(dir, "DSC00002.xmp"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if got, _ := c.Of(filepath.Join(dir, "DSC00002.ARW")); len(got) != 0 {
+		t.Errorf("expected the cached listing, got %v", got)
+	}
+	if got, _ := New([]string{"xmp"}).Of(filepath.Join(dir, "DSC00002.ARW")); len(got) != 1 {
+		t.Errorf("expected Rules.Of to read the directory again, got %v", got)
+	}
+
+	// Without rules nothing is a sidecar
+	var none *Rules
+	if got, err := none.Cache().Of(filepath.Join(dir, "DSC00001.ARW")); err != nil || got != nil {
+		t.Errorf("expected no sidecars, got %v, %v", got, err)
+	}
+}
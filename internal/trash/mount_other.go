@@ -0,0 +1,13 @@
+//go:build !unix
+
+package trash
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// mountPoint is not supported on this platform.
+func mountPoint(path string) (string, error) {
+	return "", fmt.Errorf("cannot trash %s across volumes on %s", path, runtime.GOOS)
+}
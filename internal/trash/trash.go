@@ -0,0 +1,278 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Item describes a file that was moved to the trash.
+type Item struct {
+	// OriginalPath is the absolute path the file had before it was trashed.
+	OriginalPath string
+	// Path is the location of the file inside the trash. It is empty when
+	// the platform has no trash and the file was deleted instead.
+	Path string
+	// InfoPath is the .trashinfo file written for the item, if any.
+	InfoPath string
+}
+
+// Move moves path to the trash and returns where it went.
+//
+// On Linux and other freedesktop systems it follows the XDG trash
+// specification: the file goes to the home trash with a .trashinfo file so
+// file managers can restore it, and to $topdir/.Trash/$uid or
+// $topdir/.Trash-$uid when it lives on another volume. On macOS it goes to
+// ~/.Trash or the volume's .Trashes. Elsewhere the file is deleted.
+// Existing trashed files are never overwritten.
+func Move(path string) (*Item, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(abs); err != nil {
+		return nil, err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return moveDarwin(abs)
+	case "windows", "plan9", "js", "wasip1":
+		// Fallback: just delete
+		if err := os.Remove(abs); err != nil {
+			return nil, err
+		}
+		return &Item{OriginalPath: abs}, nil
+	default:
+		return moveXDG(abs)
+	}
+}
+
+// Restore moves a trashed item back to its original path and removes its
+// .trashinfo file. It refuses to overwrite a file at the original path.
+func Restore(item Item) error {
+	if item.Path == "" {
+		return fmt.Errorf("%s was deleted and cannot be restored", item.OriginalPath)
+	}
+	if _, err := os.Lstat(item.OriginalPath); err == nil {
+		return fmt.Errorf("cannot restore %s: file already exists", item.OriginalPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(item.OriginalPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(item.Path, item.OriginalPath); err != nil {
+		return err
+	}
+	if item.InfoPath != "" {
+		if err := os.Remove(item.InfoPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// HomeDir returns the XDG home trash directory.
+func HomeDir() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+func moveXDG(path string) (*Item, error) {
+	home, err := HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	item, err := moveInto(home, path, path)
+	if err == nil || !isCrossDevice(err) {
+		return item, err
+	}
+
+	// The file is on another volume; use that volume's trash
+	top, err := mountPoint(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := volumeDir(top)
+	if err != nil {
+		return nil, err
+	}
+	// Volume trashes record paths relative to the top directory
+	rel, err := filepath.Rel(top, path)
+	if err != nil {
+		return nil, err
+	}
+	return moveInto(dir, path, rel)
+}
+
+// volumeDir returns the trash directory for the volume mounted at top,
+// creating it if needed.
+func volumeDir(top string) (string, error) {
+	uid := strconv.Itoa(os.Getuid())
+
+	// An administrator-provided $topdir/.Trash must be a sticky directory,
+	// not a symlink, to be used
+	shared := filepath.Join(top, ".Trash")
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		dir := filepath.Join(shared, uid)
+		if err := ensureDir(dir); err == nil {
+			return dir, nil
+		}
+	}
+
+	dir := filepath.Join(top, ".Trash-"+uid)
+	if err := ensureDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// ensureDir creates dir with owner-only permissions and refuses symlinks.
+func ensureDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("trash directory %s is not a directory", dir)
+	}
+	return nil
+}
+
+// moveInto moves path into the trash directory dir, recording infoPath as
+// the original location in the .trashinfo file.
+func moveInto(dir, path, infoPath string) (*Item, error) {
+	filesDir := filepath.Join(dir, "files")
+	infoDir := filepath.Join(dir, "info")
+	if err := ensureDir(filesDir); err != nil {
+		return nil, err
+	}
+	if err := ensureDir(infoDir); err != nil {
+		return nil, err
+	}
+
+	// The info file is created first and exclusively, which reserves the
+	// name against other processes trashing a file with the same name
+	info, name, err := reserveName(infoDir, filesDir, filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	infoFile := info.Name()
+
+	content := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		escapePath(infoPath), time.Now().Format("2006-01-02T15:04:05"))
+	_, err = info.WriteString(content)
+	if closeErr := info.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(infoFile)
+		return nil, err
+	}
+
+	dest := filepath.Join(filesDir, name)
+	if err := os.Rename(path, dest); err != nil {
+		_ = os.Remove(infoFile)
+		return nil, err
+	}
+
+	return &Item{OriginalPath: path, Path: dest, InfoPath: infoFile}, nil
+}
+
+// reserveName finds a name not used in filesDir and creates its info file.
+// Collisions are resolved as "name.2.ext", "name.3.ext", and so on.
+func reserveName(infoDir, filesDir, base string) (*os.File, string, error) {
+	for n := 1; ; n++ {
+		name := uniqueName(base, n)
+		if _, err := os.Lstat(filepath.Join(filesDir, name)); err == nil {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return f, name, nil
+	}
+}
+
+func moveDarwin(path string) (*Item, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	item, err := moveUnique(filepath.Join(home, ".Trash"), path)
+	if err == nil || !isCrossDevice(err) {
+		return item, err
+	}
+
+	top, err := mountPoint(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(top, ".Trashes", strconv.Itoa(os.Getuid()))
+	return moveUnique(dir, path)
+}
+
+// moveUnique moves path into dir under a name that does not exist yet.
+func moveUnique(dir, path string) (*Item, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	base := filepath.Base(path)
+	for n := 1; ; n++ {
+		dest := filepath.Join(dir, uniqueName(base, n))
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		if err := os.Rename(path, dest); err != nil {
+			return nil, err
+		}
+		return &Item{OriginalPath: path, Path: dest}, nil
+	}
+}
+
+// uniqueName returns base for n == 1 and inserts ".n" before the extension
+// otherwise.
+func uniqueName(base string, n int) string {
+	if n == 1 {
+		return base
+	}
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		// Dotfiles such as ".profile" have no stem
+		stem, ext = base, ""
+	}
+	return fmt.Sprintf("%s.%d%s", stem, n, ext)
+}
+
+// escapePath URL-escapes each path segment as required for .trashinfo.
+func escapePath(path string) string {
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
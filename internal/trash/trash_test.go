@@ -0,0 +1,151 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func setupTrash(t *testing.T) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("XDG trash is not used on " + runtime.GOOS)
+	}
+	tmpDir := t.TempDir()
+	dataHome := filepath.Join(tmpDir, "data")
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	return tmpDir, filepath.Join(dataHome, "Trash")
+}
+
+func TestMoveWritesTrashInfo(t *testing.T) {
+	tmpDir, trashDir := setupTrash(t)
+
+	path := filepath.Join(tmpDir, "my photos", "DSC00001.jpg")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	item, err := Move(path)
+	if err != nil {
+		t.Fatalf("failed to trash file: %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected file to be moved")
+	}
+	if item.Path != filepath.Join(trashDir, "files", "DSC00001.jpg") {
+		t.Errorf("unexpected trash path: %s", item.Path)
+	}
+	if item.InfoPath != filepath.Join(trashDir, "info", "DSC00001.jpg.trashinfo") {
+		t.Errorf("unexpected info path: %s", item.InfoPath)
+	}
+
+	data, err := os.ReadFile(item.InfoPath)
+	if err != nil {
+		t.Fatalf("failed to read trashinfo: %v", err)
+	}
+	info := string(data)
+	if !strings.HasPrefix(info, "[Trash Info]\n") {
+		t.Errorf("missing header in trashinfo: %q", info)
+	}
+	escaped := strings.ReplaceAll(path, " ", "%20")
+	if !strings.Contains(info, "Path="+escaped+"\n") {
+		t.Errorf("expected escaped path %s in trashinfo: %q", escaped, info)
+	}
+	if !strings.Contains(info, "DeletionDate=") {
+		t.Errorf("missing deletion date in trashinfo: %q", info)
+	}
+}
+
+func TestMoveDoesNotOverwrite(t *testing.T) {
+	tmpDir, trashDir := setupTrash(t)
+
+	var items []*Item
+	for i, dir := range []string{"a", "b", "c"} {
+		path := filepath.Join(tmpDir, dir, "DSC00001.jpg")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte{byte('0' + i)}, 0644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		item, err := Move(path)
+		if err != nil {
+			t.Fatalf("failed to trash %s: %v", path, err)
+		}
+		items = append(items, item)
+	}
+
+	expected := []string{"DSC00001.jpg", "DSC00001.2.jpg", "DSC00001.3.jpg"}
+	for i, item := range items {
+		if item.Path != filepath.Join(trashDir, "files", expected[i]) {
+			t.Errorf("expected %s, got %s", expected[i], item.Path)
+		}
+		data, err := os.ReadFile(item.Path)
+		if err != nil || string(data) != string(rune('0'+i)) {
+			t.Errorf("trashed file %s has wrong content: %q, %v", item.Path, data, err)
+		}
+		if _, err := os.Stat(item.InfoPath); err != nil {
+			t.Errorf("missing info file for %s: %v", item.Path, err)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	tmpDir, _ := setupTrash(t)
+
+	path := filepath.Join(tmpDir, "a", "DSC00001.jpg")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	item, err := Move(path)
+	if err != nil {
+		t.Fatalf("failed to trash file: %v", err)
+	}
+	if err := Restore(*item); err != nil {
+		t.Fatalf("failed to restore file: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected file to be restored: %v", err)
+	}
+	if _, err := os.Stat(item.Path); !os.IsNotExist(err) {
+		t.Error("expected file to be gone from the trash")
+	}
+	if _, err := os.Stat(item.InfoPath); !os.IsNotExist(err) {
+		t.Error("expected trashinfo to be removed")
+	}
+
+	// Restoring again must not overwrite the restored file
+	if err := Restore(*item); err == nil {
+		t.Error("expected error when the original path exists")
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	tests := []struct {
+		base string
+		n    int
+		want string
+	}{
+		{"DSC00001.jpg", 1, "DSC00001.jpg"},
+		{"DSC00001.jpg", 2, "DSC00001.2.jpg"},
+		{"archive.tar.gz", 3, "archive.tar.3.gz"},
+		{"README", 2, "README.2"},
+		{".profile", 2, ".profile.2"},
+	}
+	for _, tt := range tests {
+		if got := uniqueName(tt.base, tt.n); got != tt.want {
+			t.Errorf("uniqueName(%q, %d) = %q, want %q", tt.base, tt.n, got, tt.want)
+		}
+	}
+}
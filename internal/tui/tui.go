@@ -2,7 +2,6 @@ package tui
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
@@ -13,6 +12,9 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/fileops"
+	"github.com/jiikko/fdup/internal/sidecar"
+	"github.com/jiikko/fdup/internal/volume"
 )
 
 var (
@@ -56,13 +58,26 @@ type Model struct {
 	dryRun       bool
 	useTrash     bool
 	database     *db.DB
+	ops          *fileops.Executor
 	message      string
 	done         bool
 	err          error
+	// sidecars lists the sidecars shown with each file, reading each
+	// directory once until files are deleted or moved.
+	sidecars *sidecar.Cache
+	// offline names the volumes that are not mounted, by UUID. Their files
+	// cannot be selected or moved to, and are not counted as kept copies.
+	offline map[string]string
+	// display formats codes in group titles.
+	display *code.Display
 }
 
 // NewModel creates a new TUI model.
-func NewModel(groups []db.DuplicateGroup, database *db.DB, dryRun, useTrash bool) Model {
+// Files are deleted and moved with their sidecars.
+func NewModel(groups []db.DuplicateGroup, database *db.DB, dryRun, useTrash bool, sidecars *sidecar.Rules, display *code.Display) Model {
+	ops := fileops.New(database)
+	ops.SetSidecars(sidecars)
+
 	ti := textinput.New()
 	ti.Placeholder = "Enter directory path..."
 	ti.Width = 50
@@ -76,6 +91,9 @@ func NewModel(groups []db.DuplicateGroup, database *db.DB, dryRun, useTrash bool
 		dryRun:       dryRun,
 		useTrash:     useTrash,
 		database:     database,
+		ops:          ops,
+		sidecars:     ops.SidecarCache(),
+		display:      display,
 	}
 }
 
@@ -136,7 +154,11 @@ func (m Model) handleSelectFiles(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if idx >= 0 {
 			group := m.groups[m.currentGroup]
 			if idx < len(group.Files) {
-				m.selected[idx] = !m.selected[idx]
+				if name, ok := m.offline[group.Files[idx].Volume]; ok {
+					m.message = errorStyle.Render(fmt.Sprintf("%s is on the offline volume %s", group.Files[idx].Path, name))
+				} else {
+					m.selected[idx] = !m.selected[idx]
+				}
 			}
 		}
 		return m, nil
@@ -160,7 +182,11 @@ func (m Model) handleSelectAction(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.nextGroup()
 		return m, nil
 	case "d":
-		// Delete selected files
+		// Delete selected files, as long as a copy that can be reached is kept
+		if !m.keepsOnlineCopy() {
+			m.message = errorStyle.Render("Every copy left is on an offline volume; keep a file that is connected")
+			return m, nil
+		}
 		m.performDelete()
 		return m, nil
 	case "c":
@@ -189,7 +215,7 @@ func (m Model) handleSelectAction(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				idx = int(ch-'a') + 10
 			}
 		}
-		if idx >= 0 && idx < len(group.Files) && !m.selected[idx] {
+		if idx >= 0 && idx < len(group.Files) && !m.selected[idx] && !m.isOffline(group.Files[idx]) {
 			destDir := filepath.Dir(group.Files[idx].Path)
 			m.performMove(destDir)
 		}
@@ -219,32 +245,27 @@ func (m Model) handleCustomPath(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 }
 
 func (m *Model) performDelete() {
+	defer m.refreshSidecars()
 	group := m.groups[m.currentGroup]
 	count := len(m.selected)
 	var dryRunMsgs []string
 
+	sidecarCount := 0
 	for idx := range m.selected {
 		file := group.Files[idx]
+		sidecars := m.ops.Sidecars(file.Path)
+		sidecarCount += len(sidecars)
 		if m.dryRun {
 			if m.useTrash {
-				dryRunMsgs = append(dryRunMsgs, fmt.Sprintf("[DRY-RUN] Would trash: %s", file.Path))
+				dryRunMsgs = append(dryRunMsgs, fmt.Sprintf("[DRY-RUN] Would trash: %s%s", file.Path, withSidecars(sidecars)))
 			} else {
-				dryRunMsgs = append(dryRunMsgs, fmt.Sprintf("[DRY-RUN] Would delete: %s", file.Path))
+				dryRunMsgs = append(dryRunMsgs, fmt.Sprintf("[DRY-RUN] Would delete: %s%s", file.Path, withSidecars(sidecars)))
 			}
 		} else {
-			var err error
-			if m.useTrash {
-				err = moveToTrash(file.Path)
-			} else {
-				err = os.Remove(file.Path)
-			}
-			if err != nil {
+			if err := m.ops.Remove(file.Path, m.useTrash); err != nil {
 				m.err = err
 				return
 			}
-			if m.database != nil {
-				_ = m.database.DeleteFile(file.Path)
-			}
 		}
 	}
 
@@ -255,34 +276,30 @@ func (m *Model) performDelete() {
 		if count > 1 {
 			word = "files"
 		}
-		m.message = successStyle.Render(fmt.Sprintf("Deleted %d %s", count, word))
+		m.message = successStyle.Render(fmt.Sprintf("Deleted %d %s%s", count, word, sidecarSummary(sidecarCount)))
 	}
 	m.selected = make(map[int]bool)
 	m.nextGroup()
 }
 
 func (m *Model) performMove(destDir string) {
+	defer m.refreshSidecars()
 	group := m.groups[m.currentGroup]
 	count := len(m.selected)
 	var dryRunMsgs []string
 
+	sidecarCount := 0
 	for idx := range m.selected {
 		file := group.Files[idx]
-		destPath := filepath.Join(destDir, filepath.Base(file.Path))
+		sidecars := m.ops.Sidecars(file.Path)
+		sidecarCount += len(sidecars)
 		if m.dryRun {
-			dryRunMsgs = append(dryRunMsgs, fmt.Sprintf("[DRY-RUN] Would move: %s -> %s", file.Path, destDir))
+			dryRunMsgs = append(dryRunMsgs, fmt.Sprintf("[DRY-RUN] Would move: %s%s -> %s", file.Path, withSidecars(sidecars), destDir))
 		} else {
-			if err := os.MkdirAll(destDir, 0755); err != nil {
+			if _, err := m.ops.Move(file.Path, destDir); err != nil {
 				m.err = err
 				return
 			}
-			if err := os.Rename(file.Path, destPath); err != nil {
-				m.err = err
-				return
-			}
-			if m.database != nil {
-				_ = m.database.UpdateFilePath(file.Path, destPath)
-			}
 		}
 	}
 
@@ -293,7 +310,7 @@ func (m *Model) performMove(destDir string) {
 		if count > 1 {
 			word = "files"
 		}
-		m.message = successStyle.Render(fmt.Sprintf("Moved %d %s to %s", count, word, destDir))
+		m.message = successStyle.Render(fmt.Sprintf("Moved %d %s%s to %s", count, word, sidecarSummary(sidecarCount), destDir))
 	}
 	m.selected = make(map[int]bool)
 	m.nextGroup()
@@ -355,6 +372,28 @@ func (m *Model) performOpenFiles() {
 	m.state = stateSelectFiles
 }
 
+// refreshSidecars drops the cached directory listings after files changed.
+func (m *Model) refreshSidecars() {
+	m.sidecars = m.ops.SidecarCache()
+}
+
+// isOffline reports whether f is on a volume that is not mounted.
+func (m *Model) isOffline(f db.FileRecord) bool {
+	_, ok := m.offline[f.Volume]
+	return ok
+}
+
+// keepsOnlineCopy reports whether a file of the current group that is not
+// on an offline volume is left unselected.
+func (m *Model) keepsOnlineCopy() bool {
+	for i, f := range m.groups[m.currentGroup].Files {
+		if !m.selected[i] && !m.isOffline(f) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Model) nextGroup() {
 	m.currentGroup++
 	m.selected = make(map[int]bool)
@@ -381,21 +420,29 @@ func (m Model) View() string {
 	group := m.groups[m.currentGroup]
 
 	// Title
-	b.WriteString(titleStyle.Render(fmt.Sprintf("%s: %d files", code.Format(group.Code), len(group.Files))))
+	b.WriteString(titleStyle.Render(fmt.Sprintf("%s: %d files", m.display.Format(group.Code), len(group.Files))))
 	b.WriteString("\n")
 
 	// Files
-	for i, file := range group.Files {
-		prefix := "  "
-		style := fileStyle
-		if m.selected[i] {
-			prefix = "> "
-			style = selectedStyle
+	if group.Verified() {
+		// Files are ordered by subgroup, so indexes continue across subgroups
+		i := 0
+		for _, sg := range group.Subgroups {
+			label := "Same code, different content"
+			if sg.Identical {
+				label = "Identical content"
+			}
+			b.WriteString(helpStyle.Render("  " + label + ":"))
+			b.WriteString("\n")
+			for range sg.Files {
+				b.WriteString(m.renderFile(group, i))
+				i++
+			}
+		}
+	} else {
+		for i := range group.Files {
+			b.WriteString(m.renderFile(group, i))
 		}
-		size := formatSize(file.Size)
-		key := indexToKey(i)
-		b.WriteString(style.Render(fmt.Sprintf("%s[%s] %s (%s)", prefix, key, file.Path, size)))
-		b.WriteString("\n")
 	}
 
 	b.WriteString("\n")
@@ -408,7 +455,7 @@ func (m Model) View() string {
 		b.WriteString(helpStyle.Render("Action:"))
 		b.WriteString("\n")
 		for i, file := range group.Files {
-			if !m.selected[i] {
+			if !m.selected[i] && !m.isOffline(file) {
 				dir := filepath.Dir(file.Path)
 				key := indexToKey(i)
 				b.WriteString(helpStyle.Render(fmt.Sprintf("  [%s] Move to %s", key, dir)))
@@ -440,6 +487,49 @@ func (m Model) View() string {
 	return b.String()
 }
 
+// renderFile renders the line for the i-th file of a group.
+func (m Model) renderFile(group db.DuplicateGroup, i int) string {
+	file := group.Files[i]
+	prefix := "  "
+	style := fileStyle
+	if m.selected[i] {
+		prefix = "> "
+		style = selectedStyle
+	}
+	size := formatSize(file.Size)
+	key := indexToKey(i)
+	found, _ := m.sidecars.Of(file.Path)
+	sidecars := withSidecars(found)
+	if name, ok := m.offline[file.Volume]; ok {
+		sidecars += fmt.Sprintf(" [offline volume %s]", name)
+	}
+	return style.Render(fmt.Sprintf("%s[%s] %s (%s)%s", prefix, key, file.Path, size, sidecars)) + "\n"
+}
+
+// withSidecars lists the names of sidecars after a file, or returns "".
+func withSidecars(sidecars []string) string {
+	if len(sidecars) == 0 {
+		return ""
+	}
+	names := make([]string, len(sidecars))
+	for i, sc := range sidecars {
+		names[i] = filepath.Base(sc)
+	}
+	return fmt.Sprintf(" [with %s]", strings.Join(names, ", "))
+}
+
+// sidecarSummary counts the sidecars handled with the selected files.
+func sidecarSummary(n int) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return " and 1 sidecar"
+	default:
+		return fmt.Sprintf(" and %d sidecars", n)
+	}
+}
+
 // indexToKey converts a 0-based index to a key string for display
 // 0-8 -> "1"-"9", 9 -> "0", 10-35 -> "a"-"z"
 func indexToKey(idx int) string {
@@ -466,28 +556,6 @@ func formatSize(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-func moveToTrash(path string) error {
-	var trashDir string
-	switch runtime.GOOS {
-	case "darwin":
-		home, _ := os.UserHomeDir()
-		trashDir = filepath.Join(home, ".Trash")
-	case "linux":
-		home, _ := os.UserHomeDir()
-		trashDir = filepath.Join(home, ".local", "share", "Trash", "files")
-	default:
-		// Fallback: just delete
-		return os.Remove(path)
-	}
-
-	if err := os.MkdirAll(trashDir, 0755); err != nil {
-		return err
-	}
-
-	destPath := filepath.Join(trashDir, filepath.Base(path))
-	return os.Rename(path, destPath)
-}
-
 func revealInFinder(path string) error {
 	switch runtime.GOOS {
 	case "darwin":
@@ -513,13 +581,20 @@ func openFile(path string) error {
 }
 
 // Run starts the TUI.
-func Run(groups []db.DuplicateGroup, database *db.DB, dryRun, useTrash bool) error {
+func Run(groups []db.DuplicateGroup, database *db.DB, dryRun, useTrash bool, sidecars *sidecar.Rules, display *code.Display) error {
 	if len(groups) == 0 {
 		fmt.Println("No duplicates found")
 		return nil
 	}
 
-	p := tea.NewProgram(NewModel(groups, database, dryRun, useTrash))
-	_, err := p.Run()
+	offline, err := volume.Offline(database)
+	if err != nil {
+		return fmt.Errorf("failed to read volumes: %w", err)
+	}
+	m := NewModel(groups, database, dryRun, useTrash, sidecars, display)
+	m.offline = offline
+
+	p := tea.NewProgram(m)
+	_, err = p.Run()
 	return err
 }
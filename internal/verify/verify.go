@@ -0,0 +1,228 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+// partialSize is the number of leading bytes hashed for the partial hash.
+const partialSize = 64 * 1024
+
+// HashStore persists computed hashes so later runs can reuse them.
+type HashStore interface {
+	SetHashes(path, partialHash, hash string) error
+}
+
+// Groups splits each duplicate group into content subgroups.
+// Files are compared by size first, then by a hash of their first bytes,
+// and only files whose first bytes match are hashed in full, so most files
+// are never read in full.
+// Hashes already stored in the records are reused when the file on disk
+// still matches the indexed size and mtime; new hashes are saved to store.
+func Groups(groups []db.DuplicateGroup, store HashStore) ([]db.DuplicateGroup, error) {
+	result := make([]db.DuplicateGroup, 0, len(groups))
+	for _, group := range groups {
+		verified, err := verifyGroup(group, store)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, verified)
+	}
+	return result, nil
+}
+
+func verifyGroup(group db.DuplicateGroup, store HashStore) (db.DuplicateGroup, error) {
+	var identical []db.ContentGroup
+	var different []db.FileRecord
+
+	// Files with a unique size cannot have identical content
+	for _, sameSize := range splitBy(group.Files, func(f db.FileRecord) string { return strconv.FormatInt(f.Size, 10) }) {
+		if len(sameSize) == 1 {
+			different = append(different, sameSize...)
+			continue
+		}
+
+		var candidates []db.FileRecord
+		for _, f := range sameSize {
+			h, err := ensurePartialHash(f, store)
+			if err != nil {
+				// Unreadable files cannot be verified
+				different = append(different, f)
+				continue
+			}
+			candidates = append(candidates, h)
+		}
+
+		for _, samePartial := range splitBy(candidates, func(f db.FileRecord) string { return f.PartialHash }) {
+			if len(samePartial) == 1 {
+				different = append(different, samePartial...)
+				continue
+			}
+
+			// Only files whose first bytes match are read in full
+			var full []db.FileRecord
+			for _, f := range samePartial {
+				h, err := ensureHash(f, store)
+				if err != nil {
+					different = append(different, f)
+					continue
+				}
+				full = append(full, h)
+			}
+
+			for _, sameHash := range splitBy(full, func(f db.FileRecord) string { return f.Hash }) {
+				if len(sameHash) == 1 {
+					different = append(different, sameHash...)
+					continue
+				}
+				identical = append(identical, db.ContentGroup{
+					Identical: true,
+					Hash:      sameHash[0].Hash,
+					Files:     sameHash,
+				})
+			}
+		}
+	}
+
+	subgroups := identical
+	if len(different) > 0 {
+		subgroups = append(subgroups, db.ContentGroup{Files: different})
+	}
+
+	files := make([]db.FileRecord, 0, len(group.Files))
+	for _, sg := range subgroups {
+		files = append(files, sg.Files...)
+	}
+
+	return db.DuplicateGroup{
+		Code:      group.Code,
+		Files:     files,
+		Subgroups: subgroups,
+	}, nil
+}
+
+// ensurePartialHash fills in the partial hash of a record, computing it if
+// it is missing or stale. A stale full hash is cleared. Files no larger
+// than partialSize get their full hash too, as it is the same.
+func ensurePartialHash(f db.FileRecord, store HashStore) (db.FileRecord, error) {
+	fresh, offline, err := isFresh(f)
+	if err != nil {
+		return f, err
+	}
+	if f.PartialHash != "" && (fresh || offline) {
+		return f, nil
+	}
+
+	partial, whole, err := hashPartial(f.Path)
+	if err != nil {
+		return f, err
+	}
+	f.PartialHash = partial
+	f.Hash = ""
+	if whole {
+		f.Hash = partial
+	}
+	return f, saveHashes(f, fresh, store)
+}
+
+// ensureHash fills in the full hash of a record whose partial hash is
+// current, reading the whole file if it is missing.
+func ensureHash(f db.FileRecord, store HashStore) (db.FileRecord, error) {
+	if f.Hash != "" {
+		return f, nil
+	}
+	fresh, _, err := isFresh(f)
+	if err != nil {
+		return f, err
+	}
+	if f.Hash, err = hashFull(f.Path); err != nil {
+		return f, err
+	}
+	return f, saveHashes(f, fresh, store)
+}
+
+// isFresh reports whether the file on disk still matches the indexed size
+// and mtime, so hashes stored in the record can be trusted, or whether it
+// is offline: on a disconnected volume, with cached hashes used as is.
+func isFresh(f db.FileRecord) (fresh, offline bool, err error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) && f.Volume != "" && f.PartialHash != "" {
+			return false, true, nil
+		}
+		return false, false, err
+	}
+	return info.Size() == f.Size && info.ModTime().Equal(f.Mtime), false, nil
+}
+
+// saveHashes caches the hashes of f when they belong to the indexed
+// version of the file.
+func saveHashes(f db.FileRecord, fresh bool, store HashStore) error {
+	if !fresh || store == nil {
+		return nil
+	}
+	return store.SetHashes(f.Path, f.PartialHash, f.Hash)
+}
+
+// hashPartial returns the SHA-256 of the first partialSize bytes of a file,
+// and whether that is the whole file.
+func hashPartial(path string) (string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer func() { _ = file.Close() }()
+
+	hasher := sha256.New()
+	n, err := io.CopyN(hasher, file, partialSize)
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	if n == partialSize {
+		// A file of exactly partialSize bytes has nothing more to read
+		if _, err := file.Read(make([]byte, 1)); err == io.EOF {
+			return hex.EncodeToString(hasher.Sum(nil)), true, nil
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), false, nil
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true, nil
+}
+
+// hashFull returns the SHA-256 of a whole file.
+func hashFull(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// splitBy partitions files by key, keeping the order of first appearance.
+func splitBy(files []db.FileRecord, key func(db.FileRecord) string) [][]db.FileRecord {
+	buckets := make(map[string][]db.FileRecord)
+	var order []string
+	for _, f := range files {
+		k := key(f)
+		if _, exists := buckets[k]; !exists {
+			order = append(order, k)
+		}
+		buckets[k] = append(buckets[k], f)
+	}
+
+	result := make([][]db.FileRecord, 0, len(order))
+	for _, k := range order {
+		result = append(result, buckets[k])
+	}
+	return result
+}
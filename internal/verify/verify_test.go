@@ -0,0 +1,178 @@
+package verify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+// recordingStore remembers the hashes saved for each path.
+type recordingStore map[string][2]string
+
+func (s recordingStore) SetHashes(path, partialHash, hash string) error {
+	s[path] = [2]string{partialHash, hash}
+	return nil
+}
+
+// writeFile creates a file and returns its record as indexed.
+func writeFile(t *testing.T, dir, name string, data []byte) db.FileRecord {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	return db.FileRecord{Path: path, Code: "ABC001", Size: info.Size(), Mtime: info.ModTime()}
+}
+
+// content returns size bytes of head followed by tail.
+func content(size int, head, tail byte) []byte {
+	data := bytes.Repeat([]byte{head}, size)
+	data[size-1] = tail
+	return data
+}
+
+// paths returns the base names of files.
+func paths(files []db.FileRecord) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Path))
+	}
+	return names
+}
+
+func TestGroupsHashesInFullOnlyOnPartialMatch(t *testing.T) {
+	dir := t.TempDir()
+	size := partialSize * 2
+	a := writeFile(t, dir, "a", content(size, 'x', '1'))
+	b := writeFile(t, dir, "b", content(size, 'x', '1'))
+	// Same first bytes as a, different end
+	c := writeFile(t, dir, "c", content(size, 'x', '2'))
+	// Different from the first byte
+	d := writeFile(t, dir, "d", content(size, 'y', '1'))
+	// A size of its own
+	e := writeFile(t, dir, "e", content(size+1, 'x', '1'))
+
+	store := recordingStore{}
+	groups, err := Groups([]db.DuplicateGroup{{Code: "ABC001", Files: []db.FileRecord{a, b, c, d, e}}}, store)
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+
+	sgs := groups[0].Subgroups
+	if len(sgs) != 2 || !sgs[0].Identical || sgs[1].Identical {
+		t.Fatalf("expected one identical and one different subgroup, got %+v", sgs)
+	}
+	if got := paths(sgs[0].Files); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected a and b to be identical, got %v", got)
+	}
+	if got := paths(sgs[1].Files); len(got) != 3 {
+		t.Errorf("expected c, d and e to differ, got %v", got)
+	}
+
+	// d only differs from the others in its first bytes: it is never
+	// read in full, and e is never read at all
+	if h := store[d.Path]; h[0] == "" || h[1] != "" {
+		t.Errorf("expected only a partial hash for d, got %q", h)
+	}
+	if _, ok := store[e.Path]; ok {
+		t.Error("expected a file of a unique size not to be hashed")
+	}
+	for _, f := range sgs[1].Files {
+		if filepath.Base(f.Path) == "d" && f.Hash != "" {
+			t.Errorf("expected d to have no full hash, got %q", f.Hash)
+		}
+	}
+	for _, f := range []db.FileRecord{a, b, c} {
+		if h := store[f.Path]; h[0] == "" || h[1] == "" {
+			t.Errorf("expected both hashes for %s, got %q", filepath.Base(f.Path), h)
+		}
+	}
+	if store[a.Path][1] == store[c.Path][1] {
+		t.Error("expected a and c to have different full hashes")
+	}
+}
+
+func TestGroupsSmallFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", []byte("same"))
+	b := writeFile(t, dir, "b", []byte("same"))
+	c := writeFile(t, dir, "c", []byte("diff"))
+
+	store := recordingStore{}
+	groups, err := Groups([]db.DuplicateGroup{{Code: "ABC001", Files: []db.FileRecord{a, b, c}}}, store)
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if sgs := groups[0].Subgroups; len(sgs) != 2 || len(sgs[0].Files) != 2 || !sgs[0].Identical {
+		t.Errorf("unexpected subgroups %+v", sgs)
+	}
+	// A file read whole by the partial hash needs no second read
+	if h := store[c.Path]; h[0] == "" || h[0] != h[1] {
+		t.Errorf("expected the partial hash to be the full hash, got %q", h)
+	}
+}
+
+func TestGroupsReusesCachedHashes(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", []byte("one"))
+	b := writeFile(t, dir, "b", []byte("two"))
+	// Cached hashes of the indexed versions claim they are identical
+	a.PartialHash, a.Hash = "p", "h"
+	b.PartialHash, b.Hash = "p", "h"
+
+	store := recordingStore{}
+	groups, err := Groups([]db.DuplicateGroup{{Code: "ABC001", Files: []db.FileRecord{a, b}}}, store)
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if sgs := groups[0].Subgroups; len(sgs) != 1 || !sgs[0].Identical {
+		t.Errorf("expected the cached hashes to be used, got %+v", sgs)
+	}
+	if len(store) != 0 {
+		t.Errorf("expected nothing to be saved, got %v", store)
+	}
+
+	// Once a file changes its hashes are computed again but not saved
+	b.Mtime = b.Mtime.Add(-time.Hour)
+	groups, err = Groups([]db.DuplicateGroup{{Code: "ABC001", Files: []db.FileRecord{a, b}}}, store)
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if sgs := groups[0].Subgroups; len(sgs) != 1 || sgs[0].Identical {
+		t.Errorf("expected the changed file to differ, got %+v", sgs)
+	}
+	if _, ok := store[b.Path]; ok {
+		t.Error("expected hashes of a changed file not to be saved")
+	}
+}
+
+func TestGroupsOfflineFiles(t *testing.T) {
+	dir := t.TempDir()
+	online := writeFile(t, dir, "a", []byte("same"))
+	store := recordingStore{}
+	online, err := ensurePartialHash(online, store)
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+
+	// A copy on a disconnected volume is compared by its cached hashes
+	offline := db.FileRecord{
+		Path: filepath.Join(dir, "missing", "a"), Size: online.Size, Mtime: online.Mtime,
+		Volume: "uuid", PartialHash: online.PartialHash, Hash: online.Hash,
+	}
+	groups, err := Groups([]db.DuplicateGroup{{Code: "ABC001", Files: []db.FileRecord{online, offline}}}, nil)
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if sgs := groups[0].Subgroups; len(sgs) != 1 || !sgs[0].Identical {
+		t.Errorf("expected the offline copy to match, got %+v", sgs)
+	}
+}
@@ -0,0 +1,133 @@
+package volume
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jiikko/fdup/internal/db"
+	"gopkg.in/yaml.v3"
+)
+
+// MarkerFile is the file at the top of a volume that names it.
+const MarkerFile = ".fdup-volume"
+
+// Volume is a named disk or share whose records are kept in the index while
+// it is disconnected.
+type Volume struct {
+	Name string `yaml:"name"`
+	UUID string `yaml:"uuid"`
+	// Path is the directory containing the marker file.
+	Path string `yaml:"-"`
+}
+
+// Create writes a marker file naming dir as a new volume.
+// It fails if dir already has a marker.
+func Create(dir, name string) (*Volume, error) {
+	if name == "" {
+		return nil, errors.New("volume name must not be empty")
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	v := &Volume{Name: name, UUID: id, Path: absDir}
+
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(absDir, MarkerFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%s is already a volume", absDir)
+		}
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return v, f.Close()
+}
+
+// Read reads the marker file in dir. It returns nil if dir has no marker.
+func Read(dir string) (*Volume, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MarkerFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var v Volume
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %w", MarkerFile, dir, err)
+	}
+	if v.Name == "" || v.UUID == "" {
+		return nil, fmt.Errorf("invalid %s in %s: name and uuid are required", MarkerFile, dir)
+	}
+	v.Path = dir
+	return &v, nil
+}
+
+// Find returns the volume containing path by looking for a marker file in
+// path and its parents. It returns nil if path is not on a volume.
+func Find(path string) (*Volume, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		v, err := Read(dir)
+		if err != nil || v != nil {
+			return v, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Online reports whether the volume with uuid is mounted at dir.
+func Online(dir, uuid string) bool {
+	v, err := Read(dir)
+	return err == nil && v != nil && v.UUID == uuid
+}
+
+// Offline returns the names of the known volumes that are not mounted,
+// by UUID.
+func Offline(database *db.DB) (map[string]string, error) {
+	volumes, err := database.Volumes()
+	if err != nil {
+		return nil, err
+	}
+	offline := make(map[string]string)
+	for _, v := range volumes {
+		if !Online(v.Path, v.UUID) {
+			offline[v.UUID] = v.Name
+		}
+	}
+	return offline, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
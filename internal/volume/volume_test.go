@@ -0,0 +1,115 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+func TestCreateAndRead(t *testing.T) {
+	dir := t.TempDir()
+	created, err := Create(dir, "backup")
+	if err != nil {
+		t.Fatalf("failed to create volume: %v", err)
+	}
+	if _, err := Create(dir, "again"); err == nil {
+		t.Error("expected an error for a directory that is already a volume")
+	}
+
+	v, err := Read(dir)
+	if err != nil {
+		t.Fatalf("failed to read volume: %v", err)
+	}
+	if v == nil || v.Name != "backup" || v.UUID != created.UUID || v.Path != dir {
+		t.Errorf("expected %+v, got %+v", created, v)
+	}
+}
+
+func TestReadMissingMarker(t *testing.T) {
+	dir := t.TempDir()
+	v, err := Read(dir)
+	if err != nil || v != nil {
+		t.Errorf("expected no volume and no error, got %+v, %v", v, err)
+	}
+	if v, err := Find(dir); err != nil || v != nil {
+		t.Errorf("expected Find to find no volume, got %+v, %v", v, err)
+	}
+}
+
+func TestReadMalformedMarker(t *testing.T) {
+	for name, content := range map[string]string{
+		"not yaml":     "name: [",
+		"missing uuid": "name: backup\n",
+		"missing name": "uuid: 1234\n",
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, MarkerFile), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write marker: %v", err)
+		}
+		if v, err := Read(dir); err == nil {
+			t.Errorf("%s: expected an error, got %+v", name, v)
+		}
+		// Find reports the marker instead of looking further up
+		if v, err := Find(dir); err == nil {
+			t.Errorf("%s: expected Find to fail, got %+v", name, v)
+		}
+	}
+}
+
+func TestFindWalksUp(t *testing.T) {
+	dir := t.TempDir()
+	created, err := Create(dir, "backup")
+	if err != nil {
+		t.Fatalf("failed to create volume: %v", err)
+	}
+	sub := filepath.Join(dir, "photos", "2024")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	v, err := Find(sub)
+	if err != nil {
+		t.Fatalf("failed to find volume: %v", err)
+	}
+	if v == nil || v.UUID != created.UUID || v.Path != dir {
+		t.Errorf("expected the volume at %s, got %+v", dir, v)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	mounted := t.TempDir()
+	online, err := Create(mounted, "online")
+	if err != nil {
+		t.Fatalf("failed to create volume: %v", err)
+	}
+	// A mount point whose marker names another volume
+	replaced := t.TempDir()
+	if _, err := Create(replaced, "other"); err != nil {
+		t.Fatalf("failed to create volume: %v", err)
+	}
+	for _, v := range []Volume{
+		{UUID: online.UUID, Name: "online", Path: mounted},
+		{UUID: "uuid-unplugged", Name: "unplugged", Path: filepath.Join(mounted, "missing")},
+		{UUID: "uuid-replaced", Name: "replaced", Path: replaced},
+	} {
+		if err := database.SaveVolume(v.UUID, v.Name, v.Path); err != nil {
+			t.Fatalf("failed to save volume: %v", err)
+		}
+	}
+
+	offline, err := Offline(database)
+	if err != nil {
+		t.Fatalf("failed to list offline volumes: %v", err)
+	}
+	if len(offline) != 2 || offline["uuid-unplugged"] != "unplugged" || offline["uuid-replaced"] != "replaced" {
+		t.Errorf("expected unplugged and replaced to be offline, got %v", offline)
+	}
+}
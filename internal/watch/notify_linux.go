@@ -0,0 +1,134 @@
+package watch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Events watched on every directory. Files are indexed when they are closed
+// after writing rather than on creation, so copies are seen complete.
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// inotifyNotifier reports changes using inotify. Each directory needs its
+// own watch, so the notifier keeps the directory of every watch descriptor.
+type inotifyNotifier struct {
+	file *os.File
+	fd   int
+
+	mu        sync.Mutex
+	dirs      map[int32]string
+	wds       map[string]int32
+	closeOnce sync.Once
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+	// A non-blocking descriptor is served by the runtime poller, so Close
+	// interrupts a pending Read
+	return &inotifyNotifier{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		dirs: make(map[int32]string),
+		wds:  make(map[string]int32),
+	}, nil
+}
+
+func (n *inotifyNotifier) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, watchMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return fmt.Errorf("%s: too many watched directories (raise fs.inotify.max_user_watches)", dir)
+		}
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dirs[int32(wd)] = dir
+	n.wds[dir] = int32(wd)
+	return nil
+}
+
+func (n *inotifyNotifier) remove(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	prefix := dir + string(filepath.Separator)
+	for path, wd := range n.wds {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			_, _ = syscall.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.wds, path)
+			delete(n.dirs, wd)
+		}
+	}
+}
+
+func (n *inotifyNotifier) read() ([]event, error) {
+	buf := make([]byte, 64*1024)
+	size, err := n.file.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var events []event
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= size; {
+		wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+		mask := binary.NativeEndian.Uint32(buf[offset+4:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+		name := strings.TrimRight(string(buf[offset+syscall.SizeofInotifyEvent:offset+syscall.SizeofInotifyEvent+nameLen]), "\x00")
+		offset += syscall.SizeofInotifyEvent + nameLen
+
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			events = append(events, event{op: opOverflow})
+			continue
+		}
+
+		dir, ok := n.dirs[wd]
+		if !ok {
+			continue
+		}
+		if mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0 {
+			// The directory itself is gone; its parent reports the removal
+			delete(n.dirs, wd)
+			if n.wds[dir] == wd {
+				delete(n.wds, dir)
+			}
+			continue
+		}
+
+		ev := event{path: filepath.Join(dir, name), isDir: mask&syscall.IN_ISDIR != 0}
+		switch {
+		case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+			ev.op = opRemove
+		case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+			ev.op = opWrite
+		case mask&syscall.IN_CREATE != 0 && ev.isDir:
+			ev.op = opWrite
+		default:
+			// Files are indexed once they are closed
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func (n *inotifyNotifier) close() error {
+	var err error
+	n.closeOnce.Do(func() { err = n.file.Close() })
+	return err
+}
@@ -0,0 +1,293 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/ignore"
+	"github.com/jiikko/fdup/internal/scanner"
+)
+
+// op is the kind of change reported by a notifier.
+type op int
+
+const (
+	// opWrite means a file was written or moved in, or a directory was created
+	// or moved in.
+	opWrite op = iota
+	// opRemove means a file or directory was deleted or moved out.
+	opRemove
+	// opOverflow means events were lost and the tree must be rescanned.
+	opOverflow
+)
+
+type event struct {
+	op    op
+	path  string
+	isDir bool
+}
+
+// notifier watches directories for changes. Each platform provides its own
+// implementation through newNotifier.
+type notifier interface {
+	add(dir string) error
+	// remove stops watching dir and every directory below it.
+	remove(dir string)
+	// read blocks until events are available.
+	read() ([]event, error)
+	close() error
+}
+
+// Watcher keeps the index in sync with changes under the scanner's roots.
+type Watcher struct {
+	scanner  *scanner.Scanner
+	database *db.DB
+	notifier notifier
+
+	// OnIndex is called after a file is added to or updated in the index.
+	OnIndex func(rec db.FileRecord)
+	// OnRemove is called after a file is removed from the index.
+	OnRemove func(path string)
+	// OnDuplicate is called when a file newly indexed under a code makes it
+	// part of the duplicate group of that code, once per group.
+	OnDuplicate func(group db.DuplicateGroup, rec db.FileRecord)
+	// OnError is called for errors that do not stop the watcher.
+	OnError func(err error)
+}
+
+// New creates a watcher for the roots of s. Files are matched and ignored
+// using the rules of s.
+func New(s *scanner.Scanner, database *db.DB) (*Watcher, error) {
+	n, err := newNotifier()
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		scanner:  s,
+		database: database,
+		notifier: n,
+	}, nil
+}
+
+// Start watches the directory trees and then brings the index up to date
+// with a scan. Changes made during the scan are applied by Run.
+func (w *Watcher) Start() (*db.SyncResult, error) {
+	for _, root := range w.scanner.Roots() {
+		if err := w.addTree(root.Path, false); err != nil {
+			return nil, err
+		}
+	}
+	return w.rescan()
+}
+
+// Run applies changes to the index until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = w.notifier.close() })
+	defer stop()
+
+	for {
+		events, err := w.notifier.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, ev := range events {
+			w.handle(ev)
+		}
+	}
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	return w.notifier.close()
+}
+
+func (w *Watcher) handle(ev event) {
+	// Changed ignore rules can bring in or drop whole trees
+	if ev.op != opOverflow && !ev.isDir && filepath.Base(ev.path) == ignore.FileName {
+		ev.op = opOverflow
+	}
+
+	switch ev.op {
+	case opOverflow:
+		if _, err := w.Start(); err != nil {
+			w.reportError(err)
+		}
+	case opRemove:
+		if ev.isDir {
+			w.notifier.remove(ev.path)
+		}
+		// Removing from a directory item changes the item
+		if item, ok := w.scanner.Item(filepath.Dir(ev.path)); ok {
+			w.indexFile(item)
+			return
+		}
+		if ev.isDir {
+			w.removeTree(ev.path)
+		} else {
+			w.removeFile(ev.path)
+		}
+	case opWrite:
+		if w.scanner.Ignored(ev.path, ev.isDir) {
+			return
+		}
+		if ev.isDir {
+			// Files may have been added before the watch was in place
+			if err := w.addTree(ev.path, true); err != nil {
+				w.reportError(err)
+			}
+		} else {
+			w.indexFile(ev.path)
+		}
+	}
+}
+
+// addTree watches dir and every directory below it that is not ignored.
+// With index set, files found on the way are indexed. Directory items are
+// indexed once rather than for each file in them.
+func (w *Watcher) addTree(dir string, index bool) error {
+	var item string // directory item being walked
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may be gone already; its removal is reported separately
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if path != dir && w.scanner.Ignored(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		inItem := item != "" && (path == item || strings.HasPrefix(path, item+string(filepath.Separator)))
+		if d.IsDir() {
+			if err := w.notifier.add(path); err != nil {
+				return err
+			}
+			if index && !inItem {
+				if it, ok := w.scanner.Item(path); ok {
+					item = it
+					w.indexFile(it)
+				}
+			}
+			return nil
+		}
+		if index && !inItem {
+			w.indexFile(path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) indexFile(path string) {
+	rec, ok, err := w.scanner.Record(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.reportError(err)
+		}
+		return
+	}
+	if !ok {
+		return
+	}
+
+	// A file in a directory item is recorded as the item
+	previous, err := w.database.GetFile(rec.Path)
+	if err != nil {
+		w.reportError(err)
+		return
+	}
+	// Codes the file was already indexed under
+	known := make(map[string]bool)
+	if previous != nil {
+		for _, c := range previous.AllCodes() {
+			known[c] = true
+		}
+	}
+	var added []string
+	for _, c := range rec.AllCodes() {
+		if !known[c] {
+			added = append(added, c)
+		}
+	}
+	if previous != nil && previous.Code == rec.Code && len(added) == 0 && len(known) == len(rec.AllCodes()) &&
+		previous.Size == rec.Size && previous.Mtime.Equal(rec.Mtime) {
+		return
+	}
+	if err := w.database.InsertFile(rec); err != nil {
+		w.reportError(err)
+		return
+	}
+	if w.OnIndex != nil {
+		w.OnIndex(rec)
+	}
+
+	// A rewritten file was already reported with the groups it was in
+	for _, c := range added {
+		group, err := w.database.DuplicatesOf(c)
+		if err != nil {
+			w.reportError(err)
+			return
+		}
+		if group != nil && w.OnDuplicate != nil {
+			w.OnDuplicate(*group, rec)
+		}
+	}
+}
+
+func (w *Watcher) removeFile(path string) {
+	rec, err := w.database.GetFile(path)
+	if err != nil {
+		w.reportError(err)
+		return
+	}
+	if rec == nil {
+		return
+	}
+	if err := w.database.DeleteFile(path); err != nil {
+		w.reportError(err)
+		return
+	}
+	if w.OnRemove != nil {
+		w.OnRemove(path)
+	}
+}
+
+func (w *Watcher) removeTree(dir string) {
+	n, err := w.database.DeleteFilesUnder(dir)
+	if err != nil {
+		w.reportError(err)
+		return
+	}
+	if n > 0 && w.OnRemove != nil {
+		w.OnRemove(dir)
+	}
+}
+
+// rescan scans every root and applies the differences to the index.
+// Records under other roots, such as those of offline volumes, are kept.
+func (w *Watcher) rescan() (*db.SyncResult, error) {
+	records, _, err := w.scanner.Scan(nil)
+	if err != nil {
+		return nil, err
+	}
+	return w.database.Sync(records, w.scanner.RootPaths())
+}
+
+func (w *Watcher) reportError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/code"
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/scanner"
+)
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b", "ignored"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	existing := filepath.Join(root, "a", "DSC00001.jpg")
+	if err := os.WriteFile(existing, []byte("a"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer func() { _ = database.Close() }()
+
+	s, err := scanner.New([]code.Pattern{{Regex: `([A-Z]{2,5})(\d{3,5})`}}, []string{"ignored/"}, []scanner.Root{{Path: root}})
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	w, err := New(s, database)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	duplicates := make(chan string, 10)
+	removed := make(chan string, 10)
+	w.OnDuplicate = func(group db.DuplicateGroup, rec db.FileRecord) { duplicates <- rec.Path }
+	w.OnRemove = func(path string) { removed <- path }
+	w.OnError = func(err error) { t.Errorf("unexpected error: %v", err) }
+
+	result, err := w.Start()
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if result.Added != 1 {
+		t.Errorf("expected initial scan to add 1 file, got %d", result.Added)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	wait := func(ch chan string, want string) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+
+	// Ignored directories are not watched
+	if err := os.WriteFile(filepath.Join(root, "ignored", "DSC00001.jpg"), []byte("a"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	copied := filepath.Join(root, "b", "DSC00001.jpg")
+	if err := os.WriteFile(copied, []byte("a"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	wait(duplicates, copied)
+
+	renamed := filepath.Join(root, "b", "DSC00002.jpg")
+	if err := os.Rename(copied, renamed); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	wait(removed, copied)
+
+	if err := os.Remove(existing); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	wait(removed, existing)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+
+	files, err := database.ListFiles()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != renamed {
+		t.Errorf("expected only %s to be indexed, got %+v", renamed, files)
+	}
+}
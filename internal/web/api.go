@@ -0,0 +1,322 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/export"
+	"github.com/jiikko/fdup/internal/filter"
+	"github.com/jiikko/fdup/internal/verify"
+)
+
+// maxPerPage limits the page size of API listings.
+const maxPerPage = 500
+
+// groupsResponse is the body of GET /api/v1/groups.
+type groupsResponse struct {
+	Groups      []export.Group `json:"groups"`
+	Page        int            `json:"page"`
+	PerPage     int            `json:"per_page"`
+	TotalGroups int            `json:"total_groups"`
+	TotalPages  int            `json:"total_pages"`
+}
+
+// apiFile is an indexed file in API responses.
+type apiFile struct {
+	Code string `json:"code"`
+	// Codes lists every code of a file with several codes.
+	Codes []string `json:"codes,omitempty"`
+	export.File
+}
+
+// filesResponse is the body of GET /api/v1/files.
+type filesResponse struct {
+	Files      []apiFile `json:"files"`
+	Page       int       `json:"page"`
+	PerPage    int       `json:"per_page"`
+	TotalFiles int       `json:"total_files"`
+	TotalPages int       `json:"total_pages"`
+}
+
+// statsResponse is the body of GET /api/v1/stats.
+type statsResponse struct {
+	Files          int   `json:"files"`
+	TotalSize      int64 `json:"total_size"`
+	Groups         int   `json:"groups"`
+	DuplicateFiles int   `json:"duplicate_files"`
+	// ReclaimableSize is the size of every file of each group but its
+	// largest one.
+	ReclaimableSize int64 `json:"reclaimable_size"`
+}
+
+// scanResponse is the body of POST /api/v1/scan.
+type scanResponse struct {
+	Status    string `json:"status"`
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+}
+
+// handleAPIGroups lists duplicate groups a page at a time. The parameters
+// code (a code prefix), dir, min_size, max_size and ext narrow the groups;
+// page and per_page select the page.
+func (s *Server) handleAPIGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	page, perPage, err := pageParams(q)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileFilter, err := s.apiFilter(q)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pageGroups, total, err := s.database.FindDuplicatesPage(db.GroupQuery{
+		Filter:     fileFilter,
+		CodePrefix: s.normalizeCode(q.Get("code")),
+		Under:      q.Get("dir"),
+		Limit:      perPage,
+		Offset:     pageOffset(page, perPage),
+	})
+	if err != nil {
+		jsonError(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+	if s.verify {
+		if pageGroups, err = verify.Groups(pageGroups, s.database); err != nil {
+			jsonError(w, "Failed to verify duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, groupsResponse{
+		Groups:      export.Convert(pageGroups, s.display),
+		Page:        page,
+		PerPage:     perPage,
+		TotalGroups: total,
+		TotalPages:  (total + perPage - 1) / perPage,
+	})
+}
+
+// handleAPIGroup returns the duplicate group of one code.
+func (s *Server) handleAPIGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fileFilter, err := s.apiFilter(r.URL.Query())
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.normalizeCode(r.PathValue("code"))
+	result, _, err := s.database.FindDuplicatesPage(db.GroupQuery{Filter: fileFilter, Code: c})
+	if err != nil {
+		jsonError(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+	if len(result) == 0 {
+		jsonError(w, fmt.Sprintf("No duplicate group for %s", c), http.StatusNotFound)
+		return
+	}
+	if s.verify {
+		if result, err = verify.Groups(result, s.database); err != nil {
+			jsonError(w, "Failed to verify duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, export.Convert(result, s.display)[0])
+}
+
+// handleAPIFiles lists indexed files a page at a time, with the same
+// parameters as handleAPIGroups. code matches any code of a file.
+func (s *Server) handleAPIFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	page, perPage, err := pageParams(q)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileFilter, err := s.apiFilter(q)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files, total, err := s.database.ListFilesPage(db.FileQuery{
+		Filter:     fileFilter,
+		CodePrefix: s.normalizeCode(q.Get("code")),
+		Under:      q.Get("dir"),
+		Limit:      perPage,
+		Offset:     pageOffset(page, perPage),
+	})
+	if err != nil {
+		jsonError(w, "Failed to list files", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]apiFile, 0, len(files))
+	for _, f := range files {
+		result = append(result, apiFile{Code: f.Code, Codes: f.Codes, File: export.ConvertFile(f, f.Code)})
+	}
+	writeJSON(w, http.StatusOK, filesResponse{
+		Files:      result,
+		Page:       page,
+		PerPage:    perPage,
+		TotalFiles: total,
+		TotalPages: (total + perPage - 1) / perPage,
+	})
+}
+
+// handleAPIStats summarizes the index and its duplicates.
+func (s *Server) handleAPIStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := s.database.Stats(s.filter)
+	if err != nil {
+		jsonError(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, statsResponse{
+		Files:           stats.Files,
+		TotalSize:       stats.TotalSize,
+		Groups:          stats.Groups,
+		DuplicateFiles:  stats.DuplicateFiles,
+		ReclaimableSize: stats.ReclaimableSize,
+	})
+}
+
+// handleAPIScan rescans the roots and reports the changes to the index.
+// Only one scan runs at a time.
+func (s *Server) handleAPIScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.scan == nil {
+		jsonError(w, "Scanning is not available", http.StatusNotImplemented)
+		return
+	}
+	if !s.scanning.TryLock() {
+		jsonError(w, "A scan is already running", http.StatusConflict)
+		return
+	}
+	defer s.scanning.Unlock()
+
+	result, err := s.scan()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("[SCAN] Added %d, updated %d, removed %d records\n", result.Added, result.Updated, result.Removed)
+	writeJSON(w, http.StatusOK, scanResponse{
+		Status:    "ok",
+		Added:     result.Added,
+		Updated:   result.Updated,
+		Removed:   result.Removed,
+		Unchanged: result.Unchanged,
+	})
+}
+
+// apiFilter combines the server's file filter with the min_size, max_size
+// and ext parameters, which replace the corresponding parts of it.
+func (s *Server) apiFilter(q url.Values) (db.FileFilter, error) {
+	result := s.filter
+	if v := q.Get("min_size"); v != "" {
+		size, err := filter.ParseSize(v)
+		if err != nil {
+			return result, fmt.Errorf("min_size: %w", err)
+		}
+		result.MinSize = int64(size)
+	}
+	if v := q.Get("max_size"); v != "" {
+		size, err := filter.ParseSize(v)
+		if err != nil {
+			return result, fmt.Errorf("max_size: %w", err)
+		}
+		result.MaxSize = int64(size)
+	}
+	if exts := q["ext"]; len(exts) > 0 {
+		result.Extensions = nil
+		for _, v := range exts {
+			for _, ext := range strings.Split(v, ",") {
+				if ext = strings.TrimSpace(ext); ext != "" {
+					result.Extensions = append(result.Extensions, ext)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// normalizeCode turns a code given to the API into an indexed code.
+func (s *Server) normalizeCode(c string) string {
+	if c == "" || s.normalize == nil {
+		return c
+	}
+	return s.normalize(c)
+}
+
+// pageParams reads the page and per_page parameters.
+func pageParams(q url.Values) (int, int, error) {
+	page, size := 1, perPage
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive number")
+		}
+		page = n
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		size = n
+	}
+	return page, size, nil
+}
+
+// pageOffset returns the number of items before a page. Pages too far
+// out to count are placed past the end of any listing.
+func pageOffset(page, perPage int) int {
+	// Compare pages rather than items, as (page-1)*perPage can overflow
+	if page-1 > math.MaxInt/perPage {
+		return math.MaxInt
+	}
+	return (page - 1) * perPage
+}
+
+// inDir reports whether path is in dir or below it.
+func inDir(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return strings.HasPrefix(path, dir+string(filepath.Separator)) || (dir == string(filepath.Separator) && strings.HasPrefix(path, dir))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/code"
+	"github.com/jiikko/fdup/internal/db"
+)
+
+// setupAPIServer returns a server over three duplicate groups:
+// ABC001 and ABC002 with two files each, and XYZ001 with a large and a
+// small file.
+func setupAPIServer(t *testing.T) *Server {
+	t.Helper()
+	database, _ := setupTestDB(t)
+	t.Cleanup(func() { database.Close() })
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []db.FileRecord{
+		{Path: "/photos/a/ABC001.jpg", Code: "ABC001", Size: 100, Mtime: mtime},
+		{Path: "/photos/b/ABC001.jpg", Code: "ABC001", Size: 100, Mtime: mtime},
+		{Path: "/photos/a/ABC002.jpg", Code: "ABC002", Size: 200, Mtime: mtime},
+		{Path: "/backup/ABC002.jpg", Code: "ABC002", Size: 200, Mtime: mtime},
+		{Path: "/videos/a/XYZ001.mp4", Code: "XYZ001", Size: 5000, Mtime: mtime},
+		{Path: "/videos/b/XYZ001.mp4", Code: "XYZ001", Size: 10, Mtime: mtime},
+		{Path: "/videos/c/XYZ999.mp4", Code: "XYZ999", Size: 10, Mtime: mtime},
+	}
+	for _, rec := range records {
+		if err := database.InsertFile(rec); err != nil {
+			t.Fatalf("failed to insert file: %v", err)
+		}
+	}
+	s := newServer(database, false)
+	s.normalize = strings.ToUpper
+	return s
+}
+
+// getJSON requests path from the server and decodes the response into v.
+func getJSON(t *testing.T, s *Server, method, path string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, req)
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestAPIGroups(t *testing.T) {
+	s := setupAPIServer(t)
+
+	tests := []struct {
+		query string
+		codes []string
+		total int
+	}{
+		{"", []string{"ABC001", "ABC002", "XYZ001"}, 3},
+		{"?per_page=2&page=2", []string{"XYZ001"}, 3},
+		{"?page=5", nil, 3},
+		{"?per_page=2&page=4611686018427387905", nil, 3},
+		{"?code=abc", []string{"ABC001", "ABC002"}, 2},
+		{"?dir=/backup", []string{"ABC002"}, 1},
+		{"?dir=/photos/a", []string{"ABC001", "ABC002"}, 2},
+		{"?min_size=150", []string{"ABC002"}, 1},
+		{"?ext=mp4", []string{"XYZ001"}, 1},
+		{"?max_size=1KB&ext=jpg,mp4", []string{"ABC001", "ABC002"}, 2},
+	}
+	for _, tt := range tests {
+		var resp groupsResponse
+		if code := getJSON(t, s, http.MethodGet, "/api/v1/groups"+tt.query, &resp); code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.query, code)
+		}
+		var codes []string
+		for _, g := range resp.Groups {
+			codes = append(codes, g.Code)
+		}
+		if fmt.Sprint(codes) != fmt.Sprint(tt.codes) || resp.TotalGroups != tt.total {
+			t.Errorf("%s: expected %v of %d, got %v of %d", tt.query, tt.codes, tt.total, codes, resp.TotalGroups)
+		}
+	}
+
+	for _, query := range []string{"?page=0", "?per_page=1000", "?min_size=big"} {
+		if code := getJSON(t, s, http.MethodGet, "/api/v1/groups"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+	if code := getJSON(t, s, http.MethodPost, "/api/v1/groups", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", code)
+	}
+}
+
+func TestAPIGroup(t *testing.T) {
+	s := setupAPIServer(t)
+
+	var group struct {
+		Code          string `json:"code"`
+		FormattedCode string `json:"formatted_code"`
+		Files         []struct {
+			Path string `json:"path"`
+			Size int64  `json:"size"`
+		} `json:"files"`
+	}
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/groups/xyz001", &group); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if group.Code != "XYZ001" || group.FormattedCode != "XYZ-001" || len(group.Files) != 2 || group.Files[0].Size != 5000 {
+		t.Errorf("unexpected group %+v", group)
+	}
+
+	// Codes are formatted with the display of the server
+	display, err := code.NewDisplay("{1}_{2:05}")
+	if err != nil {
+		t.Fatalf("failed to parse display: %v", err)
+	}
+	s.display = display
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/groups/XYZ001", &group); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if group.FormattedCode != "XYZ_00001" {
+		t.Errorf("expected the display format, got %q", group.FormattedCode)
+	}
+
+	// A single file is not a group
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/groups/XYZ999", nil); code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", code)
+	}
+}
+
+func TestAPIFiles(t *testing.T) {
+	s := setupAPIServer(t)
+
+	var resp filesResponse
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/files?code=xyz&per_page=2", &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.TotalFiles != 3 || resp.TotalPages != 2 || len(resp.Files) != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	// Pages far past the end are empty
+	var last filesResponse
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/files?per_page=2&page=4611686018427387905", &last); code != http.StatusOK {
+		t.Fatalf("expected status 200 for a huge page, got %d", code)
+	}
+	if len(last.Files) != 0 || last.TotalFiles != 7 {
+		t.Errorf("expected an empty page, got %+v", last)
+	}
+	if f := resp.Files[0]; f.Code != "XYZ001" || f.Path != "/videos/a/XYZ001.mp4" || f.Directory != "/videos/a" {
+		t.Errorf("unexpected file %+v", f)
+	}
+}
+
+func TestAPIStats(t *testing.T) {
+	s := setupAPIServer(t)
+
+	var stats statsResponse
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/stats", &stats); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	want := statsResponse{Files: 7, TotalSize: 5620, Groups: 3, DuplicateFiles: 6, ReclaimableSize: 310}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestAPIScan(t *testing.T) {
+	s := setupAPIServer(t)
+
+	if code := getJSON(t, s, http.MethodPost, "/api/v1/scan", nil); code != http.StatusNotImplemented {
+		t.Errorf("expected status 501 without a scanner, got %d", code)
+	}
+
+	s.scan = func() (*db.SyncResult, error) {
+		return &db.SyncResult{Added: 2, Unchanged: 7}, nil
+	}
+	var resp scanResponse
+	if code := getJSON(t, s, http.MethodPost, "/api/v1/scan", &resp); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if resp.Added != 2 || resp.Unchanged != 7 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if code := getJSON(t, s, http.MethodGet, "/api/v1/scan", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", code)
+	}
+
+	// A scan already running is not started again
+	s.scanning.Lock()
+	defer s.scanning.Unlock()
+	if code := getJSON(t, s, http.MethodPost, "/api/v1/scan", nil); code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", code)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// tokenHeader carries the session token of requests made by the page.
+const tokenHeader = "X-Fdup-Token"
+
+// newToken returns a random session token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// protect requires the session token on every request and rejects
+// requests from other origins that change anything.
+func (s *Server) protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			deny(w, r, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		if s.token != "" && !s.authorized(r) {
+			deny(w, r, "Missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether r carries the session token, in the
+// X-Fdup-Token header, as a bearer token, or in the token parameter.
+func (s *Server) authorized(r *http.Request) bool {
+	token := r.Header.Get(tokenHeader)
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// sameOrigin reports whether r comes from a page of this server, or from
+// something other than a browser, such as a script.
+func sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	// Browsers that leave out Origin still tell where a request comes from
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+		return true
+	}
+	return false
+}
+
+// deny rejects r, with a JSON error for the API.
+func deny(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		jsonError(w, message, code)
+		return
+	}
+	http.Error(w, message, code)
+}
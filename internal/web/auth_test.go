@@ -0,0 +1,90 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	database, _ := setupTestDB(t)
+	defer database.Close()
+
+	s := newServer(database, false)
+	s.token = "secret"
+	handler := s.protect(s.routes())
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		want    int
+	}{
+		{"no token", http.MethodGet, "/api/v1/stats", nil, http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/v1/stats", map[string]string{tokenHeader: "guess"}, http.StatusUnauthorized},
+		{"header", http.MethodGet, "/api/v1/stats", map[string]string{tokenHeader: "secret"}, http.StatusOK},
+		{"bearer", http.MethodGet, "/api/v1/stats", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"parameter", http.MethodGet, "/?token=secret", nil, http.StatusOK},
+		{"index without token", http.MethodGet, "/", nil, http.StatusUnauthorized},
+		{"script POST", http.MethodPost, "/api/v1/scan", map[string]string{tokenHeader: "secret"}, http.StatusNotImplemented},
+		{"same-origin POST", http.MethodPost, "/api/v1/scan",
+			map[string]string{tokenHeader: "secret", "Origin": "http://example.com"}, http.StatusNotImplemented},
+		{"cross-origin POST", http.MethodPost, "/api/delete",
+			map[string]string{tokenHeader: "secret", "Origin": "http://evil.example"}, http.StatusForbidden},
+		{"null origin POST", http.MethodPost, "/api/delete",
+			map[string]string{tokenHeader: "secret", "Origin": "null"}, http.StatusForbidden},
+		{"cross-site POST without Origin", http.MethodPost, "/api/delete?token=secret",
+			map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
+
+func TestIndexCarriesToken(t *testing.T) {
+	if got := renderPagination(1, 3, "a&b"); !strings.Contains(got, `href="?page=2&amp;token=a%26b"`) {
+		t.Errorf("expected links with the token, got %s", got)
+	}
+	if got := renderPagination(1, 3, ""); !strings.Contains(got, `href="?page=2"`) {
+		t.Errorf("expected links without a token, got %s", got)
+	}
+}
+
+func TestListen(t *testing.T) {
+	// A host alone searches for a free port from 8080
+	listener, err := listen("")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+	if !addr.IP.IsLoopback() || addr.Port < 8080 {
+		t.Errorf("expected a loopback address from port 8080, got %s", addr)
+	}
+
+	listener, err = listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	if addr := listener.Addr().(*net.TCPAddr); !addr.IP.IsLoopback() {
+		t.Errorf("expected a loopback address, got %s", addr)
+	}
+
+	// A port in use is an error rather than another port
+	if l, err := listen(listener.Addr().String()); err == nil {
+		l.Close()
+		t.Error("expected an error for a port in use")
+	}
+}
@@ -0,0 +1,350 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/resolve"
+	"github.com/jiikko/fdup/internal/verify"
+	"github.com/jiikko/fdup/internal/volume"
+)
+
+// Batch actions.
+const (
+	batchTrash = "trash"
+	batchMove  = "move"
+	batchKeep  = "keep"
+)
+
+// Statuses of a file in a batch.
+const (
+	statusPlanned = "planned"
+	statusOK      = "ok"
+	statusSkipped = "skipped"
+	statusError   = "error"
+)
+
+// batchRequest is the body of POST /api/batch.
+type batchRequest struct {
+	// Action is trash, move or keep.
+	Action string `json:"action"`
+	// Paths are the selected files.
+	Paths []string `json:"paths"`
+	// All selects every file in the view except those in Exclude, in
+	// place of Paths.
+	All     bool     `json:"all"`
+	Exclude []string `json:"exclude"`
+	// Dest is the folder files are moved into.
+	Dest string `json:"dest"`
+	// Rules choose the file kept in each group, as for resolve --keep
+	// followed by --tie-break rules.
+	Rules []string `json:"rules"`
+	// Preview reports what would be done without changing anything.
+	Preview bool `json:"preview"`
+}
+
+// batchResult is the outcome for one file of a batch.
+type batchResult struct {
+	Path string `json:"path"`
+	// Action is trash or move, or keep for a file kept by the keep action.
+	Action string `json:"action"`
+	// Dest is where a moved file ends up.
+	Dest     string   `json:"dest,omitempty"`
+	Sidecars []string `json:"sidecars,omitempty"`
+	// Status is planned in a preview, otherwise ok. Files left alone are
+	// skipped, and files that cannot be handled are error.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchResponse is the body of POST /api/batch.
+type batchResponse struct {
+	Status  string        `json:"status"`
+	Preview bool          `json:"preview"`
+	Results []batchResult `json:"results"`
+	// Done counts the files planned or handled, kept files included.
+	Done    int `json:"done"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// handleBatch applies an action to the selected files at once. With
+// preview it only reports what each file would go through.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var resolver *resolve.Resolver
+	switch req.Action {
+	case batchTrash:
+	case batchMove:
+		if !s.allowDir(w, req.Dest) {
+			return
+		}
+	case batchKeep:
+		var rules []resolve.Rule
+		for _, name := range req.Rules {
+			rule, err := resolve.ParseRule(name)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rules = append(rules, rule)
+		}
+		var err error
+		if resolver, err = resolve.New(rules, nil); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		jsonError(w, fmt.Sprintf("unknown action %q (use trash, move or keep)", req.Action), http.StatusBadRequest)
+		return
+	}
+
+	groups, err := s.database.FindDuplicatesMatching(s.filter)
+	if err != nil {
+		jsonError(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+	paths := selection(req, groups)
+	if len(paths) == 0 {
+		jsonError(w, "No files selected", http.StatusBadRequest)
+		return
+	}
+
+	var results []batchResult
+	switch req.Action {
+	case batchTrash:
+		results = s.batchTrash(paths, req.Preview)
+	case batchMove:
+		results = s.batchMove(paths, req.Dest, req.Preview)
+	case batchKeep:
+		if results, err = s.batchKeep(paths, groups, resolver, req.Preview); err != nil {
+			jsonError(w, "Failed to verify duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := batchResponse{Status: "ok", Preview: req.Preview, Results: results}
+	for _, res := range results {
+		switch res.Status {
+		case statusSkipped:
+			resp.Skipped++
+		case statusError:
+			resp.Failed++
+		default:
+			resp.Done++
+		}
+	}
+	// One line per batch, the results list every file
+	if !req.Preview {
+		fmt.Printf("[BATCH] %s: %d done, %d skipped, %d failed\n", req.Action, resp.Done, resp.Skipped, resp.Failed)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// selection returns the selected paths without duplicates, in the order
+// given or, for the whole view, in the order of the groups.
+func selection(req batchRequest, groups []db.DuplicateGroup) []string {
+	seen := make(map[string]bool)
+	if req.All {
+		for _, p := range req.Exclude {
+			seen[p] = true
+		}
+	}
+	var paths []string
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	if req.All {
+		for _, g := range groups {
+			for _, f := range g.Files {
+				add(f.Path)
+			}
+		}
+	} else {
+		for _, p := range req.Paths {
+			add(p)
+		}
+	}
+	return paths
+}
+
+// batchTrash moves each file with its sidecars to the trash.
+func (s *Server) batchTrash(paths []string, preview bool) []batchResult {
+	var results []batchResult
+	for _, p := range paths {
+		results = append(results, s.trash(batchResult{Path: p, Action: batchTrash}, preview))
+	}
+	return results
+}
+
+// trash moves the file of res to the trash unless preview is set, and
+// returns res with its outcome.
+func (s *Server) trash(res batchResult, preview bool) batchResult {
+	if _, err := s.checkPath(res.Path); err != nil {
+		return failed(res, err)
+	}
+	res.Sidecars = s.ops.Sidecars(res.Path)
+	if preview {
+		res.Status = statusPlanned
+		return res
+	}
+	if err := s.ops.Remove(res.Path, true); err != nil {
+		return failed(res, err)
+	}
+	res.Status = statusOK
+	return res
+}
+
+// batchMove moves each file with its sidecars into dest. Files that would
+// replace another file, including one moved earlier in the batch, are not
+// moved.
+func (s *Server) batchMove(paths []string, dest string, preview bool) []batchResult {
+	var results []batchResult
+	planned := make(map[string]bool)
+	for _, p := range paths {
+		res := batchResult{Path: p, Action: batchMove}
+		if _, err := s.checkPath(p); err != nil {
+			results = append(results, failed(res, err))
+			continue
+		}
+		if filepath.Dir(p) == dest {
+			res.Status, res.Error = statusSkipped, "already in "+dest
+			results = append(results, res)
+			continue
+		}
+
+		res.Sidecars = s.ops.Sidecars(p)
+		var targets []string
+		for _, m := range append([]string{p}, res.Sidecars...) {
+			targets = append(targets, filepath.Join(dest, filepath.Base(m)))
+		}
+		// Existing files, and files moved there earlier in the batch
+		target := s.ops.MoveConflict(p, dest)
+		for _, t := range targets {
+			if target == "" && planned[t] {
+				target = t
+			}
+		}
+		if target != "" {
+			results = append(results, failed(res, fmt.Errorf("already exists: %s", target)))
+			continue
+		}
+		for _, t := range targets {
+			planned[t] = true
+		}
+		res.Dest = filepath.Join(dest, filepath.Base(p))
+
+		if preview {
+			res.Status = statusPlanned
+		} else if _, err := s.ops.Move(p, dest); err != nil {
+			res = failed(res, err)
+		} else {
+			res.Status = statusOK
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// batchKeep applies the resolver to the selected files of each group:
+// the file it picks is kept and the other selected files of the group go
+// to the trash. Unselected files are left alone.
+func (s *Server) batchKeep(paths []string, groups []db.DuplicateGroup, resolver *resolve.Resolver, preview bool) ([]batchResult, error) {
+	// Files on disconnected volumes are never kept
+	offline, err := volume.Offline(s.database)
+	if err != nil {
+		return nil, err
+	}
+	resolver.SetOffline(offline)
+
+	selected := make(map[string]bool)
+	for _, p := range paths {
+		selected[p] = true
+	}
+	offlineOf := make(map[string]string)
+	var candidates []db.DuplicateGroup
+	for _, g := range groups {
+		var files []db.FileRecord
+		for _, f := range g.Files {
+			if name, ok := offline[f.Volume]; ok {
+				offlineOf[f.Path] = name
+			}
+			if selected[f.Path] {
+				files = append(files, f)
+			}
+		}
+		if len(files) > 1 {
+			candidates = append(candidates, db.DuplicateGroup{Code: g.Code, Files: files})
+		}
+	}
+	// With --verify only identical copies are resolved against each other
+	if s.verify {
+		if candidates, err = verify.Groups(candidates, s.database); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []batchResult
+	reported := make(map[string]bool)
+	report := func(res batchResult) {
+		if !reported[res.Path] {
+			reported[res.Path] = true
+			results = append(results, res)
+		}
+	}
+	status := statusOK
+	if preview {
+		status = statusPlanned
+	}
+	for _, plan := range resolver.PlanAll(candidates) {
+		if plan.Ambiguous() {
+			for _, f := range plan.Tied {
+				report(batchResult{Path: f.Path, Action: batchKeep, Status: statusSkipped,
+					Error: fmt.Sprintf("tie between %d files", len(plan.Tied))})
+			}
+			continue
+		}
+		report(batchResult{Path: plan.Keep.Path, Action: batchKeep, Status: status})
+		for _, f := range plan.Remove {
+			if !reported[f.Path] {
+				report(s.trash(batchResult{Path: f.Path, Action: batchTrash}, preview))
+			}
+		}
+	}
+
+	reason := "no other selected copy"
+	if s.verify {
+		reason = "no identical selected copy"
+	}
+	for _, p := range paths {
+		if name, ok := offlineOf[p]; ok {
+			report(batchResult{Path: p, Action: batchKeep, Status: statusSkipped, Error: "on the offline volume " + name})
+			continue
+		}
+		report(batchResult{Path: p, Action: batchKeep, Status: statusSkipped, Error: reason})
+	}
+	return results, nil
+}
+
+// failed returns res marked as failed with err.
+func failed(res batchResult, err error) batchResult {
+	res.Status, res.Error = statusError, err.Error()
+	return res
+}
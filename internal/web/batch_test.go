@@ -0,0 +1,241 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jiikko/fdup/internal/db"
+)
+
+// setupBatchServer indexes two groups under a root: ABC001 in a, b and c
+// with growing sizes, and XYZ001 in a and b.
+func setupBatchServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	database, tmpDir := setupTestDB(t)
+	t.Cleanup(func() { database.Close() })
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	root := filepath.Join(tmpDir, "root")
+	files := []struct {
+		name string
+		code string
+		size int
+	}{
+		{"a/ABC001.txt", "ABC001", 1},
+		{"b/ABC001.txt", "ABC001", 2},
+		{"c/ABC001.txt", "ABC001", 3},
+		{"a/XYZ001.txt", "XYZ001", 1},
+		{"b/XYZ001.txt", "XYZ001", 1},
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f.name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), f.size), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		database.InsertFile(db.FileRecord{Path: path, Code: f.code, Size: int64(f.size), Mtime: time.Now(), Root: root})
+	}
+
+	s := newServer(database, false)
+	s.roots = []string{root}
+	return s, root
+}
+
+// postBatch sends req to the batch endpoint.
+func postBatch(t *testing.T, s *Server, req batchRequest) (int, batchResponse) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	s.handleBatch(w, httptest.NewRequest(http.MethodPost, "/api/batch", bytes.NewReader(body)))
+	var resp batchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp
+}
+
+// statuses maps each path of resp to its action and status.
+func statuses(resp batchResponse) map[string]string {
+	m := make(map[string]string)
+	for _, r := range resp.Results {
+		m[r.Path] = r.Action + " " + r.Status
+	}
+	return m
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestBatchTrash(t *testing.T) {
+	s, root := setupBatchServer(t)
+	a := filepath.Join(root, "a", "ABC001.txt")
+	b := filepath.Join(root, "b", "ABC001.txt")
+	outside := filepath.Join(root, "..", "secret.txt")
+	req := batchRequest{Action: batchTrash, Paths: []string{a, b, a, outside}, Preview: true}
+
+	// A preview changes nothing
+	code, resp := postBatch(t, s, req)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(resp.Results) != 3 || resp.Done != 2 || resp.Failed != 1 {
+		t.Errorf("unexpected preview %+v", resp)
+	}
+	if got := statuses(resp)[a]; got != "trash planned" {
+		t.Errorf("expected the file to be planned, got %q", got)
+	}
+	if !exists(a) || !exists(b) {
+		t.Error("expected a preview to leave the files alone")
+	}
+
+	req.Preview = false
+	_, resp = postBatch(t, s, req)
+	if resp.Done != 2 || resp.Failed != 1 || statuses(resp)[outside] != "trash error" {
+		t.Errorf("unexpected result %+v", resp)
+	}
+	if exists(a) || exists(b) {
+		t.Error("expected the files to be trashed")
+	}
+	if rec, _ := s.database.GetFile(a); rec != nil {
+		t.Error("expected the trashed file to be gone from the index")
+	}
+}
+
+func TestBatchMove(t *testing.T) {
+	s, root := setupBatchServer(t)
+	dest := filepath.Join(root, "sorted")
+
+	// Both XYZ001 files have the same name, so only the first can move
+	code, resp := postBatch(t, s, batchRequest{
+		Action: batchMove,
+		Paths:  []string{filepath.Join(root, "a", "XYZ001.txt"), filepath.Join(root, "b", "XYZ001.txt"), filepath.Join(root, "c", "ABC001.txt")},
+		Dest:   dest,
+	})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	want := map[string]string{
+		filepath.Join(root, "a", "XYZ001.txt"): "move ok",
+		filepath.Join(root, "b", "XYZ001.txt"): "move error",
+		filepath.Join(root, "c", "ABC001.txt"): "move ok",
+	}
+	got := statuses(resp)
+	for path, status := range want {
+		if got[path] != status {
+			t.Errorf("%s: expected %q, got %q", path, status, got[path])
+		}
+	}
+	if !exists(filepath.Join(dest, "XYZ001.txt")) || !exists(filepath.Join(root, "b", "XYZ001.txt")) {
+		t.Error("expected one XYZ001 file moved and the other left alone")
+	}
+	if rec, _ := s.database.GetFile(filepath.Join(dest, "ABC001.txt")); rec == nil {
+		t.Error("expected the index to have the new path")
+	}
+
+	// Outside the roots
+	if code, _ := postBatch(t, s, batchRequest{Action: batchMove, Paths: []string{filepath.Join(root, "a", "ABC001.txt")}, Dest: "/tmp"}); code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", code)
+	}
+}
+
+func TestBatchKeep(t *testing.T) {
+	s, root := setupBatchServer(t)
+
+	// Everything in the view but the largest ABC001 file
+	code, resp := postBatch(t, s, batchRequest{
+		Action:  batchKeep,
+		All:     true,
+		Exclude: []string{filepath.Join(root, "c", "ABC001.txt")},
+		Rules:   []string{"largest"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	want := map[string]string{
+		filepath.Join(root, "b", "ABC001.txt"): "keep ok",
+		filepath.Join(root, "a", "ABC001.txt"): "trash ok",
+		// Same size, so the rule ends in a tie
+		filepath.Join(root, "a", "XYZ001.txt"): "keep skipped",
+		filepath.Join(root, "b", "XYZ001.txt"): "keep skipped",
+	}
+	got := statuses(resp)
+	if len(got) != len(want) {
+		t.Errorf("expected %d results, got %v", len(want), got)
+	}
+	for path, status := range want {
+		if got[path] != status {
+			t.Errorf("%s: expected %q, got %q", path, status, got[path])
+		}
+	}
+	if exists(filepath.Join(root, "a", "ABC001.txt")) || !exists(filepath.Join(root, "c", "ABC001.txt")) {
+		t.Error("expected only the selected smaller file to be trashed")
+	}
+}
+
+// addOfflineCopy indexes a copy of code on a volume that is not mounted.
+func addOfflineCopy(t *testing.T, s *Server, root, code string, size int) string {
+	t.Helper()
+	if err := s.database.SaveVolume("uuid-1", "archive", filepath.Join(root, "disk")); err != nil {
+		t.Fatalf("failed to save volume: %v", err)
+	}
+	path := filepath.Join(root, "disk", code+".txt")
+	s.database.InsertFile(db.FileRecord{Path: path, Code: code, Size: int64(size), Mtime: time.Now(), Root: root, Volume: "uuid-1"})
+	return path
+}
+
+func TestBatchKeepSkipsOfflineVolumes(t *testing.T) {
+	s, root := setupBatchServer(t)
+	// The largest copy is on a disconnected disk
+	archived := addOfflineCopy(t, s, root, "ABC001", 10)
+
+	code, resp := postBatch(t, s, batchRequest{
+		Action: batchKeep,
+		Paths:  []string{archived, filepath.Join(root, "a", "ABC001.txt"), filepath.Join(root, "c", "ABC001.txt")},
+		Rules:  []string{"largest"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	want := map[string]string{
+		filepath.Join(root, "c", "ABC001.txt"): "keep ok",
+		filepath.Join(root, "a", "ABC001.txt"): "trash ok",
+		archived:                               "keep skipped",
+	}
+	got := statuses(resp)
+	if len(got) != len(want) {
+		t.Errorf("expected %d results, got %v", len(want), got)
+	}
+	for path, status := range want {
+		if got[path] != status {
+			t.Errorf("%s: expected %q, got %q", path, status, got[path])
+		}
+	}
+}
+
+func TestBatchInvalid(t *testing.T) {
+	s, root := setupBatchServer(t)
+	path := filepath.Join(root, "a", "ABC001.txt")
+
+	tests := []struct {
+		name string
+		req  batchRequest
+	}{
+		{"unknown action", batchRequest{Action: "shred", Paths: []string{path}}},
+		{"keep without a rule", batchRequest{Action: batchKeep, Paths: []string{path}}},
+		{"unknown rule", batchRequest{Action: batchKeep, Paths: []string{path}, Rules: []string{"best"}}},
+		{"nothing selected", batchRequest{Action: batchTrash}},
+	}
+	for _, tt := range tests {
+		if code, _ := postBatch(t, s, tt.req); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", tt.name, code)
+		}
+	}
+}
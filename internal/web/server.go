@@ -3,6 +3,7 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -11,11 +12,18 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/fileops"
+	"github.com/jiikko/fdup/internal/sidecar"
+	"github.com/jiikko/fdup/internal/verify"
+	"github.com/jiikko/fdup/internal/volume"
 )
 
 // Server holds the web server state.
@@ -23,31 +31,86 @@ type Server struct {
 	database *db.DB
 	server   *http.Server
 	port     int
+	verify   bool
+	ops      *fileops.Executor
+	// filter limits the files shown, as given to dup.
+	filter db.FileFilter
+	// normalize turns codes given to the API into indexed codes.
+	normalize func(string) string
+	// display formats codes in pages and API responses.
+	display *code.Display
+	// scan rescans the roots for the scan endpoint; nil disables it.
+	scan     func() (*db.SyncResult, error)
+	scanning sync.Mutex
+	// token is required on every request; empty disables the check.
+	token string
+	// roots limit the paths web actions may act on; empty allows none.
+	roots []string
 }
 
-// Run starts the web server and opens the browser.
-func Run(groups []db.DuplicateGroup, database *db.DB) error {
-	_ = groups // Initial groups ignored; we fetch fresh data on each request
-	s := &Server{
+func newServer(database *db.DB, verify bool) *Server {
+	return &Server{
 		database: database,
+		verify:   verify,
+		ops:      fileops.New(database),
 	}
+}
+
+// Options configure the web UI.
+type Options struct {
+	// Verify splits groups by file content on every request.
+	Verify bool
+	// Filter limits the files shown.
+	Filter db.FileFilter
+	// Sidecars are trashed along with the file they belong to.
+	Sidecars *sidecar.Rules
+	// Normalize turns codes given to the API into indexed codes. Nil uses
+	// them as given.
+	Normalize func(string) string
+	// Display formats codes. Nil uses the default format.
+	Display *code.Display
+	// Scan rescans the roots and updates the index for POST /api/v1/scan.
+	// Nil disables the endpoint.
+	Scan func() (*db.SyncResult, error)
+	// Roots are the configured scan roots, online or not. Web actions
+	// only act on indexed files under them; without roots they act on none.
+	Roots []string
+	// Listen is the address to listen on, as host:port or just a host.
+	// Without a port the first free one from 8080 is used. Empty listens
+	// on 127.0.0.1.
+	Listen string
+}
 
-	// Find available port starting from 8080
-	port, listener, err := findAvailablePort(8080)
+// defaultHost is where the web UI listens by default, reachable only from
+// this machine.
+const defaultHost = "127.0.0.1"
+
+// Run starts the web server and opens the browser.
+func Run(groups []db.DuplicateGroup, database *db.DB, opts Options) error {
+	_ = groups // Initial groups ignored; we fetch fresh data on each request
+	s := newServer(database, opts.Verify)
+	s.filter = opts.Filter
+	s.ops.SetSidecars(opts.Sidecars)
+	s.normalize = opts.Normalize
+	s.display = opts.Display
+	s.scan = opts.Scan
+	s.roots = opts.Roots
+
+	token, err := newToken()
 	if err != nil {
-		return fmt.Errorf("failed to find available port: %w", err)
+		return fmt.Errorf("failed to generate token: %w", err)
 	}
-	s.port = port
+	s.token = token
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.handleIndex)
-	mux.HandleFunc("/api/open", s.handleOpen)
-	mux.HandleFunc("/api/reveal", s.handleReveal)
-	mux.HandleFunc("/api/delete", s.handleDelete)
-	mux.HandleFunc("/api/shutdown", s.handleShutdown)
+	listener, err := listen(opts.Listen)
+	if err != nil {
+		return err
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	s.port = addr.Port
 
 	s.server = &http.Server{
-		Handler: mux,
+		Handler: s.protect(s.routes()),
 	}
 
 	// Handle graceful shutdown
@@ -67,7 +130,14 @@ func Run(groups []db.DuplicateGroup, database *db.DB) error {
 	}()
 
 	// Open browser
-	url := fmt.Sprintf("http://localhost:%d", port)
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = "localhost"
+	}
+	url := fmt.Sprintf("http://%s/?token=%s", net.JoinHostPort(host, strconv.Itoa(addr.Port)), token)
+	if !addr.IP.IsLoopback() {
+		fmt.Fprintf(os.Stderr, "Warning: listening on %s, other hosts can reach the web UI with the token\n", addr)
+	}
 	fmt.Printf("Starting web server at %s\n", url)
 	fmt.Println("Press Ctrl+C to stop")
 
@@ -85,9 +155,55 @@ func Run(groups []db.DuplicateGroup, database *db.DB) error {
 	return nil
 }
 
-func findAvailablePort(startPort int) (int, net.Listener, error) {
+// routes returns the handler of every page and endpoint.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/open", s.handleOpen)
+	mux.HandleFunc("/api/reveal", s.handleReveal)
+	mux.HandleFunc("/api/delete", s.handleDelete)
+	mux.HandleFunc("/api/move", s.handleMove)
+	mux.HandleFunc("/api/keep", s.handleKeep)
+	mux.HandleFunc("/api/batch", s.handleBatch)
+	mux.HandleFunc("/api/shutdown", s.handleShutdown)
+
+	// Versioned JSON API for scripts
+	mux.HandleFunc("/api/v1/groups", s.handleAPIGroups)
+	mux.HandleFunc("/api/v1/groups/{code}", s.handleAPIGroup)
+	mux.HandleFunc("/api/v1/files", s.handleAPIFiles)
+	mux.HandleFunc("/api/v1/stats", s.handleAPIStats)
+	mux.HandleFunc("/api/v1/scan", s.handleAPIScan)
+	return mux
+}
+
+// listen opens the address given to Options.Listen.
+func listen(addr string) (net.Listener, error) {
+	host, port := defaultHost, ""
+	if addr != "" {
+		if h, p, err := net.SplitHostPort(addr); err == nil {
+			host, port = h, p
+		} else {
+			host = addr
+		}
+	}
+
+	if port == "" {
+		_, listener, err := findAvailablePort(host, 8080)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find available port: %w", err)
+		}
+		return listener, nil
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return listener, nil
+}
+
+func findAvailablePort(host string, startPort int) (int, net.Listener, error) {
 	for port := startPort; port < startPort+100; port++ {
-		addr := fmt.Sprintf(":%d", port)
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
 		listener, err := net.Listen("tcp", addr)
 		if err == nil {
 			return port, listener, nil
@@ -118,7 +234,7 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch fresh data from database
-	allGroups, err := s.database.FindDuplicates()
+	stats, err := s.database.Stats(s.filter)
 	if err != nil {
 		http.Error(w, "Failed to fetch duplicates", http.StatusInternalServerError)
 		return
@@ -133,7 +249,7 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate pagination
-	totalGroups := len(allGroups)
+	totalGroups := stats.Groups
 	totalPages := (totalGroups + perPage - 1) / perPage
 	if totalPages == 0 {
 		totalPages = 1
@@ -142,16 +258,27 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		page = totalPages
 	}
 
-	start := (page - 1) * perPage
-	end := start + perPage
-	if end > totalGroups {
-		end = totalGroups
+	pageGroups, _, err := s.database.FindDuplicatesPage(db.GroupQuery{
+		Filter: s.filter,
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	})
+	if err != nil {
+		http.Error(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
 	}
 
-	pageGroups := allGroups[start:end]
+	// Verify only the groups being shown; hashes are cached in the database
+	if s.verify {
+		pageGroups, err = verify.Groups(pageGroups, s.database)
+		if err != nil {
+			http.Error(w, "Failed to verify duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(s.renderHTML(pageGroups, page, totalPages, totalGroups)))
+	w.Write([]byte(s.renderHTML(pageGroups, page, totalPages, totalGroups, stats.DuplicateFiles)))
 }
 
 func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request) {
@@ -168,6 +295,10 @@ func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.allowPath(w, req.Path) {
+		return
+	}
+
 	if err := openFile(req.Path); err != nil {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -190,6 +321,10 @@ func (s *Server) handleReveal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.allowPath(w, req.Path) {
+		return
+	}
+
 	if err := revealInFinder(req.Path); err != nil {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -212,20 +347,236 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := moveToTrash(req.Path); err != nil {
-		jsonError(w, err.Error(), http.StatusInternalServerError)
+	if !s.allowPath(w, req.Path) {
 		return
 	}
 
-	// Remove from database
-	if s.database != nil {
-		_ = s.database.DeleteFile(req.Path)
+	// Trash the file with its sidecars and remove it from the database
+	sidecars := s.ops.Sidecars(req.Path)
+	if err := s.ops.Remove(req.Path, true); err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	fmt.Printf("[DELETE] Moved to trash: %s\n", req.Path)
+	for _, sc := range sidecars {
+		fmt.Printf("[DELETE] Moved to trash: %s\n", sc)
+	}
 	jsonSuccess(w, "Moved to trash")
 }
 
+// allowPath reports whether web actions may act on path: an indexed file
+// under one of the roots, given as a clean absolute path. Otherwise it
+// rejects the request with 403.
+func (s *Server) allowPath(w http.ResponseWriter, path string) bool {
+	if code, err := s.checkPath(path); err != nil {
+		jsonError(w, err.Error(), code)
+		return false
+	}
+	return true
+}
+
+// checkPath returns why web actions may not act on path, with the status
+// to reject them with, or nil if they may.
+func (s *Server) checkPath(path string) (int, error) {
+	if path == "" || !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return http.StatusForbidden, fmt.Errorf("invalid path %q", path)
+	}
+	if !s.inRoots(path) {
+		return http.StatusForbidden, fmt.Errorf("not under a scan root: %s", path)
+	}
+
+	rec, err := s.database.GetFile(path)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to look up %s: %w", path, err)
+	}
+	if rec == nil {
+		return http.StatusForbidden, fmt.Errorf("not an indexed file: %s", path)
+	}
+	return 0, nil
+}
+
+// allowDir reports whether files may be moved into dir: a root or a
+// folder under one, given as a clean absolute path. Otherwise it rejects
+// the request with 403.
+func (s *Server) allowDir(w http.ResponseWriter, dir string) bool {
+	if dir == "" || !filepath.IsAbs(dir) || filepath.Clean(dir) != dir {
+		jsonError(w, fmt.Sprintf("invalid folder %q", dir), http.StatusForbidden)
+		return false
+	}
+	for _, root := range s.roots {
+		if dir == root {
+			return true
+		}
+	}
+	if !s.inRoots(dir) {
+		jsonError(w, fmt.Sprintf("not under a scan root: %s", dir), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// inRoots reports whether path is below one of the roots. Without roots
+// no path is.
+func (s *Server) inRoots(path string) bool {
+	for _, root := range s.roots {
+		if inDir(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMove moves a file with its sidecars into another folder, as the
+// TUI does. It never replaces a file already in the folder.
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+		Dest string `json:"dest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !s.allowPath(w, req.Path) || !s.allowDir(w, req.Dest) {
+		return
+	}
+	if filepath.Dir(req.Path) == req.Dest {
+		jsonError(w, "The file is already in "+req.Dest, http.StatusBadRequest)
+		return
+	}
+
+	sidecars := s.ops.Sidecars(req.Path)
+	newPath, err := s.ops.Move(req.Path, req.Dest)
+	if errors.Is(err, os.ErrExist) {
+		jsonError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("[MOVE] %s -> %s\n", req.Path, newPath)
+	for _, sc := range sidecars {
+		fmt.Printf("[MOVE] %s -> %s\n", sc, filepath.Join(req.Dest, filepath.Base(sc)))
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": "Moved", "path": newPath})
+}
+
+// handleKeep keeps one file of a duplicate group and moves the other
+// files of the group, with their sidecars, to the trash.
+func (s *Server) handleKeep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !s.allowPath(w, req.Path) {
+		return
+	}
+
+	// The group as shown, so files hidden by the filter are left alone
+	groups, err := s.database.FindDuplicatesMatching(s.filter)
+	if err != nil {
+		jsonError(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+	var group *db.DuplicateGroup
+	for i, g := range groups {
+		if g.Code == req.Code && slices.ContainsFunc(g.Files, func(f db.FileRecord) bool { return f.Path == req.Path }) {
+			group = &groups[i]
+			break
+		}
+	}
+	if group == nil {
+		jsonError(w, fmt.Sprintf("%s is not in the duplicate group %s", req.Path, req.Code), http.StatusNotFound)
+		return
+	}
+
+	// Files on disconnected volumes can be neither kept nor trashed
+	offline, err := volume.Offline(s.database)
+	if err != nil {
+		jsonError(w, "Failed to read volumes", http.StatusInternalServerError)
+		return
+	}
+	for _, f := range group.Files {
+		if name, ok := offline[f.Volume]; f.Path == req.Path && ok {
+			jsonError(w, fmt.Sprintf("%s is on the offline volume %s", filepath.Base(req.Path), name), http.StatusConflict)
+			return
+		}
+	}
+
+	copies := group.Files
+	// With --verify only identical copies of the kept file are trashed
+	if s.verify {
+		verified, err := verify.Groups([]db.DuplicateGroup{*group}, s.database)
+		if err != nil {
+			jsonError(w, "Failed to verify duplicates", http.StatusInternalServerError)
+			return
+		}
+		copies = nil
+		for _, sg := range verified[0].Subgroups {
+			if sg.Identical && slices.ContainsFunc(sg.Files, func(f db.FileRecord) bool { return f.Path == req.Path }) {
+				copies = sg.Files
+			}
+		}
+		if copies == nil {
+			jsonError(w, fmt.Sprintf("No identical copy of %s in the group", filepath.Base(req.Path)), http.StatusConflict)
+			return
+		}
+	}
+	var others []string
+	for _, f := range copies {
+		if _, ok := offline[f.Volume]; !ok && f.Path != req.Path {
+			others = append(others, f.Path)
+		}
+	}
+	// Check every file before trashing any
+	for _, path := range others {
+		if !s.allowPath(w, path) {
+			return
+		}
+	}
+
+	trashed := []string{}
+	for _, path := range others {
+		sidecars := s.ops.Sidecars(path)
+		if err := s.ops.Remove(path, true); err != nil {
+			jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("[DELETE] Moved to trash: %s\n", path)
+		for _, sc := range sidecars {
+			fmt.Printf("[DELETE] Moved to trash: %s\n", sc)
+		}
+		trashed = append(trashed, path)
+	}
+
+	word := "file"
+	if len(trashed) != 1 {
+		word = "files"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"message": fmt.Sprintf("Kept %s, moved %d %s to trash", filepath.Base(req.Path), len(trashed), word),
+		"trashed": trashed,
+	})
+}
+
 func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -265,27 +616,6 @@ func revealInFinder(path string) error {
 	}
 }
 
-func moveToTrash(path string) error {
-	var trashDir string
-	switch runtime.GOOS {
-	case "darwin":
-		home, _ := os.UserHomeDir()
-		trashDir = filepath.Join(home, ".Trash")
-	case "linux":
-		home, _ := os.UserHomeDir()
-		trashDir = filepath.Join(home, ".local", "share", "Trash", "files")
-	default:
-		return os.Remove(path)
-	}
-
-	if err := os.MkdirAll(trashDir, 0755); err != nil {
-		return err
-	}
-
-	destPath := filepath.Join(trashDir, filepath.Base(path))
-	return os.Rename(path, destPath)
-}
-
 func jsonSuccess(w http.ResponseWriter, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "message": message})
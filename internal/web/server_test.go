@@ -45,7 +45,7 @@ func TestHandleIndex(t *testing.T) {
 		Mtime: time.Now(),
 	})
 
-	s := &Server{database: database}
+	s := newServer(database, false)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	w := httptest.NewRecorder()
@@ -65,11 +65,73 @@ func TestHandleIndex(t *testing.T) {
 	}
 }
 
+func TestHandleIndexVerify(t *testing.T) {
+	database, tmpDir := setupTestDB(t)
+	defer database.Close()
+
+	// Two copies with the same content and one with different content
+	contents := map[string]string{
+		"a/DSC00001.jpg": "same",
+		"b/DSC00001.jpg": "same",
+		"c/DSC00001.jpg": "other",
+	}
+	for rel, content := range contents {
+		path := filepath.Join(tmpDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		info, _ := os.Stat(path)
+		database.InsertFile(db.FileRecord{
+			Path:  path,
+			Code:  "DSC00001",
+			Size:  info.Size(),
+			Mtime: info.ModTime(),
+		})
+	}
+
+	s := newServer(database, true)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	s.handleIndex(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Identical content") {
+		t.Error("expected body to contain identical subgroup")
+	}
+	if !strings.Contains(body, "Same code, different content") {
+		t.Error("expected body to contain different subgroup")
+	}
+
+	// Hashes of the identical files should now be cached
+	files, err := database.ListFiles()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	hashed := 0
+	for _, f := range files {
+		if f.Hash != "" {
+			hashed++
+		}
+	}
+	if hashed != 2 {
+		t.Errorf("expected 2 cached hashes, got %d", hashed)
+	}
+}
+
 func TestHandleIndexPagination(t *testing.T) {
 	database, _ := setupTestDB(t)
 	defer database.Close()
 
-	s := &Server{database: database}
+	s := newServer(database, false)
 
 	// Test page parameter
 	req := httptest.NewRequest(http.MethodGet, "/?page=1", nil)
@@ -86,7 +148,7 @@ func TestHandleIndexNotFound(t *testing.T) {
 	database, _ := setupTestDB(t)
 	defer database.Close()
 
-	s := &Server{database: database}
+	s := newServer(database, false)
 
 	req := httptest.NewRequest(http.MethodGet, "/notfound", nil)
 	w := httptest.NewRecorder()
@@ -98,11 +160,52 @@ func TestHandleIndexNotFound(t *testing.T) {
 	}
 }
 
+func TestHandleIndexEscapesHostileNames(t *testing.T) {
+	database, _ := setupTestDB(t)
+	defer database.Close()
+
+	// A name that breaks out of both a quoted attribute and a JS string
+	hostile := `/test/x');alert(1);//" onmouseover="alert(2)<b>.jpg`
+	for _, path := range []string{hostile, "/test/plain.jpg"} {
+		database.InsertFile(db.FileRecord{
+			Path:  path,
+			Code:  `<script>alert(3)</script>`,
+			Size:  1024,
+			Mtime: time.Now(),
+		})
+	}
+
+	s := newServer(database, false)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	s.handleIndex(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, raw := range []string{`');alert(1)`, `" onmouseover=`, `<b>`, `<script>alert(3)`} {
+		if strings.Contains(body, raw) {
+			t.Errorf("expected %q to be escaped", raw)
+		}
+	}
+	escaped := `data-path="/test/x&#39;);alert(1);//&quot; onmouseover=&quot;alert(2)&lt;b&gt;.jpg"`
+	if !strings.Contains(body, escaped) {
+		t.Errorf("expected the path in an escaped data attribute, got:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(3)&lt;/script&gt;") {
+		t.Error("expected the escaped code in the heading")
+	}
+}
+
 func TestHandleOpen(t *testing.T) {
 	database, _ := setupTestDB(t)
 	defer database.Close()
 
-	s := &Server{database: database}
+	s := newServer(database, false)
 
 	// Test method not allowed
 	req := httptest.NewRequest(http.MethodGet, "/api/open", nil)
@@ -129,7 +232,7 @@ func TestHandleReveal(t *testing.T) {
 	database, _ := setupTestDB(t)
 	defer database.Close()
 
-	s := &Server{database: database}
+	s := newServer(database, false)
 
 	// Test method not allowed
 	req := httptest.NewRequest(http.MethodGet, "/api/reveal", nil)
@@ -146,6 +249,9 @@ func TestHandleDelete(t *testing.T) {
 	database, tmpDir := setupTestDB(t)
 	defer database.Close()
 
+	// Keep trashed files out of the real home trash
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
 	// Create a test file
 	testFile := filepath.Join(tmpDir, "test.txt")
 	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
@@ -160,7 +266,8 @@ func TestHandleDelete(t *testing.T) {
 		Mtime: time.Now(),
 	})
 
-	s := &Server{database: database}
+	s := newServer(database, false)
+	s.roots = []string{tmpDir}
 
 	// Test method not allowed
 	req := httptest.NewRequest(http.MethodGet, "/api/delete", nil)
@@ -205,11 +312,306 @@ func TestHandleDelete(t *testing.T) {
 	}
 }
 
+func TestWebActionsRejectUnindexedPaths(t *testing.T) {
+	database, tmpDir := setupTestDB(t)
+	defer database.Close()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	root := filepath.Join(tmpDir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create root: %v", err)
+	}
+	indexed := filepath.Join(root, "sub", "ABC001.txt")
+	unindexed := filepath.Join(root, "sub", "notes.txt")
+	outside := filepath.Join(tmpDir, "secret.txt")
+	for _, path := range []string{indexed, unindexed, outside} {
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	for _, path := range []string{indexed, outside} {
+		database.InsertFile(db.FileRecord{Path: path, Code: "ABC001", Size: 4, Mtime: time.Now()})
+	}
+
+	s := newServer(database, false)
+	s.roots = []string{root}
+
+	paths := []string{
+		"",
+		"secret.txt",
+		"../secret.txt",
+		filepath.Join(root, "sub") + "/../../secret.txt",
+		root + "/./sub/ABC001.txt",
+		root + "//sub/ABC001.txt",
+		unindexed,
+		// Indexed, but outside the roots
+		outside,
+		root,
+		root + "-other/ABC001.txt",
+	}
+	handlers := map[string]http.HandlerFunc{
+		"open":   s.handleOpen,
+		"reveal": s.handleReveal,
+		"delete": s.handleDelete,
+		"move":   s.handleMove,
+		"keep":   s.handleKeep,
+	}
+	for name, handler := range handlers {
+		for _, path := range paths {
+			body, _ := json.Marshal(map[string]string{"path": path})
+			req := httptest.NewRequest(http.MethodPost, "/api/"+name, strings.NewReader(string(body)))
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %q: expected status 403, got %d", name, path, w.Code)
+			}
+		}
+	}
+
+	for _, path := range []string{unindexed, outside} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be left alone: %v", path, err)
+		}
+	}
+
+	// The indexed file under the root is still allowed
+	body := `{"path":"` + indexed + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/delete", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.handleDelete(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebActionsWithoutRoots(t *testing.T) {
+	database, tmpDir := setupTestDB(t)
+	defer database.Close()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	// Without roots, as when none are online to scan, nothing is allowed
+	root := setupRoot(t, database, tmpDir, "ABC001", "a/ABC001.txt", "b/ABC001.txt")
+	s := newServer(database, false)
+
+	file := filepath.Join(root, "a", "ABC001.txt")
+	elsewhere := filepath.Join(tmpDir, "elsewhere")
+	for name, tt := range map[string]struct {
+		handler http.HandlerFunc
+		body    map[string]string
+	}{
+		"delete": {s.handleDelete, map[string]string{"path": file}},
+		"move":   {s.handleMove, map[string]string{"path": file, "dest": elsewhere}},
+	} {
+		if status, _ := postJSON(t, tt.handler, "/api/"+name, tt.body); status != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d", name, status)
+		}
+	}
+	if status, _ := postBatch(t, s, batchRequest{Action: batchMove, Paths: []string{file}, Dest: elsewhere}); status != http.StatusForbidden {
+		t.Errorf("batch move: expected status 403, got %d", status)
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("expected the file to be left alone: %v", err)
+	}
+	if _, err := os.Stat(elsewhere); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be created outside the roots, got %v", err)
+	}
+}
+
+// setupRoot creates files under a root in tmpDir and indexes them with
+// the given code.
+func setupRoot(t *testing.T, database *db.DB, tmpDir, code string, names ...string) string {
+	t.Helper()
+	root := filepath.Join(tmpDir, "root")
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		database.InsertFile(db.FileRecord{Path: path, Code: code, Size: 4, Mtime: time.Now(), Root: root})
+	}
+	return root
+}
+
+// postJSON sends body to handler and returns the status and decoded response.
+func postJSON(t *testing.T, handler http.HandlerFunc, target string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(string(data)))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	var resp map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp
+}
+
+func TestHandleMove(t *testing.T) {
+	database, tmpDir := setupTestDB(t)
+	defer database.Close()
+
+	root := setupRoot(t, database, tmpDir, "ABC001", "a/ABC001.txt", "b/ABC001.txt", "c/ABC001 (1).txt")
+	s := newServer(database, false)
+	s.roots = []string{root}
+	src := filepath.Join(root, "c", "ABC001 (1).txt")
+
+	tests := []struct {
+		dest string
+		want int
+	}{
+		{filepath.Join(tmpDir, "elsewhere"), http.StatusForbidden},
+		{filepath.Join(root, "a") + "/../..", http.StatusForbidden},
+		{"a", http.StatusForbidden},
+		{filepath.Join(root, "c"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, _ := postJSON(t, s.handleMove, "/api/move", map[string]string{"path": src, "dest": tt.dest}); code != tt.want {
+			t.Errorf("dest %q: expected status %d, got %d", tt.dest, tt.want, code)
+		}
+	}
+
+	// A copy with the same name is never replaced
+	if code, _ := postJSON(t, s.handleMove, "/api/move", map[string]string{
+		"path": filepath.Join(root, "a", "ABC001.txt"), "dest": filepath.Join(root, "b"),
+	}); code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", code)
+	}
+
+	// Into another copy's folder
+	dest := filepath.Join(root, "a")
+	code, resp := postJSON(t, s.handleMove, "/api/move", map[string]string{"path": src, "dest": dest})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", code, resp)
+	}
+	moved := filepath.Join(dest, "ABC001 (1).txt")
+	if resp["path"] != moved {
+		t.Errorf("expected new path %s, got %v", moved, resp["path"])
+	}
+	if _, err := os.Stat(moved); err != nil {
+		t.Errorf("expected the file to be moved: %v", err)
+	}
+	if rec, _ := database.GetFile(moved); rec == nil {
+		t.Error("expected the index to have the new path")
+	}
+	if rec, _ := database.GetFile(src); rec != nil {
+		t.Error("expected the old path to be gone from the index")
+	}
+
+	// Into a new folder under the root
+	dest = filepath.Join(root, "sorted")
+	if code, resp := postJSON(t, s.handleMove, "/api/move", map[string]string{"path": moved, "dest": dest}); code != http.StatusOK {
+		t.Errorf("expected status 200, got %d: %v", code, resp)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "ABC001 (1).txt")); err != nil {
+		t.Errorf("expected the file to be moved: %v", err)
+	}
+}
+
+func TestHandleKeep(t *testing.T) {
+	database, tmpDir := setupTestDB(t)
+	defer database.Close()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	root := setupRoot(t, database, tmpDir, "ABC001", "a/ABC001.txt", "b/ABC001.txt", "c/ABC001.txt")
+	setupRoot(t, database, tmpDir, "XYZ001", "a/XYZ001.txt", "b/XYZ001.txt")
+	s := newServer(database, false)
+	s.roots = []string{root}
+	keep := filepath.Join(root, "b", "ABC001.txt")
+
+	// The file must belong to the group
+	if code, _ := postJSON(t, s.handleKeep, "/api/keep", map[string]string{"code": "XYZ001", "path": keep}); code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", code)
+	}
+
+	code, resp := postJSON(t, s.handleKeep, "/api/keep", map[string]string{"code": "ABC001", "path": keep})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", code, resp)
+	}
+	if trashed, _ := resp["trashed"].([]interface{}); len(trashed) != 2 {
+		t.Errorf("expected 2 trashed files, got %v", resp["trashed"])
+	}
+
+	for _, dir := range []string{"a", "c"} {
+		path := filepath.Join(root, dir, "ABC001.txt")
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be trashed", path)
+		}
+		if rec, _ := database.GetFile(path); rec != nil {
+			t.Errorf("expected %s to be gone from the index", path)
+		}
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("expected the kept file to remain: %v", err)
+	}
+	// Other groups are left alone
+	if _, err := os.Stat(filepath.Join(root, "a", "XYZ001.txt")); err != nil {
+		t.Errorf("expected other groups to remain: %v", err)
+	}
+}
+
+func TestHandleKeepVerify(t *testing.T) {
+	database, tmpDir := setupTestDB(t)
+	defer database.Close()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	root := setupRoot(t, database, tmpDir, "ABC001", "a/ABC001.txt", "b/ABC001.txt", "c/ABC001.txt")
+	// Same code and size, different content
+	different := filepath.Join(root, "c", "ABC001.txt")
+	if err := os.WriteFile(different, []byte("diff"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	s := newServer(database, true)
+	s.roots = []string{root}
+
+	// A file without an identical copy keeps everything
+	if code, resp := postJSON(t, s.handleKeep, "/api/keep", map[string]string{"code": "ABC001", "path": different}); code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d: %v", code, resp)
+	}
+
+	keep := filepath.Join(root, "a", "ABC001.txt")
+	code, resp := postJSON(t, s.handleKeep, "/api/keep", map[string]string{"code": "ABC001", "path": keep})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", code, resp)
+	}
+	identical := filepath.Join(root, "b", "ABC001.txt")
+	if trashed, _ := resp["trashed"].([]interface{}); len(trashed) != 1 || trashed[0] != identical {
+		t.Errorf("expected only %s to be trashed, got %v", identical, resp["trashed"])
+	}
+	if _, err := os.Stat(different); err != nil {
+		t.Errorf("expected the file with different content to remain: %v", err)
+	}
+}
+
+func TestHandleKeepOfflineVolumes(t *testing.T) {
+	s, root := setupBatchServer(t)
+	archived := addOfflineCopy(t, s, root, "ABC001", 10)
+
+	if code, resp := postJSON(t, s.handleKeep, "/api/keep", map[string]string{"code": "ABC001", "path": archived}); code != http.StatusConflict {
+		t.Errorf("expected status 409 for a file on an offline volume, got %d: %v", code, resp)
+	}
+
+	keep := filepath.Join(root, "a", "ABC001.txt")
+	code, resp := postJSON(t, s.handleKeep, "/api/keep", map[string]string{"code": "ABC001", "path": keep})
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", code, resp)
+	}
+	if trashed, _ := resp["trashed"].([]interface{}); len(trashed) != 2 {
+		t.Errorf("expected the 2 online copies to be trashed, got %v", resp["trashed"])
+	}
+	if rec, _ := s.database.GetFile(archived); rec == nil {
+		t.Error("expected the offline copy to stay indexed")
+	}
+}
+
 func TestHandleShutdown(t *testing.T) {
 	database, _ := setupTestDB(t)
 	defer database.Close()
 
-	s := &Server{database: database}
+	s := newServer(database, false)
 
 	// Test method not allowed
 	req := httptest.NewRequest(http.MethodGet, "/api/shutdown", nil)
@@ -223,7 +625,7 @@ func TestHandleShutdown(t *testing.T) {
 }
 
 func TestFindAvailablePort(t *testing.T) {
-	port, listener, err := findAvailablePort(18080)
+	port, listener, err := findAvailablePort("127.0.0.1", 18080)
 	if err != nil {
 		t.Fatalf("failed to find available port: %v", err)
 	}
@@ -2,38 +2,52 @@ package web
 
 import (
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"strings"
 
-	"github.com/jiikko/fdup/internal/code"
 	"github.com/jiikko/fdup/internal/db"
+	"github.com/jiikko/fdup/internal/resolve"
 )
 
-func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, totalPages, totalGroups int) string {
+func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, totalPages, totalGroups, totalFiles int) string {
 	var groups strings.Builder
+	// Each directory is read once for the whole page
+	sidecars := s.ops.SidecarCache()
 	for i, group := range duplicateGroups {
 		groups.WriteString(fmt.Sprintf(`
 		<div class="group" id="group-%d">
 			<h2>%s <span class="count">%d files</span></h2>
-			<ul>`, i, code.Format(group.Code), len(group.Files)))
+			<ul>`, i, escapeHTML(s.display.Format(group.Code)), len(group.Files)))
 
-		for j, file := range group.Files {
-			groups.WriteString(fmt.Sprintf(`
-				<li id="file-%d-%d" data-path="%s">
-					<span class="path">%s</span>
-					<span class="size">%s</span>
-					<div class="actions">
-						<button onclick="openFile('%s')" title="Open file">Open</button>
-						<button onclick="revealFile('%s')" title="Reveal in Finder">Finder</button>
-						<button onclick="deleteFile('%s', %d, %d)" class="delete" title="Move to Trash">Delete</button>
-					</div>
-				</li>`,
-				i, j,
-				escapeHTML(file.Path),
-				escapeHTML(file.Path),
-				formatSize(file.Size),
-				escapeJS(file.Path),
-				escapeJS(file.Path),
-				escapeJS(file.Path), i, j))
+		// Folders of the copies, offered as move destinations
+		var dirs []string
+		seen := make(map[string]bool)
+		for _, file := range group.Files {
+			if dir := filepath.Dir(file.Path); !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+
+		if group.Verified() {
+			for _, sg := range group.Subgroups {
+				class, label := "different", "Same code, different content"
+				if sg.Identical {
+					class, label = "identical", "Identical content"
+				}
+				groups.WriteString(fmt.Sprintf(`
+				<li class="subgroup %s">%s</li>`, class, label))
+				for _, file := range sg.Files {
+					found, _ := sidecars.Of(file.Path)
+					groups.WriteString(renderFile(file, found, group.Code, dirs))
+				}
+			}
+		} else {
+			for _, file := range group.Files {
+				found, _ := sidecars.Of(file.Path)
+				groups.WriteString(renderFile(file, found, group.Code, dirs))
+			}
 		}
 
 		groups.WriteString(`
@@ -114,11 +128,26 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 		li:last-child {
 			border-bottom: none;
 		}
+		li.subgroup {
+			font-size: 13px;
+			font-weight: bold;
+			padding: 6px 10px;
+		}
+		li.subgroup.identical {
+			color: #28a745;
+		}
+		li.subgroup.different {
+			color: #d48806;
+		}
 		li.deleted {
 			opacity: 0.5;
 			text-decoration: line-through;
 			background: #fee;
 		}
+		li.moved {
+			opacity: 0.5;
+			background: #eef;
+		}
 		.path {
 			flex: 1;
 			word-break: break-all;
@@ -130,6 +159,11 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 			font-size: 13px;
 			white-space: nowrap;
 		}
+		.sidecars {
+			color: #666;
+			font-size: 12px;
+			font-family: monospace;
+		}
 		.actions {
 			display: flex;
 			gap: 5px;
@@ -153,7 +187,14 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 			background: #dc3545;
 			color: white;
 		}
-		button:disabled {
+		select {
+			padding: 4px;
+			border: 1px solid #ddd;
+			border-radius: 4px;
+			font-size: 12px;
+			max-width: 160px;
+		}
+		button:disabled, select:disabled {
 			opacity: 0.5;
 			cursor: not-allowed;
 		}
@@ -178,6 +219,59 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 		.toast.error {
 			background: #dc3545;
 		}
+		.batch-bar {
+			position: sticky;
+			top: 0;
+			z-index: 10;
+			display: flex;
+			align-items: center;
+			flex-wrap: wrap;
+			gap: 8px;
+			padding: 10px;
+			margin-bottom: 15px;
+			background: white;
+			border-radius: 8px;
+			box-shadow: 0 1px 3px rgba(0,0,0,0.1);
+			font-size: 13px;
+		}
+		.batch-bar input[type=text] {
+			padding: 4px;
+			border: 1px solid #ddd;
+			border-radius: 4px;
+			font-size: 12px;
+			width: 240px;
+		}
+		.batch-report {
+			background: white;
+			border-radius: 8px;
+			padding: 15px;
+			margin-bottom: 15px;
+			box-shadow: 0 1px 3px rgba(0,0,0,0.1);
+		}
+		.batch-report h3 {
+			margin: 0 0 10px 0;
+			font-size: 16px;
+		}
+		.batch-report table {
+			width: 100%%;
+			border-collapse: collapse;
+			margin-bottom: 10px;
+			font-size: 12px;
+		}
+		.batch-report td {
+			padding: 4px 8px;
+			border-bottom: 1px solid #eee;
+			word-break: break-all;
+		}
+		.batch-report td.path {
+			font-family: monospace;
+		}
+		.batch-report .error {
+			color: #dc3545;
+		}
+		.batch-report .skipped {
+			color: #999;
+		}
 		.summary {
 			color: #666;
 			margin-bottom: 20px;
@@ -215,10 +309,33 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 		<button class="shutdown-btn" onclick="shutdown()">Shutdown Server</button>
 	</header>
 	<p class="summary">Found %d duplicate groups (showing page %d of %d)</p>
+	<div class="batch-bar">
+		<span id="selection-count">0 selected</span>
+		<button onclick="selectAll()">Select all in view (%d files)</button>
+		<button onclick="clearSelection()">Clear</button>
+		<select id="batch-action" onchange="batchActionChanged()">
+			<option value="trash">Move to Trash</option>
+			<option value="move">Move to folder</option>
+			<option value="keep">Keep one per group, trash the rest</option>
+		</select>
+		<input id="batch-dest" type="text" placeholder="Folder to move into" hidden>
+		<select id="batch-rule" title="Rule choosing the file to keep" hidden>%s
+		</select>
+		<button onclick="previewBatch()">Preview</button>
+	</div>
+	<div id="batch-report" class="batch-report" hidden>
+		<h3 id="batch-title"></h3>
+		<table><tbody id="batch-results"></tbody></table>
+		<button id="batch-apply" class="delete" onclick="applyBatch()">Apply</button>
+		<button onclick="closeReport()">Close</button>
+	</div>
 	%s
 	%s
 	<div id="toast" class="toast"></div>
 	<script>
+		const token = '%s';
+		const totalFiles = %d;
+
 		function showToast(message, type) {
 			const toast = document.getElementById('toast');
 			toast.textContent = message;
@@ -228,12 +345,12 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 			}, 3000);
 		}
 
-		async function apiCall(endpoint, path) {
+		async function apiCall(endpoint, body) {
 			try {
 				const response = await fetch('/api/' + endpoint, {
 					method: 'POST',
-					headers: { 'Content-Type': 'application/json' },
-					body: JSON.stringify({ path: path })
+					headers: { 'Content-Type': 'application/json', 'X-Fdup-Token': token },
+					body: JSON.stringify(body)
 				});
 				return await response.json();
 			} catch (e) {
@@ -241,8 +358,10 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 			}
 		}
 
-		async function openFile(path) {
-			const result = await apiCall('open', path);
+		// Actions read the file from the data attributes of its entry, so
+		// paths never end up in script
+		async function openFile(button) {
+			const result = await apiCall('open', { path: button.closest('li').dataset.path });
 			if (result.status === 'ok') {
 				showToast('File opened', 'success');
 			} else {
@@ -250,8 +369,8 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 			}
 		}
 
-		async function revealFile(path) {
-			const result = await apiCall('reveal', path);
+		async function revealFile(button) {
+			const result = await apiCall('reveal', { path: button.closest('li').dataset.path });
 			if (result.status === 'ok') {
 				showToast('Revealed in Finder', 'success');
 			} else {
@@ -259,29 +378,209 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 			}
 		}
 
-		async function deleteFile(path, groupIdx, fileIdx) {
+		async function deleteFile(button) {
+			const li = button.closest('li');
+			const path = li.dataset.path;
 			if (!confirm('Move this file to Trash?\\n\\n' + path)) {
 				return;
 			}
 
-			const result = await apiCall('delete', path);
+			const result = await apiCall('delete', { path: path });
 			if (result.status === 'ok') {
-				const li = document.getElementById('file-' + groupIdx + '-' + fileIdx);
-				if (li) {
-					li.classList.add('deleted');
-					li.querySelectorAll('button').forEach(btn => btn.disabled = true);
-				}
+				markDone(li, 'deleted');
 				showToast('Moved to Trash', 'success');
 			} else {
 				showToast('Error: ' + result.message, 'error');
 			}
 		}
 
+		async function moveFile(select) {
+			const li = select.closest('li');
+			const path = li.dataset.path;
+			let dest = select.value;
+			select.value = '';
+			if (dest === 'custom') {
+				dest = prompt('Move to folder:\n\n' + path);
+			}
+			if (!dest || !confirm('Move this file?\n\n' + path + '\n\nto ' + dest)) {
+				return;
+			}
+
+			const result = await apiCall('move', { path: path, dest: dest });
+			if (result.status === 'ok') {
+				li.querySelector('.path').textContent = result.path;
+				markDone(li, 'moved');
+				showToast('Moved to ' + dest, 'success');
+			} else {
+				showToast('Error: ' + result.message, 'error');
+			}
+		}
+
+		async function keepFile(button) {
+			const li = button.closest('li');
+			const path = li.dataset.path;
+			if (!confirm('Keep this file and move the other files of the group to Trash?\n\n' + path)) {
+				return;
+			}
+
+			const result = await apiCall('keep', { code: li.dataset.code, path: path });
+			if (result.status === 'ok') {
+				const trashed = new Set(result.trashed);
+				li.closest('.group').querySelectorAll('li[data-path]').forEach(other => {
+					if (trashed.has(other.dataset.path)) {
+						markDone(other, 'deleted');
+					}
+				});
+				showToast(result.message, 'success');
+			} else {
+				showToast('Error: ' + result.message, 'error');
+			}
+		}
+
+		// The selection spans pages: either the listed paths, or every
+		// file in the view except the excluded ones
+		const selectionKey = 'fdup-selection-' + token;
+		let selection = JSON.parse(sessionStorage.getItem(selectionKey) || 'null') || { all: false, paths: [], exclude: [] };
+
+		function isSelected(path) {
+			return selection.all ? !selection.exclude.includes(path) : selection.paths.includes(path);
+		}
+
+		function saveSelection() {
+			sessionStorage.setItem(selectionKey, JSON.stringify(selection));
+			updateSelection();
+		}
+
+		function updateSelection() {
+			document.querySelectorAll('li[data-path] input.select').forEach(box => {
+				box.checked = isSelected(box.closest('li').dataset.path);
+			});
+			const count = selection.all ? totalFiles - selection.exclude.length : selection.paths.length;
+			document.getElementById('selection-count').textContent = count + ' selected';
+		}
+
+		function toggleFile(box) {
+			const path = box.closest('li').dataset.path;
+			const list = selection.all ? selection.exclude : selection.paths;
+			const i = list.indexOf(path);
+			// Listed paths are selected, or deselected when all are selected
+			if (box.checked !== selection.all) {
+				if (i < 0) {
+					list.push(path);
+				}
+			} else if (i >= 0) {
+				list.splice(i, 1);
+			}
+			saveSelection();
+		}
+
+		function selectAll() {
+			selection = { all: true, paths: [], exclude: [] };
+			saveSelection();
+		}
+
+		function clearSelection() {
+			selection = { all: false, paths: [], exclude: [] };
+			saveSelection();
+		}
+
+		function batchActionChanged() {
+			const action = document.getElementById('batch-action').value;
+			document.getElementById('batch-dest').hidden = action !== 'move';
+			document.getElementById('batch-rule').hidden = action !== 'keep';
+		}
+
+		function batchRequest(preview) {
+			const action = document.getElementById('batch-action').value;
+			const body = { action: action, all: selection.all, paths: selection.paths, exclude: selection.exclude, preview: preview };
+			if (action === 'move') {
+				body.dest = document.getElementById('batch-dest').value;
+			}
+			if (action === 'keep') {
+				body.rules = [document.getElementById('batch-rule').value];
+			}
+			return body;
+		}
+
+		async function previewBatch() {
+			showReport(await apiCall('batch', batchRequest(true)));
+		}
+
+		async function applyBatch() {
+			const result = await apiCall('batch', batchRequest(false));
+			showReport(result);
+			if (result.status !== 'ok') {
+				return;
+			}
+			const states = { trash: 'deleted', move: 'moved' };
+			const done = new Map();
+			result.results.forEach(r => {
+				if (r.status === 'ok' && states[r.action]) {
+					done.set(r.path, states[r.action]);
+				}
+			});
+			document.querySelectorAll('li[data-path]').forEach(li => {
+				if (done.has(li.dataset.path)) {
+					markDone(li, done.get(li.dataset.path));
+				}
+			});
+			clearSelection();
+		}
+
+		function showReport(result) {
+			if (result.status !== 'ok') {
+				showToast('Error: ' + result.message, 'error');
+				return;
+			}
+			const title = result.preview ? 'Preview: ' + result.done + ' to do' : 'Result: ' + result.done + ' done';
+			document.getElementById('batch-title').textContent =
+				title + ', ' + result.skipped + ' skipped, ' + result.failed + ' failed';
+
+			const rows = document.getElementById('batch-results');
+			rows.replaceChildren();
+			result.results.forEach(r => {
+				const tr = document.createElement('tr');
+				tr.className = r.status;
+				let target = r.path;
+				if (r.dest) {
+					target += ' -> ' + r.dest;
+				}
+				if (r.sidecars) {
+					target += ' (with ' + r.sidecars.map(p => p.split('/').pop()).join(', ') + ')';
+				}
+				[r.status, r.action, target, r.error || ''].forEach((text, i) => {
+					const td = document.createElement('td');
+					td.textContent = text;
+					if (i === 2) {
+						td.className = 'path';
+					}
+					tr.appendChild(td);
+				});
+				rows.appendChild(tr);
+			});
+
+			document.getElementById('batch-apply').hidden = !result.preview || result.done === 0;
+			document.getElementById('batch-report').hidden = false;
+		}
+
+		function closeReport() {
+			document.getElementById('batch-report').hidden = true;
+		}
+
+		updateSelection();
+
+		function markDone(li, state) {
+			if (li) {
+				li.classList.add(state);
+				li.querySelectorAll('button, select, input').forEach(el => el.disabled = true);
+			}
+		}
+
 		async function shutdown() {
 			if (!confirm('Shutdown the server?')) {
 				return;
 			}
-			await fetch('/api/shutdown', { method: 'POST' });
+			await fetch('/api/shutdown', { method: 'POST', headers: { 'X-Fdup-Token': token } });
 			showToast('Server shutting down...', 'success');
 			setTimeout(() => {
 				document.body.innerHTML = '<h1 style="text-align:center;margin-top:100px;">Server stopped. You can close this tab.</h1>';
@@ -289,10 +588,68 @@ func (s *Server) renderHTML(duplicateGroups []db.DuplicateGroup, currentPage, to
 		}
 	</script>
 </body>
-</html>`, totalGroups, currentPage, totalPages, groups.String(), renderPagination(currentPage, totalPages))
+</html>`, totalGroups, currentPage, totalPages, totalFiles, renderRuleOptions(), groups.String(), renderPagination(currentPage, totalPages, s.token), escapeJS(s.token), totalFiles)
 }
 
-func renderPagination(currentPage, totalPages int) string {
+// renderFile renders a file of a group with the sidecars that are trashed
+// along with it. dirs are the folders of the group's copies, which the file
+// can be moved to.
+func renderFile(file db.FileRecord, sidecars []string, groupCode string, dirs []string) string {
+	var sidecarInfo string
+	if len(sidecars) > 0 {
+		names := make([]string, len(sidecars))
+		for i, sc := range sidecars {
+			names[i] = filepath.Base(sc)
+		}
+		sidecarInfo = fmt.Sprintf(`
+					<span class="sidecars">with %s</span>`, escapeHTML(strings.Join(names, ", ")))
+	}
+	var moveOptions strings.Builder
+	for _, dir := range dirs {
+		if dir != filepath.Dir(file.Path) {
+			moveOptions.WriteString(fmt.Sprintf(`
+							<option value="%s">%s</option>`, escapeHTML(dir), escapeHTML(dir)))
+		}
+	}
+	return fmt.Sprintf(`
+				<li data-path="%s" data-code="%s">
+					<input type="checkbox" class="select" onchange="toggleFile(this)" title="Select for batch actions">
+					<span class="path">%s</span>%s
+					<span class="size">%s</span>
+					<div class="actions">
+						<button onclick="openFile(this)" title="Open file">Open</button>
+						<button onclick="revealFile(this)" title="Reveal in Finder">Finder</button>
+						<select onchange="moveFile(this)" title="Move to another folder">
+							<option value="">Move to...</option>%s
+							<option value="custom">Other folder...</option>
+						</select>
+						<button onclick="keepFile(this)" title="Keep this file and move the rest of the group to Trash">Keep</button>
+						<button onclick="deleteFile(this)" class="delete" title="Move to Trash">Delete</button>
+					</div>
+				</li>`,
+		escapeHTML(file.Path), escapeHTML(groupCode),
+		escapeHTML(file.Path),
+		sidecarInfo,
+		formatSize(file.Size),
+		moveOptions.String())
+}
+
+// renderRuleOptions renders the keep rules the batch keep action offers.
+// The preferred rule needs directories, so it is left out.
+func renderRuleOptions() string {
+	var b strings.Builder
+	for _, rule := range resolve.Rules {
+		if rule != resolve.Preferred {
+			b.WriteString(fmt.Sprintf(`
+			<option value="%s">%s</option>`, rule, rule))
+		}
+	}
+	return b.String()
+}
+
+// renderPagination renders links to the other pages, which carry the
+// session token.
+func renderPagination(currentPage, totalPages int, token string) string {
 	if totalPages <= 1 {
 		return ""
 	}
@@ -302,7 +659,7 @@ func renderPagination(currentPage, totalPages int) string {
 
 	// Previous
 	if currentPage > 1 {
-		b.WriteString(fmt.Sprintf(`<a href="?page=%d">&laquo; Prev</a>`, currentPage-1))
+		b.WriteString(fmt.Sprintf(`<a href="%s">&laquo; Prev</a>`, pageLink(currentPage-1, token)))
 	} else {
 		b.WriteString(`<span class="disabled">&laquo; Prev</span>`)
 	}
@@ -312,7 +669,7 @@ func renderPagination(currentPage, totalPages int) string {
 		if i == currentPage {
 			b.WriteString(fmt.Sprintf(`<span class="current">%d</span>`, i))
 		} else if i == 1 || i == totalPages || (i >= currentPage-2 && i <= currentPage+2) {
-			b.WriteString(fmt.Sprintf(`<a href="?page=%d">%d</a>`, i, i))
+			b.WriteString(fmt.Sprintf(`<a href="%s">%d</a>`, pageLink(i, token), i))
 		} else if i == currentPage-3 || i == currentPage+3 {
 			b.WriteString(`<span>...</span>`)
 		}
@@ -320,7 +677,7 @@ func renderPagination(currentPage, totalPages int) string {
 
 	// Next
 	if currentPage < totalPages {
-		b.WriteString(fmt.Sprintf(`<a href="?page=%d">Next &raquo;</a>`, currentPage+1))
+		b.WriteString(fmt.Sprintf(`<a href="%s">Next &raquo;</a>`, pageLink(currentPage+1, token)))
 	} else {
 		b.WriteString(`<span class="disabled">Next &raquo;</span>`)
 	}
@@ -329,11 +686,20 @@ func renderPagination(currentPage, totalPages int) string {
 	return b.String()
 }
 
+// pageLink returns the URL of a page of the index.
+func pageLink(page int, token string) string {
+	if token == "" {
+		return fmt.Sprintf("?page=%d", page)
+	}
+	return escapeHTML(fmt.Sprintf("?page=%d&token=%s", page, url.QueryEscape(token)))
+}
+
 func escapeHTML(s string) string {
 	s = strings.ReplaceAll(s, "&", "&amp;")
 	s = strings.ReplaceAll(s, "<", "&lt;")
 	s = strings.ReplaceAll(s, ">", "&gt;")
 	s = strings.ReplaceAll(s, `"`, "&quot;")
+	s = strings.ReplaceAll(s, "'", "&#39;")
 	return s
 }
 